@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/golang/glog"
+
+	"github.com/wos-project/wos-core-go/app/models"
+)
+
+type reqApiKeyCreate struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type respApiKey struct {
+	Id         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Key        string     `json:"key,omitempty"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+type respApiKeys struct {
+	ApiKeys []respApiKey `json:"apiKeys"`
+}
+
+func (r *respApiKey) MarshalFromApiKey(k *models.ApiKey) {
+	r.Id = k.ID
+	r.Name = k.Name
+	r.Prefix = k.Prefix
+	r.Scopes = k.ScopeList()
+	r.ExpiresAt = k.ExpiresAt
+	r.RevokedAt = k.RevokedAt
+	r.LastUsedAt = k.LastUsedAt
+}
+
+// HandleApiKeyCreate godoc
+// @Summary HandleApiKeyCreate mints a new api key.  The key is only returned here, only its hash is stored.
+// @Accept json
+// @Produce json
+// @Param App-Key header string true "Application key header with admin scope"
+// @Param json body reqApiKeyCreate required "key name, scopes and optional expiry"
+// @Success 200 object respApiKey success "new api key"
+// @Failure 400 {string} error "Request params wrong"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 500 {string} error "Internal error"
+// @Router /admin/apiKey [post]
+func HandleApiKeyCreate(c *gin.Context) {
+
+	var request reqApiKeyCreate
+	if err := c.ShouldBindBodyWith(&request, binding.JSON); err != nil {
+		glog.Errorf("cannot unmarshall api key create %v", err)
+		c.JSON(400, gin.H{"error": ""})
+		return
+	}
+	for _, s := range request.Scopes {
+		if !models.ValidApiKeyScope(s) {
+			glog.Errorf("unknown api key scope %s", s)
+			c.JSON(400, gin.H{"error": ""})
+			return
+		}
+	}
+
+	clear, key, err := models.CreateApiKey(request.Name, request.Scopes, request.ExpiresAt)
+	if err != nil {
+		glog.Errorf("cannot create api key %v", err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+
+	var resp respApiKey
+	resp.MarshalFromApiKey(key)
+	resp.Key = clear
+	c.JSON(200, resp)
+}
+
+// HandleApiKeysGet godoc
+// @Summary HandleApiKeysGet lists api keys, without the keys themselves
+// @Produce json
+// @Param App-Key header string true "Application key header with admin scope"
+// @Success 200 object respApiKeys success "api keys"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 500 {string} error "Internal error"
+// @Router /admin/apiKeys [get]
+func HandleApiKeysGet(c *gin.Context) {
+
+	var keys []models.ApiKey
+	res := models.Db.Order("id").Find(&keys)
+	if res.Error != nil {
+		glog.Errorf("cannot list api keys %v", res.Error)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+
+	resp := respApiKeys{ApiKeys: make([]respApiKey, len(keys))}
+	for i, k := range keys {
+		resp.ApiKeys[i].MarshalFromApiKey(&k)
+	}
+	c.JSON(200, resp)
+}
+
+// HandleApiKeyRevoke godoc
+// @Summary HandleApiKeyRevoke revokes an api key
+// @Produce json
+// @Param App-Key header string true "Application key header with admin scope"
+// @Param id path string true "api key id"
+// @Success 200 {string} success ""
+// @Failure 400 {string} error "Request params wrong"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 451 {string} error "Cannot find api key"
+// @Failure 500 {string} error "Internal error"
+// @Router /admin/apiKey/{id} [delete]
+func HandleApiKeyRevoke(c *gin.Context) {
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		glog.Errorf("api key id parameter wrong %s", c.Param("id"))
+		c.JSON(400, gin.H{"error": ""})
+		return
+	}
+
+	var key models.ApiKey
+	res := models.Db.First(&key, id)
+	if res.Error != nil {
+		glog.Errorf("cannot find api key %d %v", id, res.Error)
+		c.JSON(451, gin.H{"error": ""})
+		return
+	}
+
+	now := time.Now()
+	key.RevokedAt = &now
+	res = models.Db.Save(&key)
+	if res.Error != nil {
+		glog.Errorf("cannot revoke api key %d %v", id, res.Error)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	c.JSON(200, "")
+}
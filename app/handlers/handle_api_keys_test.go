@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApiKeys(t *testing.T) {
+
+	router := SetupRouter()
+	v := "/" + viper.GetString("apiVersion")
+
+	// no key, bad key
+	w := PerformRequestWithApiKey(router, "POST", v+"/object/batchUpload", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = PerformRequestWithApiKey(router, "POST", v+"/object/batchUpload", "", "12345678.nope")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// mint a key that can only write objects
+	w = PerformRequest(router, "POST", "/admin/apiKey", `{"name": "writer", "scopes": ["object:write"]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var key respApiKey
+	err := json.Unmarshal([]byte(w.Body.String()), &key)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, key.Key)
+	assert.Equal(t, []string{"object:write"}, key.Scopes)
+
+	// unknown scope
+	w = PerformRequest(router, "POST", "/admin/apiKey", `{"name": "bad", "scopes": ["everything"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// scoped key works for its scope only
+	w = PerformRequestWithApiKey(router, "POST", v+"/object/batchUpload", "", key.Key)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = PerformRequestWithApiKey(router, "GET", v+"/transaction/queue", "", key.Key)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = PerformRequestWithApiKey(router, "GET", v+"/admin/apiKeys", "", key.Key)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// list does not leak keys
+	w = PerformRequest(router, "GET", "/admin/apiKeys", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var keys respApiKeys
+	err = json.Unmarshal([]byte(w.Body.String()), &keys)
+	assert.Nil(t, err)
+	assert.True(t, len(keys.ApiKeys) >= 2)
+	for _, k := range keys.ApiKeys {
+		assert.Empty(t, k.Key)
+	}
+
+	// revoked key stops working
+	w = PerformRequest(router, "DELETE", fmt.Sprintf("/admin/apiKey/%d", key.Id), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = PerformRequestWithApiKey(router, "POST", v+"/object/batchUpload", "", key.Key)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// the deprecated shared key keeps its scopes until it is removed from the config
+	viper.Set("auth.apiKey.value", "legacy-shared-key")
+	defer viper.Set("auth.apiKey.value", "")
+	w = PerformRequestWithApiKey(router, "GET", v+"/transactions", "", "legacy-shared-key")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = PerformRequestWithApiKey(router, "GET", v+"/admin/apiKeys", "", "legacy-shared-key")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = PerformRequestWithApiKey(router, "GET", v+"/transactions", "", "legacy-shared-nope")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	viper.Set("auth.apiKey.legacyScopes", []string{"object:write"})
+	defer viper.Set("auth.apiKey.legacyScopes", nil)
+	w = PerformRequestWithApiKey(router, "GET", v+"/transactions", "", "legacy-shared-key")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	viper.Set("auth.apiKey.value", "")
+	w = PerformRequestWithApiKey(router, "GET", v+"/transactions", "", "legacy-shared-key")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
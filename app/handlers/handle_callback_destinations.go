@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+
+	"github.com/wos-project/wos-core-go/app/models"
+)
+
+const defaultCallbackSecretGraceSecs = 86400
+
+type reqCallbackDestinationCreate struct {
+	Name string `json:"name" binding:"required"`
+	Url  string `json:"url" binding:"required"`
+}
+
+type respCallbackDestination struct {
+	Id                      uint       `json:"id"`
+	Name                    string     `json:"name"`
+	Url                     string     `json:"url"`
+	Secret                  string     `json:"secret,omitempty"`
+	PreviousSecretExpiresAt *time.Time `json:"previousSecretExpiresAt,omitempty"`
+	DisabledAt              *time.Time `json:"disabledAt,omitempty"`
+	LegacyAppKey            bool       `json:"legacyAppKey,omitempty"`
+	CreatedAt               time.Time  `json:"createdAt"`
+}
+
+type respCallbackDestinations struct {
+	CallbackDestinations []respCallbackDestination `json:"callbackDestinations"`
+}
+
+func (r *respCallbackDestination) MarshalFromCallbackDestination(d *models.CallbackDestination) {
+	r.Id = d.ID
+	r.Name = d.Name
+	r.Url = d.Url
+	r.PreviousSecretExpiresAt = d.PreviousSecretExpiresAt
+	r.DisabledAt = d.DisabledAt
+	r.LegacyAppKey = d.LegacyAppKey
+	r.CreatedAt = d.CreatedAt
+}
+
+// findCallbackDestination returns the destination with the id path param, or an HTTP code on error
+func findCallbackDestination(c *gin.Context) (*models.CallbackDestination, int) {
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		glog.Errorf("callback destination id parameter wrong %s", c.Param("id"))
+		return nil, 400
+	}
+	var d models.CallbackDestination
+	res := models.Db.First(&d, id)
+	if res.Error != nil {
+		glog.Errorf("cannot find callback destination %d %v", id, res.Error)
+		return nil, 451
+	}
+	return &d, 200
+}
+
+// HandleCallbackDestinationCreate godoc
+// @Summary HandleCallbackDestinationCreate registers a receiver of transaction callbacks.  The signing secret is only returned here and when rotated.
+// @Accept json
+// @Produce json
+// @Param App-Key header string true "Application key header with admin scope"
+// @Param json body reqCallbackDestinationCreate required "name and URL that callback URIs must be under"
+// @Success 200 object respCallbackDestination success "new callback destination with secret"
+// @Failure 400 {string} error "Request params wrong"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 500 {string} error "Internal error"
+// @Router /admin/callbackDestination [post]
+func HandleCallbackDestinationCreate(c *gin.Context) {
+
+	var request reqCallbackDestinationCreate
+	if err := c.ShouldBindBodyWith(&request, binding.JSON); err != nil {
+		glog.Errorf("cannot unmarshall callback destination create %v", err)
+		c.JSON(400, gin.H{"error": ""})
+		return
+	}
+
+	d, err := models.CreateCallbackDestination(request.Name, request.Url)
+	if err != nil {
+		glog.Errorf("cannot create callback destination %v", err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var resp respCallbackDestination
+	resp.MarshalFromCallbackDestination(d)
+	resp.Secret = d.Secret
+	c.JSON(200, resp)
+}
+
+// HandleCallbackDestinationsGet godoc
+// @Summary HandleCallbackDestinationsGet lists callback destinations, without their secrets
+// @Produce json
+// @Param App-Key header string true "Application key header with admin scope"
+// @Success 200 object respCallbackDestinations success "callback destinations"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 500 {string} error "Internal error"
+// @Router /admin/callbackDestinations [get]
+func HandleCallbackDestinationsGet(c *gin.Context) {
+
+	var destinations []models.CallbackDestination
+	res := models.Db.Order("id").Find(&destinations)
+	if res.Error != nil {
+		glog.Errorf("cannot list callback destinations %v", res.Error)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+
+	resp := respCallbackDestinations{CallbackDestinations: make([]respCallbackDestination, len(destinations))}
+	for i, d := range destinations {
+		resp.CallbackDestinations[i].MarshalFromCallbackDestination(&d)
+	}
+	c.JSON(200, resp)
+}
+
+// HandleCallbackDestinationRotateSecret godoc
+// @Summary HandleCallbackDestinationRotateSecret replaces the signing secret, callbacks are signed with both for transactions.callbackSecretGraceSecs
+// @Produce json
+// @Param App-Key header string true "Application key header with admin scope"
+// @Param id path string true "callback destination id"
+// @Success 200 object respCallbackDestination success "callback destination with new secret"
+// @Failure 400 {string} error "Request params wrong"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 451 {string} error "Cannot find callback destination"
+// @Failure 500 {string} error "Internal error"
+// @Router /admin/callbackDestination/{id}/rotateSecret [post]
+func HandleCallbackDestinationRotateSecret(c *gin.Context) {
+
+	d, code := findCallbackDestination(c)
+	if d == nil {
+		c.JSON(code, gin.H{"error": ""})
+		return
+	}
+
+	grace := viper.GetInt("transactions.callbackSecretGraceSecs")
+	if grace <= 0 {
+		grace = defaultCallbackSecretGraceSecs
+	}
+	if err := d.RotateSecret(time.Duration(grace) * time.Second); err != nil {
+		glog.Error(err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+
+	var resp respCallbackDestination
+	resp.MarshalFromCallbackDestination(d)
+	resp.Secret = d.Secret
+	c.JSON(200, resp)
+}
+
+// HandleCallbackDestinationDisable godoc
+// @Summary HandleCallbackDestinationDisable disables a callback destination, its pending callbacks fail
+// @Produce json
+// @Param App-Key header string true "Application key header with admin scope"
+// @Param id path string true "callback destination id"
+// @Success 200 {string} success ""
+// @Failure 400 {string} error "Request params wrong"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 451 {string} error "Cannot find callback destination"
+// @Failure 500 {string} error "Internal error"
+// @Router /admin/callbackDestination/{id} [delete]
+func HandleCallbackDestinationDisable(c *gin.Context) {
+
+	d, code := findCallbackDestination(c)
+	if d == nil {
+		c.JSON(code, gin.H{"error": ""})
+		return
+	}
+
+	now := time.Now()
+	d.DisabledAt = &now
+	res := models.Db.Save(d)
+	if res.Error != nil {
+		glog.Errorf("cannot disable callback destination %d %v", d.ID, res.Error)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	c.JSON(200, "")
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/golang/glog"
+
+	"github.com/wos-project/wos-core-go/app/models"
+	"github.com/wos-project/wos-core-go/app/utils"
+)
+
+type reqCampaign struct {
+	Name                  string     `json:"name"`
+	ChainId               string     `json:"chainId"`
+	Budget                string     `json:"budget"`
+	EstimatedCost         string     `json:"estimatedCost"`
+	MaxPerWallet          int        `json:"maxPerWallet"`
+	MaxPerDay             int        `json:"maxPerDay"`
+	MaxTokenQuantity      int        `json:"maxTokenQuantity"`
+	MaxTotalTokenQuantity int        `json:"maxTotalTokenQuantity"`
+	StartsAt              *time.Time `json:"startsAt"`
+	EndsAt                *time.Time `json:"endsAt"`
+}
+
+type respCampaign struct {
+	Id                    uint       `json:"id"`
+	Name                  string     `json:"name"`
+	ChainId               string     `json:"chainId,omitempty"`
+	Budget                string     `json:"budget,omitempty"`
+	Spent                 string     `json:"spent"`
+	EstimatedCost         string     `json:"estimatedCost,omitempty"`
+	MaxPerWallet          int        `json:"maxPerWallet,omitempty"`
+	MaxPerDay             int        `json:"maxPerDay,omitempty"`
+	MaxTokenQuantity      int        `json:"maxTokenQuantity,omitempty"`
+	MaxTotalTokenQuantity int        `json:"maxTotalTokenQuantity,omitempty"`
+	StartsAt              *time.Time `json:"startsAt,omitempty"`
+	EndsAt                *time.Time `json:"endsAt,omitempty"`
+	DisabledAt            *time.Time `json:"disabledAt,omitempty"`
+	Transactions          int64      `json:"transactions"`
+	CreatedAt             time.Time  `json:"createdAt"`
+}
+
+type respCampaigns struct {
+	Campaigns []respCampaign `json:"campaigns"`
+}
+
+func (r *respCampaign) MarshalFromCampaign(c *models.Campaign) {
+	r.Id = c.ID
+	r.Name = c.Name
+	r.ChainId = c.ChainId
+	r.Budget = c.Budget
+	r.Spent = c.Spent
+	r.EstimatedCost = c.EstimatedCost
+	r.MaxPerWallet = c.MaxPerWallet
+	r.MaxPerDay = c.MaxPerDay
+	r.MaxTokenQuantity = c.MaxTokenQuantity
+	r.MaxTotalTokenQuantity = c.MaxTotalTokenQuantity
+	r.StartsAt = c.StartsAt
+	r.EndsAt = c.EndsAt
+	r.DisabledAt = c.DisabledAt
+	r.CreatedAt = c.CreatedAt
+}
+
+// unmarshalToCampaign sets the limits of c from r, the name is only set for new campaigns
+func (r *reqCampaign) unmarshalToCampaign(c *models.Campaign) error {
+	if c.ID == 0 {
+		c.Name = r.Name
+	}
+	if r.ChainId != "" {
+		chains, err := utils.SupportedChains()
+		if err != nil {
+			return err
+		}
+		supported := false
+		for _, chain := range chains {
+			supported = supported || chain.Id == r.ChainId
+		}
+		if !supported {
+			return fmt.Errorf("chain %s is not supported", r.ChainId)
+		}
+	}
+	c.ChainId = r.ChainId
+	c.Budget = r.Budget
+	c.EstimatedCost = r.EstimatedCost
+	c.MaxPerWallet = r.MaxPerWallet
+	c.MaxPerDay = r.MaxPerDay
+	c.MaxTokenQuantity = r.MaxTokenQuantity
+	c.MaxTotalTokenQuantity = r.MaxTotalTokenQuantity
+	c.StartsAt = r.StartsAt
+	c.EndsAt = r.EndsAt
+	return c.Validate()
+}
+
+// findCampaign returns the campaign with the id path param, or an HTTP code on error
+func findCampaign(c *gin.Context) (*models.Campaign, int) {
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		glog.Errorf("campaign id parameter wrong %s", c.Param("id"))
+		return nil, 400
+	}
+	var campaign models.Campaign
+	res := models.Db.First(&campaign, id)
+	if res.Error != nil {
+		glog.Errorf("cannot find campaign %d %v", id, res.Error)
+		return nil, 451
+	}
+	return &campaign, 200
+}
+
+// HandleCampaignCreate godoc
+// @Summary HandleCampaignCreate creates a campaign, transactions enqueued in it are held to its budget and limits
+// @Description The budget is in the smallest unit of the native currency of the chain, like wei, and is compared to
+// @Description the sum of the costs transactors report.  Each transaction not yet reported reserves estimatedCost of
+// @Description the budget, which is required with a budget.  Missing or zero limits are unlimited.
+// @Accept json
+// @Produce json
+// @Param App-Key header string true "Application key header with admin scope"
+// @Param json body reqCampaign required "campaign name, budget, limits and dates"
+// @Success 200 object respCampaign success "new campaign"
+// @Failure 400 {string} error "Request params wrong"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 500 {string} error "Internal error"
+// @Router /admin/campaign [post]
+func HandleCampaignCreate(c *gin.Context) {
+
+	var request reqCampaign
+	if err := c.ShouldBindBodyWith(&request, binding.JSON); err != nil {
+		glog.Errorf("cannot unmarshall campaign create %v", err)
+		c.JSON(400, gin.H{"error": ""})
+		return
+	}
+
+	var campaign models.Campaign
+	if err := request.unmarshalToCampaign(&campaign); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := models.CreateCampaign(&campaign); err != nil {
+		glog.Error(err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var resp respCampaign
+	resp.MarshalFromCampaign(&campaign)
+	c.JSON(200, resp)
+}
+
+// HandleCampaignsGet godoc
+// @Summary HandleCampaignsGet lists campaigns with what they spent and how many transactions they have
+// @Produce json
+// @Param App-Key header string true "Application key header with admin scope"
+// @Success 200 object respCampaigns success "campaigns"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 500 {string} error "Internal error"
+// @Router /admin/campaigns [get]
+func HandleCampaignsGet(c *gin.Context) {
+
+	var campaigns []models.Campaign
+	res := models.Db.Order("id").Find(&campaigns)
+	if res.Error != nil {
+		glog.Errorf("cannot list campaigns %v", res.Error)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	ids := make([]uint, len(campaigns))
+	for i := range campaigns {
+		ids[i] = campaigns[i].ID
+	}
+	counts, err := models.CampaignTransactionCounts(ids)
+	if err != nil {
+		glog.Error(err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+
+	resp := respCampaigns{Campaigns: make([]respCampaign, len(campaigns))}
+	for i := range campaigns {
+		resp.Campaigns[i].MarshalFromCampaign(&campaigns[i])
+		resp.Campaigns[i].Transactions = counts[campaigns[i].ID]
+	}
+	c.JSON(200, resp)
+}
+
+// HandleCampaignUpdate godoc
+// @Summary HandleCampaignUpdate replaces the budget, limits and dates of a campaign, like to raise its budget
+// @Description The name cannot be changed and what the campaign spent is kept.
+// @Accept json
+// @Produce json
+// @Param App-Key header string true "Application key header with admin scope"
+// @Param id path string true "campaign id"
+// @Param json body reqCampaign required "campaign budget, limits and dates"
+// @Success 200 object respCampaign success "updated campaign"
+// @Failure 400 {string} error "Request params wrong"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 451 {string} error "Cannot find campaign"
+// @Failure 500 {string} error "Internal error"
+// @Router /admin/campaign/{id} [put]
+func HandleCampaignUpdate(c *gin.Context) {
+
+	campaign, code := findCampaign(c)
+	if campaign == nil {
+		c.JSON(code, gin.H{"error": ""})
+		return
+	}
+	var request reqCampaign
+	if err := c.ShouldBindBodyWith(&request, binding.JSON); err != nil {
+		glog.Errorf("cannot unmarshall campaign update %v", err)
+		c.JSON(400, gin.H{"error": ""})
+		return
+	}
+	if err := request.unmarshalToCampaign(campaign); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// spent is left out, callbacks add to it concurrently
+	res := models.Db.Model(campaign).Select("chain_id", "budget", "estimated_cost", "max_per_wallet", "max_per_day", "max_token_quantity",
+		"max_total_token_quantity", "starts_at", "ends_at").Updates(campaign)
+	if res.Error != nil {
+		glog.Errorf("cannot update campaign %d %v", campaign.ID, res.Error)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+
+	var resp respCampaign
+	resp.MarshalFromCampaign(campaign)
+	c.JSON(200, resp)
+}
+
+// HandleCampaignDisable godoc
+// @Summary HandleCampaignDisable disables a campaign, it takes no new transactions but those enqueued are still sent
+// @Produce json
+// @Param App-Key header string true "Application key header with admin scope"
+// @Param id path string true "campaign id"
+// @Success 200 {string} success ""
+// @Failure 400 {string} error "Request params wrong"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 451 {string} error "Cannot find campaign"
+// @Failure 500 {string} error "Internal error"
+// @Router /admin/campaign/{id} [delete]
+func HandleCampaignDisable(c *gin.Context) {
+
+	campaign, code := findCampaign(c)
+	if campaign == nil {
+		c.JSON(code, gin.H{"error": ""})
+		return
+	}
+
+	res := models.Db.Model(campaign).Update("disabled_at", time.Now())
+	if res.Error != nil {
+		glog.Errorf("cannot disable campaign %d %v", campaign.ID, res.Error)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	c.JSON(200, "")
+}
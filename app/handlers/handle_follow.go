@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"github.com/wos-project/wos-core-go/app/models"
+)
+
+// HandleUserFollow godoc
+// @Summary HandleUserFollow makes the logged in user a follower of user uid, which lets them see followers only objects
+// @Security JWT
+// @Produce json
+// @Param uid path string true "uid of user to follow"
+// @Success 200 {string} success ""
+// @Failure 400 {string} error "Request params wrong"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 500 {string} error "Internal error"
+// @Router /user/follow/{uid} [post]
+func HandleUserFollow(c *gin.Context) {
+
+	followerUid := ValJWT(c)
+	followeeUid := c.Param("uid")
+	if followeeUid == "" || followeeUid == followerUid {
+		glog.Errorf("follow uid parameter wrong %s", followeeUid)
+		c.JSON(400, gin.H{"error": ""})
+		return
+	}
+
+	err := models.AddFollow(followerUid, followeeUid)
+	if err != nil {
+		glog.Errorf("cannot follow %s %v", followeeUid, err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	c.JSON(200, "")
+}
+
+// HandleUserUnfollow godoc
+// @Summary HandleUserUnfollow stops the logged in user following user uid
+// @Security JWT
+// @Produce json
+// @Param uid path string true "uid of user to unfollow"
+// @Success 200 {string} success ""
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 500 {string} error "Internal error"
+// @Router /user/follow/{uid} [delete]
+func HandleUserUnfollow(c *gin.Context) {
+
+	res := models.Db.Where("follower_uid = ? AND followee_uid = ?", ValJWT(c), c.Param("uid")).Delete(&models.Follow{})
+	if res.Error != nil {
+		glog.Errorf("cannot delete follow %v", res.Error)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	c.JSON(200, "")
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+
+	"github.com/wos-project/wos-core-go/app/models"
+	"github.com/wos-project/wos-core-go/app/utils"
+)
+
+// HandleMediaResizeGet godoc
+// @Summary HandleMediaResizeGet returns an image of an Object resized on demand, resized images are cached
+// @Produce image/jpeg,image/png,image/gif,image/webp
+// @Param cid path string true "object address"
+// @Param path path string true "path of image in object"
+// @Param w query int false "width, one of media.resize.sizes"
+// @Param h query int false "height, one of media.resize.sizes"
+// @Param fit query string false "fit (default) within w x h, or fill it center cropped"
+// @Param fmt query string false "jpeg, png, gif or webp, default keeps the image format"
+// @Success 200 {file} file "resized image"
+// @Success 304 {string} success "not modified"
+// @Failure 400 {string} error "Request params wrong"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 415 {string} error "Not an image"
+// @Failure 451 {string} error "Cannot find object"
+// @Failure 500 {string} error "Internal error"
+// @Router /media/{cid}/{path} [get]
+func HandleMediaResizeGet(c *gin.Context) {
+
+	cid := c.Param("cid")
+	p := strings.TrimPrefix(c.Param("path"), "/")
+	if cid == "" || p == "" || strings.Contains(p, "..") {
+		glog.Errorf("cid or path parameter wrong %s %s", cid, p)
+		c.JSON(400, gin.H{"error": ""})
+		return
+	}
+	variant, err := utils.ParseResizeVariant(c.Query("w"), c.Query("h"), c.Query("fit"), c.Query("fmt"))
+	if err != nil {
+		glog.Errorf("resize parameters wrong %s %s %v", cid, p, err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	obj, _, err := findObjectByCid(cid)
+	if err != nil {
+		c.JSON(451, gin.H{"error": ""})
+		return
+	}
+	viewerUid, _ := jwtUserUid(c)
+	if !canViewObject(obj, viewerUid) {
+		c.JSON(451, gin.H{"error": ""})
+		return
+	}
+
+	key := variant.Key(cid, p)
+	tempDir, err := ioutil.TempDir(viper.GetString("media.uploadTemp.path"), "resize")
+	if err != nil {
+		glog.Errorf("cannot create resize temp dir %v", err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	variantPath := path.Join(tempDir, path.Base(key))
+	err = utils.Cache.Download(variantPath, key)
+	if err != nil {
+
+		// not cached yet, resize the original
+		glog.V(2).Infof("resizing %s %v", key, err)
+		originalPath := path.Join(tempDir, "original")
+		err = utils.Cache.Download(originalPath, path.Join(cid, p))
+		if err != nil {
+			glog.Errorf("cannot download media %s %s %v", cid, p, err)
+			c.JSON(451, gin.H{"error": ""})
+			return
+		}
+		mediaFormat, mediaFormatDetail, err := utils.SniffMediaFormat(originalPath)
+		if err != nil || mediaFormat != utils.MediaFormatImage || mediaFormatDetail == utils.MediaFormatDetailImageHeic {
+			c.JSON(415, gin.H{"error": ""})
+			return
+		}
+		err = variant.Create(c.Request.Context(), originalPath, variantPath)
+		if err != nil {
+			glog.Errorf("cannot resize media %s %v", key, err)
+			c.JSON(500, gin.H{"error": ""})
+			return
+		}
+
+		// failing to cache only costs resizing again
+		err = utils.Cache.Upload(variantPath, key)
+		if err != nil {
+			glog.Errorf("cannot cache resized media %s %v", key, err)
+		}
+	}
+
+	// objects are immutable, so a variant never changes for a cid once it could be made.  Non public ones must not be
+	// kept by shared caches.
+	etag := fmt.Sprintf(`"%s"`, key)
+	visibility := "public"
+	if obj.Privacy != "" && obj.Privacy != models.ObjectPrivacyPublic {
+		visibility = "private"
+	}
+	c.Header("Cache-Control", fmt.Sprintf("%s, max-age=%d, immutable", visibility, viper.GetInt("media.resize.maxAgeSecs")))
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(304)
+		return
+	}
+	c.File(variantPath)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"archive/tar"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -123,6 +125,23 @@ type respObjectSearch struct {
 	Results []respObjectSearchItem `json:"results"`
 }
 
+type respObjectFileUrl struct {
+	Path       string            `json:"path"`
+	Url        string            `json:"url"`
+	Thumbnails map[string]string `json:"thumbnails,omitempty"`
+}
+
+type respObjectUrls struct {
+	Cid       string              `json:"cid"`
+	ExpiresAt time.Time           `json:"expiresAt"`
+	Files     []respObjectFileUrl `json:"files"`
+}
+
+type respObjectFiles struct {
+	Cid   string                 `json:"cid"`
+	Files []*utils.MediaFileInfo `json:"files"`
+}
+
 type respBatchUploadBegin struct {
 	SessionID string `json:"sessionId"`
 }
@@ -166,6 +185,30 @@ func HandleObjectArchiveUploadMultipart(c *gin.Context) {
 		return
 	}
 
+	// check index and owner against logged in user
+	uploaderUid, code, err := checkObjectIndex(c, path.Join(tempDirPath, viper.GetString("media.indexFilename")))
+	if err != nil {
+		glog.Errorf("object index rejected %v", err)
+		c.JSON(code, gin.H{"error": ""})
+		return
+	}
+
+	// check 3d models only reference files in the upload
+	err = utils.CheckModelsInFolder(tempDirPath)
+	if err != nil {
+		glog.Errorf("model rejected %v", err)
+		c.JSON(400, gin.H{"error": ""})
+		return
+	}
+
+	// remove private photo metadata before publishing, IPFS is permanent
+	err = scrubObjectMedia(c.Request.Context(), tempDirPath)
+	if err != nil {
+		glog.Errorf("media rejected %v", err)
+		c.JSON(400, gin.H{"error": ""})
+		return
+	}
+
 	// save to ipfs
 	cid, err := utils.Ipfs.UploadDirectory(tempDirPath)
 	if err != nil {
@@ -175,7 +218,7 @@ func HandleObjectArchiveUploadMultipart(c *gin.Context) {
 	}
 
 	// index
-	err = indexObjectFile(cid, path.Join(tempDirPath, viper.GetString("media.indexFilename")))
+	err = indexObjectFile(cid, path.Join(tempDirPath, viper.GetString("media.indexFilename")), uploaderUid)
 	if err != nil {
 		glog.Errorf("cannot index %v", err)
 		c.JSON(500, gin.H{"error": ""})
@@ -183,18 +226,24 @@ func HandleObjectArchiveUploadMultipart(c *gin.Context) {
 		return
 	}
 
-	// create thumbs
-	err = utils.CreateThumbnailsInFolder(tempDirPath)
+	// create thumbs, conversions and file manifest, they go to the media cache only, not IPFS
+	manifest, err := utils.CreateThumbnailsInFolder(c.Request.Context(), tempDirPath, cid)
 	if err != nil {
 		glog.Errorf("cannot create thumbnails %s %v", tempDirPath, err)
 		c.JSON(500, gin.H{"error": ""})
 		return
 	}
+	err = saveObjectFiles(cid, manifest)
+	if err != nil {
+		glog.Errorf("cannot save file manifest %s %v", cid, err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
 
-	// save to S3
-	err = utils.S3.UploadDirectory(tempDirPath, cid)
+	// save to media cache
+	err = utils.Cache.UploadDirectory(tempDirPath, cid)
 	if err != nil {
-		glog.Errorf("cannot upload to S3 %s %v", cid, err)
+		glog.Errorf("cannot upload to media cache %s %v", cid, err)
 		c.JSON(500, gin.H{"error": ""})
 		return
 	}
@@ -209,6 +258,7 @@ func HandleObjectArchiveUploadMultipart(c *gin.Context) {
 // @Success 200 {string} success ""
 // @Failure 400 {string} error "Request params wrong"
 // @Failure 401 {string} error "Unauthorized"
+// @Failure 451 {string} error "Cannot find object"
 // @Failure 500 {string} error "Internal error"
 // @Router /object/archive/{cid} [get]
 func HandleObjectArchiveGet(c *gin.Context) {
@@ -220,7 +270,16 @@ func HandleObjectArchiveGet(c *gin.Context) {
 		return
 	}
 
-	// TODO: cid exists in database?
+	obj, _, err := findObjectByCid(cid)
+	if err != nil {
+		c.JSON(451, gin.H{"error": ""})
+		return
+	}
+	viewerUid, _ := jwtUserUid(c)
+	if !canViewObject(obj, viewerUid) {
+		c.JSON(451, gin.H{"error": ""})
+		return
+	}
 
 	// create temp folder
 	tempDirPath, err := os.MkdirTemp(viper.GetString("media.uploadTemp.path"), "")
@@ -310,6 +369,14 @@ func HandleObjectIndexPost(c *gin.Context) {
 		return
 	}
 
+	// check index and owner against logged in user
+	uploaderUid, code, err := checkObjectIndex(c, path.Join(tempDirPath, viper.GetString("media.indexFilename")))
+	if err != nil {
+		glog.Errorf("object index rejected %v", err)
+		c.JSON(code, gin.H{"error": ""})
+		return
+	}
+
 	// save to ipfs
 	cid, err := utils.Ipfs.UploadDirectory(tempDirPath)
 	if err != nil {
@@ -319,7 +386,7 @@ func HandleObjectIndexPost(c *gin.Context) {
 	}
 
 	// index
-	err = indexObjectFile(cid, path.Join(tempDirPath, viper.GetString("media.indexFilename")))
+	err = indexObjectFile(cid, path.Join(tempDirPath, viper.GetString("media.indexFilename")), uploaderUid)
 	if err != nil {
 		glog.Errorf("cannot index %v", err)
 		c.JSON(500, gin.H{"error": ""})
@@ -327,10 +394,24 @@ func HandleObjectIndexPost(c *gin.Context) {
 		return
 	}
 
-	// save to S3
-	err = utils.S3.UploadDirectory(tempDirPath, cid)
+	// file manifest, just the index
+	manifest, err := utils.CreateThumbnailsInFolder(c.Request.Context(), tempDirPath, cid)
+	if err != nil {
+		glog.Errorf("cannot create file manifest %s %v", tempDirPath, err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	err = saveObjectFiles(cid, manifest)
+	if err != nil {
+		glog.Errorf("cannot save file manifest %s %v", cid, err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+
+	// save to media cache
+	err = utils.Cache.UploadDirectory(tempDirPath, cid)
 	if err != nil {
-		glog.Errorf("cannot upload to S3 %s %v", cid, err)
+		glog.Errorf("cannot upload to media cache %s %v", cid, err)
 		c.JSON(500, gin.H{"error": ""})
 		//TODO: delete from IPFS
 		return
@@ -360,26 +441,200 @@ func HandleObjectIndexGet(c *gin.Context) {
 	}
 
 	// find object, whether is arc, pin, pinned_arc
-	var arc models.Arc
-	res := models.Db.Where("cid = ?", cid).First(&arc)
-	if res.RowsAffected == 1 {
-		c.JSON(200, arc.Body)
+	obj, _, err := findObjectByCid(cid)
+	if err != nil {
+		c.JSON(451, gin.H{"error": ""})
+		return
+	}
+
+	// objects the viewer may not see are reported as not found
+	viewerUid, _ := jwtUserUid(c)
+	if !canViewObject(obj, viewerUid) {
+		c.JSON(451, gin.H{"error": ""})
+		return
+	}
+
+	c.JSON(200, obj.Body)
+}
+
+// HandleObjectMediaGet godoc
+// @Summary HandleObjectMediaGet redirects to an expiring URL for a media file of an Object
+// @Produce json
+// @Param cid path string true "object address"
+// @Param path path string true "path of media file in object"
+// @Success 307 {string} success "redirect to media"
+// @Failure 400 {string} error "Request params wrong"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 451 {string} error "Cannot find object"
+// @Failure 500 {string} error "Internal error"
+// @Router /object/{cid}/media/{path} [get]
+func HandleObjectMediaGet(c *gin.Context) {
+
+	cid := c.Param("cid")
+	p := strings.TrimPrefix(c.Param("path"), "/")
+	if cid == "" || p == "" || strings.Contains(p, "..") {
+		glog.Errorf("cid or path parameter wrong %s %s", cid, p)
+		c.JSON(400, gin.H{"error": ""})
 		return
 	}
-	var pin models.Pin
-	res = models.Db.Where("cid = ?", cid).First(&pin)
-	if res.RowsAffected == 1 {
-		c.JSON(200, pin.Body)
+
+	obj, _, err := findObjectByCid(cid)
+	if err != nil {
+		c.JSON(451, gin.H{"error": ""})
 		return
 	}
-	var pa models.PinnedArc
-	res = models.Db.Where("cid = ?", cid).First(&pa)
-	if res.RowsAffected == 1 {
-		c.JSON(200, pa.Body)
+	viewerUid, _ := jwtUserUid(c)
+	if !canViewObject(obj, viewerUid) {
+		c.JSON(451, gin.H{"error": ""})
 		return
 	}
 
-	c.JSON(451, gin.H{"error": ""})
+	// media in the cache is only served through expiring URLs
+	u, _, err := utils.Cache.GetExpiringURL(path.Join(cid, p))
+	if err != nil {
+		glog.Errorf("cannot get expiring url %s %s %v", cid, p, err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	c.Redirect(http.StatusTemporaryRedirect, u)
+}
+
+// HandleObjectUrlsGet godoc
+// @Summary HandleObjectUrlsGet returns expiring, signed URLs for every file in an Object, with thumbnail URLs per file
+// @Produce json
+// @Param cid path string true "object address"
+// @Success 200 object respObjectUrls success "signed URLs"
+// @Failure 400 {string} error "Request params wrong"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 451 {string} error "Cannot find object"
+// @Failure 500 {string} error "Internal error"
+// @Router /object/{cid}/urls [get]
+func HandleObjectUrlsGet(c *gin.Context) {
+
+	cid := c.Param("cid")
+	if cid == "" {
+		glog.Errorf("cid parameter missing")
+		c.JSON(400, gin.H{"error": ""})
+		return
+	}
+
+	obj, _, err := findObjectByCid(cid)
+	if err != nil {
+		c.JSON(451, gin.H{"error": ""})
+		return
+	}
+	viewerUid, _ := jwtUserUid(c)
+	if !canViewObject(obj, viewerUid) {
+		c.JSON(451, gin.H{"error": ""})
+		return
+	}
+
+	files, err := utils.Cache.ListFiles(cid)
+	if err != nil {
+		glog.Errorf("cannot list object files %s %v", cid, err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+
+	resp := respObjectUrls{
+		Cid:       cid,
+		ExpiresAt: time.Now().Add(utils.Cache.UrlExpiry()),
+		Files:     []respObjectFileUrl{},
+	}
+
+	// originals first, then thumbnails are attached to their originals
+	index := map[string]int{}
+	baseOriginals := map[string]string{}
+	for _, f := range files {
+		if _, format := utils.SplitThumbnailName(f); format != "" {
+			continue
+		}
+		u, _, err := utils.Cache.GetExpiringURL(path.Join(cid, f))
+		if err != nil {
+			glog.Errorf("cannot get expiring url %s %s %v", cid, f, err)
+			c.JSON(500, gin.H{"error": ""})
+			return
+		}
+		index[f] = len(resp.Files)
+		baseOriginals[strings.TrimSuffix(f, path.Ext(f))] = f
+		resp.Files = append(resp.Files, respObjectFileUrl{Path: f, Url: u})
+	}
+	for _, f := range files {
+		original, format := utils.SplitThumbnailName(f)
+		if format == "" {
+			continue
+		}
+		i, ok := index[original]
+		if !ok {
+			// video posters, and model previews of older objects, have their own suffix
+			i, ok = index[baseOriginals[strings.TrimSuffix(original, path.Ext(original))]]
+		}
+		if !ok {
+			continue
+		}
+		u, _, err := utils.Cache.GetExpiringURL(path.Join(cid, f))
+		if err != nil {
+			glog.Errorf("cannot get expiring url %s %s %v", cid, f, err)
+			c.JSON(500, gin.H{"error": ""})
+			return
+		}
+		if resp.Files[i].Thumbnails == nil {
+			resp.Files[i].Thumbnails = map[string]string{}
+		}
+		resp.Files[i].Thumbnails[format] = u
+	}
+
+	c.JSON(200, resp)
+}
+
+// HandleObjectFilesGet godoc
+// @Summary HandleObjectFilesGet returns the file manifest of an Object: media format, dimensions, duration, thumbnails, hashes
+// @Produce json
+// @Param cid path string true "object address"
+// @Success 200 object respObjectFiles success "file manifest"
+// @Failure 400 {string} error "Request params wrong"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 451 {string} error "Cannot find object"
+// @Failure 500 {string} error "Internal error"
+// @Router /object/{cid}/files [get]
+func HandleObjectFilesGet(c *gin.Context) {
+
+	cid := c.Param("cid")
+	if cid == "" {
+		glog.Errorf("cid parameter missing")
+		c.JSON(400, gin.H{"error": ""})
+		return
+	}
+
+	obj, _, err := findObjectByCid(cid)
+	if err != nil {
+		c.JSON(451, gin.H{"error": ""})
+		return
+	}
+	viewerUid, _ := jwtUserUid(c)
+	if !canViewObject(obj, viewerUid) {
+		c.JSON(451, gin.H{"error": ""})
+		return
+	}
+
+	var manifest utils.MediaManifest
+	b, err := obj.Files.MarshalJSON()
+	if err == nil {
+		err = json.Unmarshal(b, &manifest)
+	}
+	if err != nil {
+		glog.Errorf("cannot read file manifest %s %v", cid, err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+
+	resp := respObjectFiles{Cid: cid, Files: []*utils.MediaFileInfo{}}
+	for _, f := range manifest {
+		resp.Files = append(resp.Files, f)
+	}
+	sort.Slice(resp.Files, func(i, j int) bool { return resp.Files[i].Path < resp.Files[j].Path })
+
+	c.JSON(200, resp)
 }
 
 // HandleObjectSearch godoc
@@ -414,6 +669,7 @@ func HandleObjectSearch(c *gin.Context) {
 	}
 
 	var resp respObjectSearch
+	viewerUid, _ := jwtUserUid(c)
 
 	// TODO: validate more
 
@@ -422,7 +678,10 @@ func HandleObjectSearch(c *gin.Context) {
 		// TODO: validate key/values
 
 		var arcs []models.Arc
-		res := models.Db.Where("name like ?", "%"+request.MatchExpressions[0].Values[0]+"%").Find(&arcs)
+		searchable, args := searchableObjectsClause("arcs", viewerUid)
+		res := models.Db.Where("name like ?", "%"+request.MatchExpressions[0].Values[0]+"%").
+			Where(searchable, args...).
+			Find(&arcs)
 		if res.Error != nil {
 			glog.Errorf("search query error %v", res.Error)
 			c.JSON(500, gin.H{"error": ""})
@@ -450,12 +709,17 @@ func HandleObjectSearch(c *gin.Context) {
 			return
 		}
 
+		// both the arc and the pin it is pinned to must be listable
 		var pas []ArcAndPin
+		searchableArc, arcArgs := searchableObjectsClause("a", viewerUid)
+		searchablePin, pinArgs := searchableObjectsClause("p", viewerUid)
 		res := models.Db.
 			Joins("JOIN pins p on p.id = pinned_arcs.pin_id").
 			Joins("JOIN arcs a on a.id = pinned_arcs.arc_id").
 			Select("p.*, a.*").
 			Where(fmt.Sprintf("ST_DWithin(p.location, 'SRID=4326;POINT(%f %f)'::geography, %f)", lon, lat, 10.0)).
+			Where(searchableArc, arcArgs...).
+			Where(searchablePin, pinArgs...).
 			Table("pinned_arcs").
 			Find(&pas)
 		if res.Error != nil {
@@ -473,18 +737,98 @@ func HandleObjectSearch(c *gin.Context) {
 	c.JSON(200, resp)
 }
 
+// checkObjectIndex validates the index file and checks its owner against the JWT user, if the request has a JWT.
+// Returns the uploader UID, which is empty when the request was authorized by App-Key only, or an HTTP code on error.
+func checkObjectIndex(c *gin.Context, indexPath string) (string, int, error) {
+
+	body, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		return "", 400, fmt.Errorf("cannot read index file %v", err)
+	}
+	var request reqObject
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		return "", 400, fmt.Errorf("cannot unmarshall object index %v", err)
+	}
+	if !validObjectPrivacy(request.Metadata.Privacy, request.Metadata.Visibility) {
+		return "", 400, fmt.Errorf("unknown privacy %s or visibility %s", request.Metadata.Privacy, request.Metadata.Visibility)
+	}
+
+	uploaderUid, ok := jwtUserUid(c)
+	if !ok {
+		return "", 0, nil
+	}
+	if !canPublishAs(uploaderUid, request.Metadata.Owner.Id, request.Metadata.Owner.Provider) {
+		return "", 403, fmt.Errorf("user %s cannot publish as owner %s", uploaderUid, request.Metadata.Owner.Id)
+	}
+	return uploaderUid, 0, nil
+}
+
+// ownsUploadSession returns true if the request has the JWT user that began the batch upload, or no JWT for uploads
+// begun with an App-Key only
+func ownsUploadSession(c *gin.Context, mu *models.MediaUpload) bool {
+	uid, _ := jwtUserUid(c)
+	return uid == mu.UploaderUid
+}
+
+// scrubObjectMedia removes private metadata from the photos in folder.  When media.scrub.gpsToPinLocation is set, a pin
+// index without a location gets the position of its first photo with GPS, as scrubbing kept it.
+func scrubObjectMedia(ctx context.Context, folder string) error {
+
+	result, err := utils.ScrubMediaInFolder(ctx, folder)
+	if err != nil {
+		return err
+	}
+	if result.Position == nil || !viper.GetBool("media.scrub.gpsToPinLocation") {
+		return nil
+	}
+	return locateObjectIndex(path.Join(folder, viper.GetString("media.indexFilename")), *result.Position)
+}
+
+// locateObjectIndex sets the location of the pin index at indexPath to position, unless it has one
+func locateObjectIndex(indexPath string, position utils.GeoPosition) error {
+
+	body, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		return fmt.Errorf("cannot read index file %v", err)
+	}
+	var index map[string]interface{}
+	err = json.Unmarshal(body, &index)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshall object index %v", err)
+	}
+	metadata, ok := index["metadata"].(map[string]interface{})
+	if index["kind"] != "pin" || !ok {
+		return nil
+	}
+	if location, ok := metadata["location"].(map[string]interface{}); ok {
+		lat, _ := location["lat"].(float64)
+		lon, _ := location["lon"].(float64)
+		if lat != 0 || lon != 0 {
+			return nil
+		}
+	}
+
+	metadata["location"] = map[string]float64{"lat": position.Lat, "lon": position.Lon}
+	body, err = json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("cannot marshal object index %v", err)
+	}
+	return ioutil.WriteFile(indexPath, body, 0644)
+}
+
 // indexObjectFile indexes index.json file
-func indexObjectFile(cid string, indexPath string) error {
+func indexObjectFile(cid string, indexPath string, uploaderUid string) error {
 
 	body, err := ioutil.ReadFile(indexPath)
 	if err != nil {
 		return fmt.Errorf("cannot read index file %v", err)
 	}
-	return indexObjectString(cid, string(body))
+	return indexObjectString(cid, string(body), uploaderUid)
 }
 
 // indexObjectString adds an object index given the string body of the object index
-func indexObjectString(cid string, body string) error {
+func indexObjectString(cid string, body string, uploaderUid string) error {
 
 	var request reqObject
 	err := json.Unmarshal([]byte(body), &request)
@@ -500,6 +844,9 @@ func indexObjectString(cid string, body string) error {
 		arc.Cid = cid
 		arc.OwnerUid = request.Metadata.Owner.Id
 		arc.OwnerProvider = request.Metadata.Owner.Provider
+		arc.UploaderUid = uploaderUid
+		arc.Privacy = request.Metadata.Privacy
+		arc.Visibility = request.Metadata.Visibility
 		arc.Name = request.Metadata.Name
 		arc.Description = request.Metadata.Description
 		arc.CreatedAtInner = request.Metadata.CreatedAt
@@ -516,6 +863,9 @@ func indexObjectString(cid string, body string) error {
 		pin.Cid = cid
 		pin.OwnerUid = request.Metadata.Owner.Id
 		pin.OwnerProvider = request.Metadata.Owner.Provider
+		pin.UploaderUid = uploaderUid
+		pin.Privacy = request.Metadata.Privacy
+		pin.Visibility = request.Metadata.Visibility
 		pin.Name = request.Metadata.Name
 		pin.Description = request.Metadata.Description
 		pin.CreatedAtInner = request.Metadata.CreatedAt
@@ -555,6 +905,9 @@ func indexObjectString(cid string, body string) error {
 		pa.Cid = cid
 		pa.OwnerUid = request.Metadata.Owner.Id
 		pa.OwnerProvider = request.Metadata.Owner.Provider
+		pa.UploaderUid = uploaderUid
+		pa.Privacy = request.Metadata.Privacy
+		pa.Visibility = request.Metadata.Visibility
 		pa.Name = request.Metadata.Name
 		pa.Description = request.Metadata.Description
 		pa.CreatedAtInner = request.Metadata.CreatedAt
@@ -571,8 +924,30 @@ func indexObjectString(cid string, body string) error {
 	return nil
 }
 
+// saveObjectFiles stores the file manifest in the indexed object
+func saveObjectFiles(cid string, manifest utils.MediaManifest) error {
+
+	obj, record, err := findObjectByCid(cid)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("cannot marshal file manifest %v", err)
+	}
+	err = obj.Files.UnmarshalJSON(b)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal file manifest %v", err)
+	}
+	res := models.Db.Save(record)
+	if res.Error != nil {
+		return fmt.Errorf("cannot save file manifest %v", res.Error)
+	}
+	return nil
+}
+
 // HandleObjectBatchUploadBegin godoc
-// @Summary HandleObjectBatchUploadBegin starts a batch upload for an object
+// @Summary HandleObjectBatchUploadBegin starts a batch upload for an object.  With a JWT, only that user can add to and end the upload.
 // @Accept json
 // @Produce json
 // @Success 200 object respBatchUploadBegin success "Batch upload session ID"
@@ -596,6 +971,7 @@ func HandleObjectBatchUploadBegin(c *gin.Context) {
 	mu.SessionID = sid
 	mu.Status = models.MediaUploadEnabled
 	mu.Path = tempDirPath
+	mu.UploaderUid, _ = jwtUserUid(c)
 	res := models.Db.Save(&mu)
 	if res.Error != nil {
 		os.RemoveAll(tempDirPath)
@@ -614,6 +990,7 @@ func HandleObjectBatchUploadBegin(c *gin.Context) {
 // @Success 200 {string} success ""
 // @Failure 400 {string} error "Request params wrong"
 // @Failure 401 {string} error "Unauthorized"
+// @Failure 403 {string} error "Upload was begun by another user"
 // @Failure 451 {string} error "Cannot find sessionId"
 // @Failure 500 {string} error "Internal error"
 // @Router /object/batchUpload/multipart/{sessionId} [post]
@@ -634,6 +1011,11 @@ func HandleObjectBatchUploadMultipart(c *gin.Context) {
 		c.JSON(451, gin.H{"error": ""})
 		return
 	}
+	if !ownsUploadSession(c, &mu) {
+		glog.Errorf("upload session %s begun by another user", sessionId)
+		c.JSON(403, gin.H{"error": ""})
+		return
+	}
 
 	// upload files to temp folder using path information
 	form, _ := c.MultipartForm()
@@ -678,6 +1060,7 @@ func HandleObjectBatchUploadMultipart(c *gin.Context) {
 // @Success 200 object respObject success "CID of uploaded Object"
 // @Failure 400 {string} error "Request params wrong"
 // @Failure 401 {string} error "Unauthorized"
+// @Failure 403 {string} error "Upload was begun by another user"
 // @Failure 451 {string} error "Cannot file session ID"
 // @Failure 500 {string} error "Internal error"
 // @Router /object/batchUpload/end/{sessionId} [put]
@@ -698,8 +1081,37 @@ func HandleObjectBatchUploadEnd(c *gin.Context) {
 		c.JSON(451, gin.H{"error": ""})
 		return
 	}
+	if !ownsUploadSession(c, &mu) {
+		glog.Errorf("upload session %s begun by another user", sessionId)
+		c.JSON(403, gin.H{"error": ""})
+		return
+	}
 	defer os.RemoveAll(mu.Path)
 
+	// check index and owner against logged in user
+	uploaderUid, code, err := checkObjectIndex(c, path.Join(mu.Path, viper.GetString("media.indexFilename")))
+	if err != nil {
+		glog.Errorf("object index rejected %v", err)
+		c.JSON(code, gin.H{"error": ""})
+		return
+	}
+
+	// check 3d models only reference files in the upload
+	err = utils.CheckModelsInFolder(mu.Path)
+	if err != nil {
+		glog.Errorf("model rejected %v", err)
+		c.JSON(400, gin.H{"error": ""})
+		return
+	}
+
+	// remove private photo metadata before publishing, IPFS is permanent
+	err = scrubObjectMedia(c.Request.Context(), mu.Path)
+	if err != nil {
+		glog.Errorf("media rejected %v", err)
+		c.JSON(400, gin.H{"error": ""})
+		return
+	}
+
 	// save to ipfs
 	cid, err := utils.Ipfs.UploadDirectory(mu.Path)
 	if err != nil {
@@ -709,7 +1121,7 @@ func HandleObjectBatchUploadEnd(c *gin.Context) {
 	}
 
 	// index
-	err = indexObjectFile(cid, path.Join(mu.Path, viper.GetString("media.indexFilename")))
+	err = indexObjectFile(cid, path.Join(mu.Path, viper.GetString("media.indexFilename")), uploaderUid)
 	if err != nil {
 		glog.Errorf("cannot index %v", err)
 		c.JSON(500, gin.H{"error": ""})
@@ -717,18 +1129,24 @@ func HandleObjectBatchUploadEnd(c *gin.Context) {
 		return
 	}
 
-	// create thumbs
-	err = utils.CreateThumbnailsInFolder(mu.Path)
+	// create thumbs, conversions and file manifest, they go to the media cache only, not IPFS
+	manifest, err := utils.CreateThumbnailsInFolder(c.Request.Context(), mu.Path, cid)
 	if err != nil {
 		glog.Errorf("cannot create thumbnails %s %v", mu.Path, err)
 		c.JSON(500, gin.H{"error": ""})
 		return
 	}
+	err = saveObjectFiles(cid, manifest)
+	if err != nil {
+		glog.Errorf("cannot save file manifest %s %v", cid, err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
 
-	// save to S3
-	err = utils.S3.UploadDirectory(mu.Path, cid)
+	// save to media cache
+	err = utils.Cache.UploadDirectory(mu.Path, cid)
 	if err != nil {
-		glog.Errorf("cannot upload to S3 %s %v", cid, err)
+		glog.Errorf("cannot upload to media cache %s %v", cid, err)
 		c.JSON(500, gin.H{"error": ""})
 		return
 	}
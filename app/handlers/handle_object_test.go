@@ -5,10 +5,13 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"os"
+	"path"
 	"time"
 
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/wos-project/wos-core-go/app/config"
@@ -25,6 +28,12 @@ func init() {
 	config.ConfigPath = flag.String("config", "../config.yaml", "path to YAML config file")
 	config.InitializeConfiguration()
 	flag.Parse()
+	// tests enqueue on local chains, the shipped config defaults to mainnet
+	viper.Set("transactions.chains", []map[string]interface{}{
+		{"id": "1", "name": "ethereum mainnet", "walletKind": "ethereum"},
+		{"id": "1337", "name": "ganache", "walletKind": "ethereum", "default": true},
+		{"id": "devnet", "name": "solana devnet", "walletKind": "solana", "default": true},
+	})
 	utils.InitMediaStorage()
 	models.OpenDatabase()
 	models.DropAllTables()
@@ -96,6 +105,19 @@ func TestObjects(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.True(t, w.Body.Len() > 50000)
 
+	// get file manifest
+	w = PerformRequest(router, "GET", fmt.Sprintf("/object/%s/files", objArc.Cid), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var files respObjectFiles
+	err = json.Unmarshal([]byte(w.Body.String()), &files)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(files.Files))
+	assert.Equal(t, "index.json", files.Files[0].Path)
+	assert.Equal(t, "media/hello.mp3", files.Files[1].Path)
+	assert.Equal(t, utils.MediaFormatAudio, files.Files[1].MediaFormat)
+	assert.Equal(t, int64(55298), files.Files[1].Size)
+	assert.Equal(t, objArc.Cid+"/media/hello.mp3", files.Files[1].Key)
+
 	// get arc index
 	w = PerformRequest(router, "GET", fmt.Sprintf("/object/%s/index", objArc.Cid), "")
 	assert.Equal(t, http.StatusOK, w.Code)
@@ -175,6 +197,127 @@ func TestObjects(t *testing.T) {
 	err = json.Unmarshal([]byte(w.Body.String()), &search)
 	assert.Equal(t, 1, len(search.Results))
 
+	// publish as someone else with a JWT
+	ownedIndex := `{
+		"apiVersion": "v1",
+		"metadata": {
+		  "name": "owned",
+		  "createdAt": "2021-12-15T01:01:01Z",
+		  "owner": {
+		    "id": "y8240fnweir02shwie8ree0",
+		    "provider": "eth"
+		  }
+		},
+		"kind": "arc",
+		"spec": {}
+	      }`
+	w, err = PerformRequestWithJWT(router, "POST", "/object/index", ownedIndex, "someone-else")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// publish as owner with a JWT
+	w, err = PerformRequestWithJWT(router, "POST", "/object/index", ownedIndex, "y8240fnweir02shwie8ree0")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// private object is only visible to its owner
+	w = PerformRequest(router, "POST", "/object/index", `{
+		"apiVersion": "v1",
+		"metadata": {
+		  "name": "private-arc",
+		  "createdAt": "2021-12-15T01:01:01Z",
+		  "owner": {
+		    "id": "y8240fnweir02shwie8ree0",
+		    "provider": "eth"
+		  },
+		  "privacy": "private",
+		  "visibility": "visible"
+		},
+		"kind": "arc",
+		"spec": {}
+	      }`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var objPrivate respObject
+	err = json.Unmarshal([]byte(w.Body.String()), &objPrivate)
+	assert.Nil(t, err)
+
+	w = PerformRequest(router, "GET", "/object/"+objPrivate.Cid+"/index", "")
+	assert.Equal(t, 451, w.Code)
+	w, err = PerformRequestWithJWT(router, "GET", "/object/"+objPrivate.Cid+"/index", "", "y8240fnweir02shwie8ree0")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = PerformRequest(router, "GET", "/object/search", `{"matchExpressions": [ {"key": "name", "operator": "equal", "values": ["private-arc"]} ]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.Unmarshal([]byte(w.Body.String()), &search)
+	assert.Equal(t, 0, len(search.Results))
+
+	// arcs pinned to a private pin are not found by location, except by the owner
+	w = PerformRequest(router, "POST", "/object/index", `{
+		"apiVersion": "v1",
+		"metadata": {
+		  "name": "private-pin",
+		  "createdAt": "2021-12-15T01:01:01Z",
+		  "owner": {"id": "y8240fnweir02shwie8ree0", "provider": "eth"},
+		  "privacy": "private",
+		  "location": {"lat": 43.5, "lon": -70.5}
+		},
+		"kind": "pin",
+		"spec": {}
+	      }`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var objPrivatePin respObject
+	err = json.Unmarshal([]byte(w.Body.String()), &objPrivatePin)
+	assert.Nil(t, err)
+	w = PerformRequest(router, "POST", "/object/index", fmt.Sprintf(`{
+		"apiVersion": "v1",
+		"metadata": {
+		  "name": "pinned-to-private",
+		  "createdAt": "2021-12-15T01:01:01Z",
+		  "owner": {"id": "y8240fnweir02shwie8ree0", "provider": "eth"}
+		},
+		"kind": "pinnedArc",
+		"spec": {
+		  "arcSelector": {"cid": "%s"},
+		  "pinSelector": {"cid": "%s"}
+		}
+	      }`, objArc.Cid, objPrivatePin.Cid))
+	assert.Equal(t, http.StatusOK, w.Code)
+	locationSearch := `{"matchExpressions": [ {"key": "location", "operator": "equal", "values": ["43.5", "-70.5"]} ]}`
+	w = PerformRequest(router, "GET", "/object/search", locationSearch)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.Unmarshal([]byte(w.Body.String()), &search)
+	assert.Equal(t, 0, len(search.Results))
+	w, err = PerformRequestWithJWT(router, "GET", "/object/search", locationSearch, "y8240fnweir02shwie8ree0")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.Unmarshal([]byte(w.Body.String()), &search)
+	assert.Equal(t, 1, len(search.Results))
+
+	// following twice is one follow, and following again after unfollowing works
+	for _, method := range []string{"POST", "POST", "DELETE", "POST", "POST"} {
+		w, err = PerformRequestWithJWT(router, method, "/user/follow/y8240fnweir02shwie8ree0", "", "a-follower")
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	var follows int64
+	models.Db.Model(&models.Follow{}).Where("follower_uid = ?", "a-follower").Count(&follows)
+	assert.Equal(t, int64(1), follows)
+
+	// unknown privacy is rejected
+	w = PerformRequest(router, "POST", "/object/index", `{
+		"apiVersion": "v1",
+		"metadata": {
+		  "name": "bad-privacy",
+		  "createdAt": "2021-12-15T01:01:01Z",
+		  "owner": {"id": "y8240fnweir02shwie8ree0", "provider": "eth"},
+		  "privacy": "secretish"
+		},
+		"kind": "arc",
+		"spec": {}
+	      }`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
 	// batch upload
 	w = PerformRequest(router, "POST", "/object/batchUpload", "")
 	assert.Equal(t, http.StatusOK, w.Code)
@@ -193,7 +336,64 @@ func TestObjects(t *testing.T) {
 	err = json.Unmarshal([]byte(w.Body.String()), &objPin)
 	assert.True(t, len(obj.Cid) > 0)
 
+	// uploads begun with a JWT belong to that user
+	w, err = PerformRequestWithJWT(router, "POST", "/object/batchUpload", "", "y8240fnweir02shwie8ree0")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var buOwned respBatchUploadBegin
+	err = json.Unmarshal([]byte(w.Body.String()), &buOwned)
+	assert.Nil(t, err)
+	w, err = UploadFile(router, "POST", "/object/batchUpload/multipart/"+buOwned.SessionID, "test/object_folder/index.json", "/index.json")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = PerformRequest(router, "PUT", "/object/batchUpload/"+buOwned.SessionID, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, err = PerformRequestWithJWT(router, "PUT", "/object/batchUpload/"+buOwned.SessionID, "", "someone-else")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// resize on demand, first resized then from the cache
+	w = PerformRequest(router, "GET", fmt.Sprintf("/media/%s/media/charlestown1.jpg?w=200&fmt=webp", objPin.Cid), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/webp", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Cache-Control"), "immutable")
+	etag := w.Header().Get("ETag")
+	w = PerformRequest(router, "GET", fmt.Sprintf("/media/%s/media/charlestown1.jpg?w=200&fmt=webp", objPin.Cid), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	w = PerformRequest(router, "GET", fmt.Sprintf("/media/%s/media/charlestown1.jpg?w=201", objPin.Cid), "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/%s/media/%s/media/charlestown1.jpg?w=200&fmt=webp",
+		viper.GetString("apiVersion"), objPin.Cid), nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// only variants that can be served are cacheable or not modified
+	for path, code := range map[string]int{"index.json": http.StatusUnsupportedMediaType, "media/nope.jpg": 451} {
+		req, _ = http.NewRequest("GET", fmt.Sprintf("/%s/media/%s/%s?w=200", viper.GetString("apiVersion"), objPin.Cid, path), nil)
+		variant, _ := utils.ParseResizeVariant("200", "", "", "")
+		req.Header.Set("If-None-Match", fmt.Sprintf(`"%s"`, variant.Key(objPin.Cid, path)))
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, code, w.Code, path)
+		assert.Empty(t, w.Header().Get("ETag"), path)
+		assert.Empty(t, w.Header().Get("Cache-Control"), path)
+	}
+
 	// test
 	w = PerformRequest(router, "GET", fmt.Sprintf("/object/%s/index", obj.Cid), "")
 	assert.Equal(t, http.StatusOK, w.Code)
+
+	// old unsigned media links redirect to signed URLs, after the privacy check
+	viper.Set("media.schemes.localSimple.webPath", "legacy-web-path")
+	defer viper.Set("media.schemes.localSimple.webPath", "")
+	legacyRouter := SetupRouter()
+	legacyPrefix := path.Join(viper.GetString("media.webMediaPath"), viper.GetString("media.schemes.localSimple.id"), "legacy-web-path")
+	w = PerformRequestFull(legacyRouter, "GET", path.Join(legacyPrefix, objPin.Cid, "media/charlestown1.jpg"), "")
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Contains(t, w.Header().Get("Location"), "/"+objPin.Cid+"/media/charlestown1.jpg?")
+	assert.Contains(t, w.Header().Get("Location"), "sig=")
+	w = PerformRequestFull(legacyRouter, "GET", path.Join(legacyPrefix, objPrivate.Cid, "index.json"), "")
+	assert.Equal(t, 451, w.Code)
 }
@@ -1,11 +1,12 @@
 package handlers
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
-	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
@@ -13,11 +14,23 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/wos-project/wos-core-go/app/models"
+	"github.com/wos-project/wos-core-go/app/utils"
+)
+
+const (
+	defaultTransactionLeaseSecs = 300
+	maxTransactorWorkerIdLen    = 128
+	defaultTransactionMaxList   = 100
+
+	// transactionResultOk is the status workers report for transactions that went through
+	transactionResultOk = "ok"
 )
 
 type reqTransactionEnqueue struct {
-	Kind string      `json:"kind"`
-	Spec interface{} `json:"spec"`
+	Kind     string      `json:"kind"`
+	ChainId  string      `json:"chainId"`
+	Campaign string      `json:"campaign"`
+	Spec     interface{} `json:"spec"`
 }
 
 type reqTransactionEnqueueSpecAirdropErc721 struct {
@@ -37,8 +50,10 @@ type reqTransactionEnqueueSpecAirdropErc20 struct {
 }
 
 type respTransactionQueuedItem struct {
-	Kind string      `json:"kind"`
-	Spec interface{} `json:"spec"`
+	Kind           string      `json:"kind"`
+	ChainId        string      `json:"chainId"`
+	Spec           interface{} `json:"spec"`
+	LeaseExpiresAt time.Time   `json:"leaseExpiresAt"`
 }
 
 type respTransactionQueuedItemAirdropErc721 struct {
@@ -47,6 +62,7 @@ type respTransactionQueuedItemAirdropErc721 struct {
 	WalletAddr  string `json:"walletAddr"`
 	WalletKind  string `json:"walletKind"`
 	IpfsCid     string `json:"ipfsCid"`
+	TokenUri    string `json:"tokenUri,omitempty"`
 }
 
 type respTransactionQueuedItemAirdropErc20 struct {
@@ -57,25 +73,136 @@ type respTransactionQueuedItemAirdropErc20 struct {
 	Quantity    int    `json:"tokenQuantity"`
 }
 
+type respTransaction struct {
+	Uid           string                 `json:"uid"`
+	Kind          string                 `json:"kind"`
+	Status        string                 `json:"status"`
+	WalletAddr    string                 `json:"walletAddr"`
+	WalletKind    string                 `json:"walletKind"`
+	ChainId       string                 `json:"chainId"`
+	IpfsCid       string                 `json:"ipfsCid,omitempty"`
+	TokenQuantity int                    `json:"tokenQuantity,omitempty"`
+	CallbackUri   string                 `json:"callbackUri"`
+	ContractAddr  string                 `json:"contractAddr,omitempty"`
+	Cost          string                 `json:"cost,omitempty"`
+	Spec          interface{}            `json:"spec,omitempty"`
+	Result        interface{}            `json:"result,omitempty"`
+	ErrorCount    int                    `json:"errorCount"`
+	LastError     string                 `json:"lastError,omitempty"`
+	LastErrorAt   *time.Time             `json:"lastErrorAt,omitempty"`
+	CreatedAt     time.Time              `json:"createdAt"`
+	UpdatedAt     time.Time              `json:"updatedAt"`
+	Events        []respTransactionEvent `json:"events,omitempty"`
+}
+
+type respTransactionEvent struct {
+	Status  string    `json:"status"`
+	Worker  string    `json:"worker,omitempty"`
+	Message string    `json:"message,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+type reqTransactionList struct {
+	Status     string    `form:"status"`
+	Kind       string    `form:"kind"`
+	WalletAddr string    `form:"walletAddr"`
+	ChainId    string    `form:"chainId"`
+	From       time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To         time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Offset     int       `form:"offset"`
+	Limit      int       `form:"limit"`
+}
+
+type respTransactions struct {
+	Transactions []respTransaction `json:"transactions"`
+	Total        int64             `json:"total"`
+	Offset       int               `json:"offset"`
+	Limit        int               `json:"limit"`
+}
+
+func (r *respTransaction) MarshalFromTransaction(tx *models.Transaction) {
+	r.Uid = tx.Uid
+	r.Kind = tx.Kind
+	r.Status = models.TransactionStatusNames[tx.Status]
+	r.WalletAddr = tx.WalletAddr
+	r.WalletKind = tx.WalletKind
+	r.ChainId = tx.ChainId
+	r.IpfsCid = tx.IpfsCid
+	r.TokenQuantity = tx.TokenQuantity
+	r.CallbackUri = tx.CallbackUri
+	r.ContractAddr = tx.ContractAddr
+	r.Cost = tx.Cost
+	r.Spec = tx.Metadata["spec"]
+	r.Result = tx.Metadata["result"]
+	r.ErrorCount = tx.ErrorCount
+	r.LastError = tx.LastError
+	if !tx.LastErrorAt.IsZero() {
+		lastErrorAt := tx.LastErrorAt
+		r.LastErrorAt = &lastErrorAt
+	}
+	r.CreatedAt = tx.CreatedAt
+	r.UpdatedAt = tx.UpdatedAt
+}
+
+func (r *respTransactionEvent) MarshalFromTransactionEvent(e *models.TransactionEvent) {
+	r.Status = models.TransactionStatusNames[e.Status]
+	r.Worker = e.Worker
+	r.Message = e.Message
+	r.At = e.CreatedAt
+}
+
+// marshalTransactionsWithEvents returns txs with their timelines
+func marshalTransactionsWithEvents(txs []models.Transaction) ([]respTransaction, error) {
+
+	ids := make([]uint, len(txs))
+	byId := map[uint]int{}
+	resp := make([]respTransaction, len(txs))
+	for i := range txs {
+		ids[i] = txs[i].ID
+		byId[txs[i].ID] = i
+		resp[i].MarshalFromTransaction(&txs[i])
+	}
+	events, err := models.FindTransactionEvents(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range events {
+		var e respTransactionEvent
+		e.MarshalFromTransactionEvent(&events[i])
+		r := &resp[byId[events[i].TransactionID]]
+		r.Events = append(r.Events, e)
+	}
+	return resp, nil
+}
+
 type reqTransactionQueuedItemCallback struct {
-	Uid           string `json:"uid"`
-	TxId          string `json:"txId"`
-	ContractAddr  string `json:"contractAddr"`
-	Status        string `json:"status"`
-	IpfsCid       string `json:"ipfsCid"`
-	Cost          string `json:"cost"`
-	TokenQuantity int    `json:"tokenQuantity"`
+	Uid           string      `json:"uid"`
+	TxId          string      `json:"txId"`
+	ContractAddr  string      `json:"contractAddr"`
+	Status        string      `json:"status"`
+	IpfsCid       string      `json:"ipfsCid"`
+	Cost          string      `json:"cost"`
+	TokenQuantity int         `json:"tokenQuantity"`
+	TokenId       string      `json:"tokenId,omitempty"`
+	Result        interface{} `json:"result,omitempty"`
 }
 
 // HandleTransactionEnqueue godoc
 // @Summary HandleTransactionEnqueue enqueues a transaction for the transactor
+// @Description Enqueuing is idempotent on uid, enqueuing the same transaction again returns the existing one.
+// @Description Wallet addresses are checked and normalized for their wallet kind, and chainId must be one of
+// @Description transactions.chains, defaulting to the default chain of the wallet kind.  Transactions in a campaign
+// @Description are only enqueued while it is open and within its limits, see /admin/campaign.
 // @Accept mpfd
 // @Produce json
 // @Param App-Key header string true "Application key header"
 // @Param json body reqTransactionEnqueue required "transaction details"
-// @Success 200 {string} success ""
-// @Failure 400 {string} error "Request params wrong"
+// @Success 200 object respTransaction success "the enqueued transaction"
+// @Failure 400 {string} error "Request params wrong, unsupported chain, unknown campaign, or callbackUri is not under a registered callback destination"
 // @Failure 401 {string} error "Unauthorized"
+// @Failure 403 {string} error "Campaign disabled, not started, ended or out of budget"
+// @Failure 409 object respTransaction error "A different transaction with the uid exists"
+// @Failure 429 {string} error "Campaign limit reached"
 // @Failure 500 {string} error "Internal Server Error"
 // @Router /transaction/enqueue [post]
 func HandleTransactionEnqueue(c *gin.Context) {
@@ -94,137 +221,443 @@ func HandleTransactionEnqueue(c *gin.Context) {
 		return
 	}
 
-	var tx models.Transaction
+	kind, ok := transactionKinds[request.Kind]
+	if !ok {
+		glog.Errorf("tx kind not supported %s", request.Kind)
+		c.JSON(400, gin.H{"error": "kind not supported " + request.Kind})
+		return
+	}
+	tx := models.Transaction{
+		Kind:   request.Kind,
+		Status: models.TRANSACTION_STATUS_PENDING,
+	}
+	if err := kind.Enqueue(b, &tx); err != nil {
+		glog.Errorf("bad %s tx spec %v", request.Kind, err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if tx.Uid == "" {
+		c.JSON(400, gin.H{"error": "uid required"})
+		return
+	}
+
+	// workers only get transactions for chains they can send to
+	chain, err := utils.FindChain(request.ChainId, tx.WalletKind)
+	if err != nil {
+		glog.Errorf("tx %s %v", tx.Uid, err)
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	tx.ChainId = chain.Id
 
-	if request.Kind == "erc20" {
-		var erc20 reqTransactionEnqueueSpecAirdropErc20
-		err = json.Unmarshal(b, &erc20)
+	// results are only sent to registered receivers, which can verify them with the destination secret
+	destination, err := models.FindCallbackDestination(tx.CallbackUri)
+	if err != nil {
+		glog.Error(err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	if destination == nil {
+		c.JSON(400, gin.H{"error": "callbackUri is not under a registered callback destination"})
+		return
+	}
+	tx.CallbackDestinationID = destination.ID
+
+	// campaign limits are checked when the transaction is saved, so concurrent enqueues cannot both take the last one
+	if request.Campaign != "" {
+		campaign, err := models.FindCampaign(request.Campaign)
 		if err != nil {
-			glog.Errorf("cannot unmarshall object tx %v", err)
-			c.JSON(400, gin.H{"error": ""})
+			glog.Error(err)
+			c.JSON(500, gin.H{"error": ""})
 			return
 		}
-		tx = models.Transaction{
-			Kind:          "erc20",
-			Uid:           erc20.Uid,
-			WalletAddr:    erc20.WalletAddr,
-			WalletKind:    erc20.WalletKind,
-			TokenQuantity: erc20.Quantity,
-			CallbackUri:   erc20.CallbackUri,
-			Status:        models.TRANSACTION_STATUS_PENDING,
+		if campaign == nil {
+			c.JSON(400, gin.H{"error": "unknown campaign " + request.Campaign})
+			return
 		}
-	} else if request.Kind == "erc721" {
-		var erc721 reqTransactionEnqueueSpecAirdropErc721
-		err = json.Unmarshal(b, &erc721)
-		if err != nil {
-			glog.Errorf("cannot unmarshall object tx %v", err)
-			c.JSON(400, gin.H{"error": ""})
+		if campaign.ChainId != "" && campaign.ChainId != tx.ChainId {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("campaign %s is on chain %s", campaign.Name, campaign.ChainId)})
 			return
 		}
-		tx = models.Transaction{
-			Kind:        "erc721",
-			Uid:         erc721.Uid,
-			WalletAddr:  erc721.WalletAddr,
-			WalletKind:  erc721.WalletKind,
-			IpfsCid:     erc721.IpfsCid,
-			CallbackUri: erc721.CallbackUri,
-			Status:      models.TRANSACTION_STATUS_PENDING,
+		if transactionSendsValue(&tx) {
+			c.JSON(400, gin.H{"error": "transactions in a campaign cannot send value, it is not counted against the budget"})
+			return
 		}
-	} else {
-		glog.Errorf("tx kind not supported %s", request.Kind)
-		c.JSON(400, gin.H{"error": ""})
+		tx.CampaignID = campaign.ID
+	} else if viper.GetBool("transactions.requireCampaign") {
+		c.JSON(400, gin.H{"error": "campaign required"})
 		return
 	}
-	resp := models.Db.Save(&tx)
+
+	// retries of the same request get the existing transaction, a concurrent retry loses on the unique uid
+	var existing models.Transaction
+	resp := models.Db.Where("uid = ?", tx.Uid).Limit(1).Find(&existing)
 	if resp.Error != nil {
-		glog.Errorf("cannot save transaction %v", resp.Error)
+		glog.Errorf("cannot get transaction %v", resp.Error)
 		c.JSON(500, gin.H{"error": ""})
 		return
 	}
+	if resp.RowsAffected == 0 {
+		// only new transactions are prepared, so retries do not pin again and succeed while IPFS is down
+		if err := kind.Prepare(&tx); err != nil {
+			glog.Errorf("cannot prepare tx %s %v", tx.Uid, err)
+			c.JSON(500, gin.H{"error": ""})
+			return
+		}
+		err = models.CreateTransaction(&tx)
+		if err == nil {
+			var r respTransaction
+			r.MarshalFromTransaction(&tx)
+			c.JSON(200, r)
+			return
+		}
+		if models.Db.Where("uid = ?", tx.Uid).Limit(1).Find(&existing).RowsAffected == 0 {
+			switch {
+			case errors.Is(err, models.ErrCampaignClosed):
+				c.JSON(403, gin.H{"error": err.Error()})
+			case errors.Is(err, models.ErrCampaignLimit):
+				c.JSON(429, gin.H{"error": err.Error()})
+			default:
+				glog.Errorf("cannot save transaction %v", err)
+				c.JSON(500, gin.H{"error": ""})
+			}
+			return
+		}
+	}
+
+	var r respTransaction
+	r.MarshalFromTransaction(&existing)
+	if !existing.SameRequest(&tx) {
+		glog.Warningf("tx %s enqueued again with different details", tx.Uid)
+		c.JSON(409, r)
+		return
+	}
+	c.JSON(200, r)
 }
 
-// HandleTransactionQueueGet godoc
-// @Summary HandleTransactionQueueGet gets an item from the transaction queue from the transactor
-// @Accept mpfd
+// transactorWorkerId returns the Worker-Id header, or the api key name and prefix for workers that do not send one
+func transactorWorkerId(c *gin.Context) string {
+
+	worker := strings.TrimSpace(c.GetHeader("Worker-Id"))
+	if worker == "" {
+		if key, ok := c.Get(apiKeyContextKey); ok {
+			if k, ok := key.(*models.ApiKey); ok {
+				worker = k.Name + "/" + k.Prefix
+			}
+		}
+	}
+	if len(worker) > maxTransactorWorkerIdLen {
+		worker = worker[:maxTransactorWorkerIdLen]
+	}
+	return worker
+}
+
+// transactionQueuedItem returns the queue item the transactor works on for tx
+func transactionQueuedItem(tx *models.Transaction, callbackUri string) (*respTransactionQueuedItem, error) {
+
+	item := respTransactionQueuedItem{
+		Kind: tx.Kind,
+	}
+	if tx.LeaseExpiresAt != nil {
+		item.LeaseExpiresAt = *tx.LeaseExpiresAt
+	}
+	kind, ok := transactionKinds[tx.Kind]
+	if !ok {
+		return nil, fmt.Errorf("tx kind not supported %s", tx.Kind)
+	}
+	// transactions enqueued before chains were recorded are on the default chain of their wallet kind
+	chain, err := utils.FindChain(tx.ChainId, tx.WalletKind)
+	if err != nil {
+		return nil, err
+	}
+	item.ChainId = chain.Id
+	spec, err := kind.QueuedSpec(tx, callbackUri)
+	if err != nil {
+		return nil, err
+	}
+	item.Spec = spec
+	return &item, nil
+}
+
+// HandleTransactionGet godoc
+// @Summary HandleTransactionGet gets a transaction with its timeline
 // @Produce json
 // @Param App-Key header string true "Application key header"
+// @Param uid path string true "uid the transaction was enqueued with"
 // @Success 200 object respTransaction success "transaction details"
-// @Success 201 {string} success "no transactions"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 404 {string} error "Transaction not found"
+// @Failure 500 {string} error "Internal Server Error"
+// @Router /transaction/{uid} [get]
+func HandleTransactionGet(c *gin.Context) {
+
+	var txs []models.Transaction
+	resp := models.Db.Where("uid = ?", c.Param("uid")).Limit(1).Find(&txs)
+	if resp.Error != nil {
+		glog.Errorf("cannot get transaction %v", resp.Error)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	if len(txs) == 0 {
+		c.JSON(404, gin.H{"error": "transaction not found"})
+		return
+	}
+
+	r, err := marshalTransactionsWithEvents(txs)
+	if err != nil {
+		glog.Error(err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	c.JSON(200, r[0])
+}
+
+// HandleTransactionsGet godoc
+// @Summary HandleTransactionsGet lists transactions with their timelines, newest first
+// @Produce json
+// @Param App-Key header string true "Application key header"
+// @Param status query string false "status name, pending, inFlight, pendingCallback, done or error"
+// @Param kind query string false "kind, erc20, erc721, erc1155 or contractCall"
+// @Param walletAddr query string false "wallet address"
+// @Param chainId query string false "chain id"
+// @Param from query string false "created at or after, RFC3339"
+// @Param to query string false "created before, RFC3339"
+// @Param offset query int false "transactions to skip"
+// @Param limit query int false "most transactions returned, at most transactions.maxList"
+// @Success 200 object respTransactions success "transactions"
 // @Failure 400 {string} error "Request params wrong"
 // @Failure 401 {string} error "Unauthorized"
 // @Failure 500 {string} error "Internal Server Error"
-// @Router /transaction/queue [get]
-func HandleTransactionQueueGet(c *gin.Context) {
+// @Router /transactions [get]
+func HandleTransactionsGet(c *gin.Context) {
 
-	var tx models.Transaction
-	resp := models.Db.Where("status = 1").Order("created_at").First(&tx)
-	if resp.RowsAffected == 0 {
-		c.JSON(201, "")
+	var request reqTransactionList
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	maxList := viper.GetInt("transactions.maxList")
+	if maxList <= 0 {
+		maxList = defaultTransactionMaxList
+	}
+	if request.Limit <= 0 || request.Limit > maxList {
+		request.Limit = maxList
+	}
+	if request.Offset < 0 {
+		c.JSON(400, gin.H{"error": "offset must not be negative"})
 		return
 	}
 
-	hostKey := "host.hosts." + viper.GetString("host.mode")
-	host := viper.GetString(hostKey + ".domain") 
-	if !(viper.GetInt(hostKey + ".port") == 80 || viper.GetInt(hostKey + ".port") == 443) {
-		host += ":" + viper.GetString(hostKey + ".port")
+	query := models.Db.Model(&models.Transaction{})
+	if request.Status != "" {
+		status, ok := byte(0), false
+		for s, name := range models.TransactionStatusNames {
+			if name == request.Status {
+				status, ok = s, true
+			}
+		}
+		if !ok {
+			c.JSON(400, gin.H{"error": "unknown status " + request.Status})
+			return
+		}
+		query = query.Where("status = ?", status)
+	}
+	if request.Kind != "" {
+		query = query.Where("kind = ?", request.Kind)
 	}
-	cb := &url.URL{
-		Scheme: viper.GetString(hostKey + ".scheme"),
-		Host:   host,
-		Path:   "v1/transaction/cb",
+	if request.WalletAddr != "" {
+		// ethereum addresses are stored checksummed, but may be searched in any case
+		walletAddr := request.WalletAddr
+		if addr, err := utils.NormalizeEthereumAddr(walletAddr); err == nil {
+			walletAddr = addr
+		}
+		query = query.Where("wallet_addr = ?", walletAddr)
+	}
+	if request.ChainId != "" {
+		query = query.Where("chain_id = ?", request.ChainId)
+	}
+	if !request.From.IsZero() {
+		query = query.Where("created_at >= ?", request.From)
+	}
+	if !request.To.IsZero() {
+		query = query.Where("created_at < ?", request.To)
 	}
 
-	item := respTransactionQueuedItem{
-		Kind: tx.Kind,
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		glog.Errorf("cannot count transactions %v", err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	var txs []models.Transaction
+	err := query.Order("created_at DESC, id DESC").Offset(request.Offset).Limit(request.Limit).Find(&txs).Error
+	if err != nil {
+		glog.Errorf("cannot list transactions %v", err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+
+	r, err := marshalTransactionsWithEvents(txs)
+	if err != nil {
+		glog.Error(err)
+		c.JSON(500, gin.H{"error": ""})
+		return
 	}
-	if tx.Kind == "erc721" {
+	c.JSON(200, respTransactions{
+		Transactions: r,
+		Total:        total,
+		Offset:       request.Offset,
+		Limit:        request.Limit,
+	})
+}
 
-		erc721 := respTransactionQueuedItemAirdropErc721{
-			Uid:         tx.Uid,
-			WalletAddr:  tx.WalletAddr,
-			WalletKind:  tx.WalletKind,
-			IpfsCid:     tx.IpfsCid,
-			CallbackUri: cb.String(),
+// HandleTransactionQueueGet godoc
+// @Summary HandleTransactionQueueGet leases items from the transaction queue to a transactor worker
+// @Description Leased transactions are not handed to other workers until the lease expires, see transactions.leaseSecs.
+// @Description Without max a single item is returned, with max an array of up to max items.
+// @Accept mpfd
+// @Produce json
+// @Param App-Key header string true "Application key header"
+// @Param Worker-Id header string false "Identity of the worker, defaults to the api key"
+// @Param max query int false "Lease up to max transactions, at most transactions.maxDequeue"
+// @Success 200 object respTransactionQueuedItem success "transaction details"
+// @Success 201 {string} success "no transactions"
+// @Failure 400 {string} error "Request params wrong"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 500 {string} error "Internal Server Error"
+// @Router /transaction/queue [get]
+func HandleTransactionQueueGet(c *gin.Context) {
+
+	max := 1
+	maxParam := c.Query("max")
+	if maxParam != "" {
+		var err error
+		max, err = strconv.Atoi(maxParam)
+		if err != nil || max < 1 {
+			c.JSON(400, gin.H{"error": "max must be a positive number"})
+			return
+		}
+		if limit := viper.GetInt("transactions.maxDequeue"); limit > 0 && max > limit {
+			max = limit
 		}
-		item.Spec = &erc721
+	}
 
-	} else if tx.Kind == "erc20" {
+	worker := transactorWorkerId(c)
+	txs, err := models.LeaseTransactions(worker, max, transactionLease())
+	if err != nil {
+		glog.Error(err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+
+	cb := utils.HostURL("v1/transaction/cb")
 
-		erc20 := respTransactionQueuedItemAirdropErc20{
-			Uid:         tx.Uid,
-			WalletAddr:  tx.WalletAddr,
-			WalletKind:  tx.WalletKind,
-			Quantity:    tx.TokenQuantity,
-			CallbackUri: cb.String(),
+	items := []*respTransactionQueuedItem{}
+	for i := range txs {
+		item, err := transactionQueuedItem(&txs[i], cb.String())
+		if err != nil {
+			// enqueue only takes known kinds, so take it out of the queue instead of failing the whole lease
+			glog.Error(err)
+			txs[i].Status = models.TRANSACTION_STATUS_ERROR
+			txs[i].LastError = err.Error()
+			txs[i].LastErrorAt = time.Now()
+			txs[i].ErrorCount = txs[i].ErrorCount + 1
+			if err := models.SaveTransaction(&txs[i], txs[i].LastError); err != nil {
+				glog.Error(err)
+			}
+			continue
 		}
-		item.Spec = &erc20
+		items = append(items, item)
+	}
+	glog.V(2).Infof("leased %d transactions to %s", len(items), worker)
 
-	} else {
-		glog.Errorf("tx kind not supported %s", tx.Kind)
-		c.JSON(400, gin.H{"error": ""})
+	if len(items) == 0 {
+		c.JSON(201, "")
+		return
+	}
+	if maxParam == "" {
+		c.JSON(200, items[0])
 		return
 	}
+	c.JSON(200, items)
+}
 
-	// mark transaction as in-process
-	tx.Status = models.TRANSACTION_STATUS_PENDING
-	resp = models.Db.Save(&tx)
-	if resp.Error != nil {
-		glog.Errorf("cannot save transaction %v", resp.Error)
+// transactionLease returns how long transactions are leased to workers, transactions.leaseSecs
+func transactionLease() time.Duration {
+	leaseSecs := viper.GetInt("transactions.leaseSecs")
+	if leaseSecs <= 0 {
+		leaseSecs = defaultTransactionLeaseSecs
+	}
+	return time.Duration(leaseSecs) * time.Second
+}
+
+// HandleTransactionLeaseRenew godoc
+// @Summary HandleTransactionLeaseRenew extends the lease of a transaction by transactions.leaseSecs from now
+// @Description Workers renew the leases of transactions that take longer than a lease, like on slow chains, before
+// @Description they expire.  Once a lease expired the transaction may be leased to another worker, so workers must
+// @Description also not send a transaction twice for the same uid.
+// @Produce json
+// @Param App-Key header string true "Application key header"
+// @Param Worker-Id header string false "Identity of the worker, defaults to the api key"
+// @Param uid path string true "transaction uid"
+// @Success 200 object respTransactionQueuedItem success "transaction with its new lease"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 409 {string} error "Transaction not leased to the worker anymore"
+// @Failure 451 {string} error "Cannot find transaction matching UID"
+// @Failure 500 {string} error "Internal Server Error"
+// @Router /transaction/{uid}/lease [post]
+func HandleTransactionLeaseRenew(c *gin.Context) {
+
+	tx, err := models.RenewTransactionLease(c.Param("uid"), transactorWorkerId(c), transactionLease())
+	if errors.Is(err, models.ErrTransactionLeaseLost) {
+		glog.Warning(err)
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		glog.Error(err)
 		c.JSON(500, gin.H{"error": ""})
 		return
 	}
+	if tx == nil {
+		c.JSON(451, gin.H{"error": ""})
+		return
+	}
 
+	item, err := transactionQueuedItem(tx, utils.HostURL("v1/transaction/cb").String())
+	if err != nil {
+		glog.Error(err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
 	c.JSON(200, item)
 }
 
+// RequeueExpiredTransactionLeases puts transactions whose worker did not call back before the lease expired back in
+// the queue
+func RequeueExpiredTransactionLeases() {
+
+	n, err := models.RequeueExpiredTransactionLeases(time.Now())
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+	if n > 0 {
+		glog.Warningf("requeued %d transactions with expired leases", n)
+	}
+}
+
 // HandleTransactionQueueCallback godoc
 // @Summary HandleTransactionQueueCallback handles queued callbacks from the transactor
+// @Description The result is saved and delivered to the callback URI of the transaction later, with retries.  The cost
+// @Description is in the smallest unit of the native currency of the chain, like wei, and is added to the campaign.
 // @Accept mpfd
 // @Produce json
 // @Param App-Key header string true "Application key header"
-// @Success 200 object respObject success "CID of object"
+// @Success 200 object respTransaction success "transaction details"
 // @Failure 400 {string} error "Request params wrong"
 // @Failure 401 {string} error "Unauthorized"
+// @Failure 409 {string} error "Transaction already reported"
 // @Failure 451 {string} error "Cannot find transaction matching UID"
 // @Failure 500 {string} error "Internal Server Error"
 // @Router /transaction/callback [post]
@@ -243,45 +676,73 @@ func HandleTransactionQueueCallback(c *gin.Context) {
 		c.JSON(451, "")
 		return
 	}
+	if tx.Reported() {
+		c.JSON(409, gin.H{"error": "transaction already done"})
+		return
+	}
+
+	// a late callback after the lease expired still wins, the work was done
+	worker := transactorWorkerId(c)
+	if tx.Status != models.TRANSACTION_STATUS_IN_FLIGHT || tx.LeasedBy != worker {
+		glog.Warningf("tx %s callback from %s, status %d leased by %s", tx.Uid, worker, tx.Status, tx.LeasedBy)
+	}
+	if request.Cost != "" {
+		if _, err := models.ParseCost(request.Cost); err != nil {
+			glog.Errorf("bad tx %s callback %v", tx.Uid, err)
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	tx.LeasedBy = ""
+	tx.LeaseExpiresAt = nil
 	tx.Cost = request.Cost
-	tx.IpfsCid = request.IpfsCid
-	tx.TokenQuantity = request.TokenQuantity
 	tx.Status = models.TRANSACTION_STATUS_PENDING_CB
-	tx.ContractAddr = request.ContractAddr
-
-	// TODO: make this a cron job with retries
+	if request.ContractAddr != "" {
+		addr, err := utils.NormalizeWalletAddr(tx.WalletKind, request.ContractAddr)
+		if err != nil {
+			glog.Errorf("bad tx %s callback contractAddr %v", tx.Uid, err)
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		request.ContractAddr = addr
+	}
+	if tx.ContractAddr == "" {
+		tx.ContractAddr = request.ContractAddr
+	} else if request.ContractAddr != "" && request.ContractAddr != tx.ContractAddr {
+		glog.Warningf("tx %s callback contract %s, not %s", tx.Uid, request.ContractAddr, tx.ContractAddr)
+	}
+	setTransactionResult(&tx, "txId", request.TxId)
+	setTransactionResult(&tx, "status", request.Status)
+	var tokens []models.ObjectToken
+	if kind, ok := transactionKinds[tx.Kind]; ok {
+		var err error
+		tokens, err = kind.ApplyCallback(&request, &tx)
+		if err != nil {
+			glog.Errorf("bad tx %s callback %v", tx.Uid, err)
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
-	// call callback to report status
-	body, _ := json.Marshal(&request)
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", tx.CallbackUri, bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("App-Key", viper.GetString("services.sync.questori.apiKey"))
-	r, err := client.Do(req)
+	// the caller is told by DispatchTransactionCallbacks, with retries
+	body, err := json.Marshal(&request)
 	if err != nil {
-		err = fmt.Errorf("failed returning call %v", err)
-		glog.Error(err)
-		tx.LastError = fmt.Sprintf("failed returning call %v", err)
-		tx.Status = models.TRANSACTION_STATUS_ERROR
-		tx.ErrorCount = tx.ErrorCount + 1
-		models.Db.Save(&tx)
+		glog.Errorf("cannot marshal tx callback %v", err)
+		c.JSON(500, gin.H{"error": ""})
 		return
 	}
-	if r.StatusCode != 200 {
-		err = fmt.Errorf("failed returning call %v", r.StatusCode)
+	if err := models.SaveTransactionWithCallback(&tx, tokens, string(body), "reported by "+worker); err != nil {
+		// another report of the transaction got in first
+		if errors.Is(err, models.ErrTransactionReported) {
+			c.JSON(409, gin.H{"error": "transaction already done"})
+			return
+		}
 		glog.Error(err)
-		tx.LastError = fmt.Sprintf("failed returning call %v", r.StatusCode)
-		tx.Status = models.TRANSACTION_STATUS_ERROR
-		tx.ErrorCount = tx.ErrorCount + 1
-		models.Db.Save(&tx)
+		c.JSON(500, gin.H{"error": ""})
 		return
 	}
 
-	// mark db as done
-	tx.Status = models.TRANSACTION_STATUS_DONE
-	resp = models.Db.Save(&tx)
-	if resp.Error != nil {
-		glog.Errorf("cannot get transaction %v", resp.Error)
-		return
-	}
+	var r respTransaction
+	r.MarshalFromTransaction(&tx)
+	c.JSON(200, r)
 }
@@ -1,17 +1,24 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/spf13/viper"
 
+	"github.com/wos-project/wos-core-go/app/models"
+	"github.com/wos-project/wos-core-go/app/simulator"
 	"github.com/wos-project/wos-core-go/app/utils"
+	"github.com/wos-project/wos-core-go/app/webhook"
 )
 
 var _ = func() bool {
@@ -19,7 +26,8 @@ var _ = func() bool {
 	return true
 }()
 
-// TestTx tests NFT and token transactions.  It requires that the transactor is running
+// TestTx tests the enqueue, dequeue, callback and retry cycle of NFT and token transactions offline, with the
+// simulated transactor and callback receiver
 func TestTx(t *testing.T) {
 
 	os.RemoveAll("/var/tmp/mediatmp")
@@ -27,55 +35,1031 @@ func TestTx(t *testing.T) {
 
 	router := SetupRouter()
 	utils.InitMediaStorage()
+	for {
+		if _, code := leaseQueue(router, "drain", 100); code != http.StatusOK {
+			break
+		}
+	}
+	models.Db.Model(&models.TransactionCallback{}).Where("status = ?", models.CALLBACK_STATUS_PENDING).
+		Update("status", models.CALLBACK_STATUS_DEAD)
 
-	// run the main web server
-	go func() {
-		err := http.ListenAndServe(":"+viper.GetString("host.hosts.localhost.port"), router)
-		assert.Nil(t, err)
-	}()
+	// the main web server and the receiver of its callbacks
+	core := httptest.NewServer(router)
+	defer core.Close()
+	receiver := simulator.NewReceiver(nil, 1, 1)
+	receiverServer := httptest.NewServer(receiver)
+	defer receiverServer.Close()
+	destination := registerTestCallbackDestination(t, receiverServer.URL)
+	receiver.Secret = []byte(destination.Secret)
 
-	fmt.Println("!!!!!!make sure transactor and ganache are running!!!!!!")
+	newTransactor := func(dropRate float64) *simulator.Transactor {
+		return simulator.NewTransactor(simulator.TransactorConfig{
+			BaseUrl:      core.URL + "/" + viper.GetString("apiVersion"),
+			ApiKey:       TestApiKey(),
+			ApiKeyHeader: viper.GetString("auth.apiKey.key"),
+			WorkerId:     fmt.Sprintf("sim%v", dropRate),
+			DropRate:     dropRate,
+			Seed:         1,
+		})
+	}
+	status := func(uid string) respTransaction {
+		w := PerformRequest(router, "GET", "/transaction/"+uid, "")
+		var tx respTransaction
+		json.Unmarshal(w.Body.Bytes(), &tx)
+		return tx
+	}
+
+	// enqueue an NFT and tokens
+	cid := indexTestObject(t, testArcIndex)
+	erc721 := reqTransactionEnqueueSpecAirdropErc721{
+		Uid:         utils.GenerateBase64Rand(),
+		WalletAddr:  "d36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8",
+		WalletKind:  "ethereum",
+		IpfsCid:     cid,
+		CallbackUri: receiverServer.URL + "/cb/erc721",
+	}
+	body, _ := json.Marshal(&reqTransactionEnqueue{Kind: "erc721", Spec: &erc721})
+	w := PerformRequest(router, "POST", "/transaction/enqueue", string(body))
+	assert.Equal(t, http.StatusOK, w.Code)
+	erc20 := reqTransactionEnqueueSpecAirdropErc20{
+		Uid:         utils.GenerateBase64Rand(),
+		WalletAddr:  "d36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8",
+		WalletKind:  "ethereum",
+		Quantity:    5,
+		CallbackUri: receiverServer.URL + "/cb/erc20",
+	}
+	body, _ = json.Marshal(&reqTransactionEnqueue{Kind: "erc20", Spec: &erc20})
+	w = PerformRequest(router, "POST", "/transaction/enqueue", string(body))
+	assert.Equal(t, http.StatusOK, w.Code)
 
-	contractAddr := ""
+	// a worker dies with both, they are requeued when their leases expire
+	dying := newTransactor(1)
+	n, err := dying.RunOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "inFlight", status(erc721.Uid).Status)
+	models.Db.Model(&models.Transaction{}).Where("uid IN ?", []string{erc721.Uid, erc20.Uid}).
+		Update("lease_expires_at", time.Now().Add(-time.Second))
+	RequeueExpiredTransactionLeases()
+	assert.Equal(t, "pending", status(erc721.Uid).Status)
 
-	// spin up another web server that will receive the callback
-	mockApi := http.NewServeMux()
-	mockApi.HandleFunc("/cb/erc721", func(w http.ResponseWriter, r *http.Request) {
+	// another worker reports them
+	n, err = newTransactor(0).RunOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "pendingCallback", status(erc20.Uid).Status)
 
-		fmt.Fprintf(w, "Got callback!")
-		var request reqTransactionQueuedItemCallback
+	// the receiver is down, then the retries are delivered
+	DispatchTransactionCallbacks()
+	assert.Equal(t, 1, status(erc721.Uid).ErrorCount)
+	receiver.FailureRate = 0
+	models.Db.Model(&models.TransactionCallback{}).Where("status = ?", models.CALLBACK_STATUS_PENDING).
+		Update("next_attempt_at", time.Now().Add(-time.Second))
+	DispatchTransactionCallbacks()
+	assert.Equal(t, "done", status(erc721.Uid).Status)
+	assert.Equal(t, "done", status(erc20.Uid).Status)
 
-		err := json.NewDecoder(r.Body).Decode(&request)
-		if err != nil {
-			assert.Fail(t, "failure marshaling cb from transactor")
-			fmt.Println("failure marshaling cb from transactor")
-			return
+	accepted := map[string]reqTransactionQueuedItemCallback{}
+	for _, d := range receiver.Deliveries() {
+		assert.True(t, d.Verified, d.Path)
+		if d.Accepted {
+			var cb reqTransactionQueuedItemCallback
+			assert.Nil(t, json.Unmarshal(d.Body, &cb))
+			accepted[d.Path] = cb
+		}
+	}
+	if assert.Len(t, accepted, 2) {
+		assert.Equal(t, erc721.Uid, accepted["/cb/erc721"].Uid)
+		assert.Equal(t, "1", accepted["/cb/erc721"].TokenId)
+		assert.Equal(t, 5, accepted["/cb/erc20"].TokenQuantity)
+	}
+
+	// the NFT is recorded on the object
+	w = PerformRequest(router, "GET", "/object/"+cid+"/tokens", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tokens respObjectTokens
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+	contractAddr, _ := utils.NormalizeEthereumAddr(simulator.ContractAddrs["ethereum"])
+	if assert.Len(t, tokens.Tokens, 1) {
+		assert.Equal(t, contractAddr, tokens.Tokens[0].ContractAddr)
+	}
+}
+
+// testArcIndex is the index of a public arc with a cover and an audio representation
+const testArcIndex = `{
+	"apiVersion": "v1",
+	"metadata": {
+		"name": "example-mp4",
+		"createdAt": "2021-12-15T01:01:01Z",
+		"description": "example simple audio mp4",
+		"owner": {"id": "y8240fnweir02shwie8ree0", "provider": "eth"},
+		"privacy": "public",
+		"visibility": "visible",
+		"fidelity": ["phone", "earbuds"]
+	},
+	"kind": "arc",
+	"spec": {
+		"coverImageUri": "/media/example-cover-image.jpg",
+		"representation": [{"profile": "audio", "mimeType": "audio/mp4", "uri": "/media/hello.mp3"}],
+		"attributes": [{"trait_type": "place", "value": "Charlestown"}]
+	}
+}`
+
+// indexTestObject indexes an object with index and a unique cid and returns the cid
+func indexTestObject(t *testing.T, index string) string {
+	cid, err := utils.GenerateCid()
+	assert.Nil(t, err)
+	assert.Nil(t, indexObjectString(cid, index, ""))
+	return cid
+}
+
+// registerTestCallbackDestination registers a callback destination for url with a unique name
+func registerTestCallbackDestination(t *testing.T, url string) *models.CallbackDestination {
+	d, err := models.CreateCallbackDestination("test-"+utils.GenerateBase64Rand(), url)
+	assert.Nil(t, err)
+	return d
+}
+
+// leaseQueue leases up to max transactions as worker
+func leaseQueue(router http.Handler, worker string, max int) ([]respTransactionQueuedItem, int) {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/%s/transaction/queue?max=%d", viper.GetString("apiVersion"), max), nil)
+	req.Header.Set(viper.GetString("auth.apiKey.key"), TestApiKey())
+	req.Header.Set("Worker-Id", worker)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var items []respTransactionQueuedItem
+	if w.Code == http.StatusOK {
+		json.Unmarshal(w.Body.Bytes(), &items)
+	}
+	return items, w.Code
+}
+
+// renewLease renews the lease of transaction uid as worker
+func renewLease(router http.Handler, worker string, uid string) (respTransactionQueuedItem, int) {
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/%s/transaction/%s/lease", viper.GetString("apiVersion"), uid), nil)
+	req.Header.Set(viper.GetString("auth.apiKey.key"), TestApiKey())
+	req.Header.Set("Worker-Id", worker)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var item respTransactionQueuedItem
+	if w.Code == http.StatusOK {
+		json.Unmarshal(w.Body.Bytes(), &item)
+	}
+	return item, w.Code
+}
+
+// TestTxQueueLease tests that concurrent workers never lease the same transaction and expired leases are requeued
+func TestTxQueueLease(t *testing.T) {
+
+	router := SetupRouter()
+	registerTestCallbackDestination(t, "http://127.0.0.1:7890/cb")
+
+	// drain whatever earlier tests left in the queue
+	for {
+		if _, code := leaseQueue(router, "drain", 100); code != http.StatusOK {
+			break
+		}
+	}
+
+	uids := map[string]bool{}
+	for i := 0; i < 12; i++ {
+		spec := reqTransactionEnqueueSpecAirdropErc20{
+			Uid:         utils.GenerateBase64Rand(),
+			WalletAddr:  "d36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8",
+			WalletKind:  "ethereum",
+			Quantity:    1,
+			CallbackUri: "http://127.0.0.1:7890/cb/erc20",
 		}
-		contractAddr = request.ContractAddr
-	})
-	go func() {
-		err := http.ListenAndServe(":7890", mockApi)
-		assert.Nil(t, err)
-	}()
-
-	// enqueue transaction
-	txErc721Spec := reqTransactionEnqueueSpecAirdropErc721{
-		Uid:        utils.GenerateBase64Rand(),
-		WalletAddr: "d36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8",
-		WalletKind: "ethereum",
-		IpfsCid:    "a7b969b1a6d25974b2692916abc41312d89bb6a00355ae06ebdb159b89ef5bd8",
+		uids[spec.Uid] = true
+		body, _ := json.Marshal(&reqTransactionEnqueue{Kind: "erc20", Spec: &spec})
+		w := PerformRequest(router, "POST", "/transaction/enqueue", string(body))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// workers race for the queue
+	var mutex sync.Mutex
+	leased := map[string]string{}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(worker string) {
+			defer wg.Done()
+			for {
+				items, code := leaseQueue(router, worker, 2)
+				if code != http.StatusOK {
+					return
+				}
+				mutex.Lock()
+				for _, item := range items {
+					spec := item.Spec.(map[string]interface{})
+					uid := spec["uid"].(string)
+					assert.Empty(t, leased[uid], "%s leased twice", uid)
+					leased[uid] = worker
+					assert.True(t, item.LeaseExpiresAt.After(time.Now()))
+				}
+				mutex.Unlock()
+			}
+		}(fmt.Sprintf("worker%d", i))
+	}
+	wg.Wait()
+	assert.Equal(t, len(uids), len(leased))
+
+	var tx models.Transaction
+	for uid := range uids {
+		models.Db.Where("uid = ?", uid).First(&tx)
+		assert.Equal(t, byte(models.TRANSACTION_STATUS_IN_FLIGHT), tx.Status)
+		assert.Equal(t, leased[uid], tx.LeasedBy)
+	}
+
+	// nothing to lease until leases expire
+	_, code := leaseQueue(router, "late", 1)
+	assert.Equal(t, 201, code)
+	n, err := models.RequeueExpiredTransactionLeases(time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.True(t, n >= int64(len(uids)))
+
+	items, code := leaseQueue(router, "late", 100)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, len(items) >= len(uids))
+	models.Db.Where("uid = ?", tx.Uid).First(&tx)
+	assert.Equal(t, "late", tx.LeasedBy)
+
+	// only the worker holding the lease renews it, until it is requeued
+	time.Sleep(10 * time.Millisecond)
+	item, code := renewLease(router, "late", tx.Uid)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, item.LeaseExpiresAt.After(*tx.LeaseExpiresAt))
+	_, code = renewLease(router, leased[tx.Uid], tx.Uid)
+	assert.Equal(t, http.StatusConflict, code)
+	_, code = renewLease(router, "late", "nope-"+tx.Uid)
+	assert.Equal(t, 451, code)
+	_, err = models.RequeueExpiredTransactionLeases(time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	_, code = renewLease(router, "late", tx.Uid)
+	assert.Equal(t, http.StatusConflict, code)
+
+	// bad max
+	w := PerformRequest(router, "GET", "/transaction/queue?max=0", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestTxEnqueueIdempotent tests that enqueuing the same uid again returns the existing transaction
+func TestTxEnqueueIdempotent(t *testing.T) {
+
+	router := SetupRouter()
+	utils.InitMediaStorage()
+	registerTestCallbackDestination(t, "http://127.0.0.1:7890/cb")
+
+	spec := reqTransactionEnqueueSpecAirdropErc721{
+		Uid:         utils.GenerateBase64Rand(),
+		WalletAddr:  "d36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8",
+		WalletKind:  "ethereum",
+		IpfsCid:     indexTestObject(t, testArcIndex),
 		CallbackUri: "http://127.0.0.1:7890/cb/erc721",
 	}
-	txErc721 := reqTransactionEnqueue{
-		Kind: "erc721",
-		Spec: &txErc721Spec,
+	body, _ := json.Marshal(&reqTransactionEnqueue{Kind: "erc721", Spec: &spec})
+
+	w := PerformRequest(router, "POST", "/transaction/enqueue", string(body))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var first respTransaction
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &first))
+	assert.Equal(t, spec.Uid, first.Uid)
+	assert.Equal(t, "erc721", first.Kind)
+	assert.Equal(t, "pending", first.Status)
+
+	// retry
+	w = PerformRequest(router, "POST", "/transaction/enqueue", string(body))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var again respTransaction
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &again))
+	assert.Equal(t, first.CreatedAt.Unix(), again.CreatedAt.Unix())
+	var count int64
+	models.Db.Model(&models.Transaction{}).Where("uid = ?", spec.Uid).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// same uid, different wallet
+	spec.WalletAddr = "0000000000000000000000000000000000000001"
+	body, _ = json.Marshal(&reqTransactionEnqueue{Kind: "erc721", Spec: &spec})
+	w = PerformRequest(router, "POST", "/transaction/enqueue", string(body))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "d36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8")
+
+	// no uid
+	spec.Uid = ""
+	body, _ = json.Marshal(&reqTransactionEnqueue{Kind: "erc721", Spec: &spec})
+	w = PerformRequest(router, "POST", "/transaction/enqueue", string(body))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestTxEnqueueChains tests that addresses are checked and enqueues are only accepted for supported chains
+func TestTxEnqueueChains(t *testing.T) {
+
+	router := SetupRouter()
+	registerTestCallbackDestination(t, "http://127.0.0.1:7890/cb")
+	for {
+		if _, code := leaseQueue(router, "drain", 100); code != http.StatusOK {
+			break
+		}
+	}
+
+	enqueue := func(chainId string, walletAddr string, walletKind string) (*respTransaction, int) {
+		spec := reqTransactionEnqueueSpecAirdropErc20{
+			Uid:         utils.GenerateBase64Rand(),
+			WalletAddr:  walletAddr,
+			WalletKind:  walletKind,
+			Quantity:    1,
+			CallbackUri: "http://127.0.0.1:7890/cb/erc20",
+		}
+		body, _ := json.Marshal(&reqTransactionEnqueue{Kind: "erc20", ChainId: chainId, Spec: &spec})
+		w := PerformRequest(router, "POST", "/transaction/enqueue", string(body))
+		var r respTransaction
+		json.Unmarshal(w.Body.Bytes(), &r)
+		return &r, w.Code
+	}
+
+	// lower case addresses are checksummed, no chain is the default chain of the wallet kind
+	r, code := enqueue("", "0xd36e5aeaba5f35997e374bb5d1a10b770aace6e8", "ethereum")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "0xd36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8", r.WalletAddr)
+	assert.Equal(t, "1337", r.ChainId)
+	r, code = enqueue("1", "0xd36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8", "ethereum")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "1", r.ChainId)
+
+	items, code := leaseQueue(router, "worker1", 10)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, items, 2) {
+		assert.Equal(t, "1337", items[0].ChainId)
+		assert.Equal(t, "1", items[1].ChainId)
+	}
+
+	for _, bad := range [][3]string{
+		{"137", "0xd36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8", "ethereum"},
+		{"devnet", "0xd36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8", "ethereum"},
+		{"1", "0xd36E5AEaBa5f35997e374Bb5D1A10B770aACE6E8", "ethereum"},
+		{"1", "0xd36E5AEaBa5f35997e374Bb5D1A10B770aACE6", "ethereum"},
+		{"", "0xd36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8", "solana"},
+		{"", "0xd36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8", "dogecoin"},
+	} {
+		_, code = enqueue(bad[0], bad[1], bad[2])
+		assert.Equal(t, http.StatusBadRequest, code, "%v", bad)
+	}
+	_, code = leaseQueue(router, "worker1", 10)
+	assert.Equal(t, http.StatusCreated, code)
+}
+
+// TestTxErc721Tokens tests that NFTs are only minted for indexed objects and minted tokens are recorded on them
+func TestTxErc721Tokens(t *testing.T) {
+
+	router := SetupRouter()
+	utils.InitMediaStorage()
+	registerTestCallbackDestination(t, "http://127.0.0.1:7890/cb")
+	for {
+		if _, code := leaseQueue(router, "drain", 100); code != http.StatusOK {
+			break
+		}
+	}
+
+	cid := indexTestObject(t, testArcIndex)
+	enqueueUid := func(uid string, chainId string, ipfsCid string) int {
+		spec := reqTransactionEnqueueSpecAirdropErc721{
+			Uid:         uid,
+			WalletAddr:  "0xd36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8",
+			WalletKind:  "ethereum",
+			IpfsCid:     ipfsCid,
+			CallbackUri: "http://127.0.0.1:7890/cb/erc721",
+		}
+		body, _ := json.Marshal(&reqTransactionEnqueue{Kind: "erc721", ChainId: chainId, Spec: &spec})
+		return PerformRequest(router, "POST", "/transaction/enqueue", string(body)).Code
+	}
+	enqueue := func(ipfsCid string) (string, int) {
+		uid := utils.GenerateBase64Rand()
+		return uid, enqueueUid(uid, "", ipfsCid)
+	}
+
+	// unknown and private objects are not minted
+	unknown, _ := utils.GenerateCid()
+	_, code := enqueue(unknown)
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = enqueue(indexTestObject(t, strings.Replace(testArcIndex, `"privacy": "public"`, `"privacy": "private"`, 1)))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// the worker gets the pinned metadata
+	uid, code := enqueue(cid)
+	assert.Equal(t, http.StatusOK, code)
+
+	// metadata is only pinned for new transactions that passed every check, so retries work without IPFS
+	ipfs := utils.Ipfs
+	utils.Ipfs = utils.IPFS_Driver{}
+	assert.Equal(t, http.StatusOK, enqueueUid(uid, "", cid))
+	assert.Equal(t, http.StatusBadRequest, enqueueUid(utils.GenerateBase64Rand(), "137", cid))
+	assert.Equal(t, http.StatusInternalServerError, enqueueUid(utils.GenerateBase64Rand(), "", cid))
+	utils.Ipfs = ipfs
+	items, code := leaseQueue(router, "worker1", 1)
+	if !assert.Equal(t, http.StatusOK, code) || !assert.Len(t, items, 1) {
+		return
+	}
+	spec := items[0].Spec.(map[string]interface{})
+	assert.Equal(t, cid, spec["ipfsCid"])
+	tokenUri, _ := spec["tokenUri"].(string)
+	assert.True(t, strings.HasPrefix(tokenUri, "ipfs://") && strings.HasSuffix(tokenUri, "/metadata.json"), tokenUri)
+
+	// the callback records the token and cannot change the object
+	body, _ := json.Marshal(&reqTransactionQueuedItemCallback{Uid: uid, TxId: "0x1", Status: "ok",
+		ContractAddr: "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359", TokenId: "0042", IpfsCid: unknown})
+	w := PerformRequest(router, "POST", "/transaction/cb", string(body))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tx respTransaction
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &tx))
+	assert.Equal(t, cid, tx.IpfsCid)
+	assert.Equal(t, "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359", tx.ContractAddr)
+
+	w = PerformRequest(router, "GET", "/object/"+cid+"/tokens", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tokens respObjectTokens
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+	if assert.Len(t, tokens.Tokens, 1) {
+		assert.Equal(t, "42", tokens.Tokens[0].TokenId)
+		assert.Equal(t, "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359", tokens.Tokens[0].ContractAddr)
+		assert.Equal(t, "0xd36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8", tokens.Tokens[0].WalletAddr)
+		assert.Equal(t, "1337", tokens.Tokens[0].ChainId)
+		assert.Equal(t, tokenUri, tokens.Tokens[0].TokenUri)
+	}
+
+	// failed mints record no token
+	uid, code = enqueue(cid)
+	assert.Equal(t, http.StatusOK, code)
+	_, code = leaseQueue(router, "worker1", 1)
+	assert.Equal(t, http.StatusOK, code)
+	body, _ = json.Marshal(&reqTransactionQueuedItemCallback{Uid: uid, TxId: "0x2", Status: "failed",
+		ContractAddr: "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359", TokenId: "43"})
+	w = PerformRequest(router, "POST", "/transaction/cb", string(body))
+	assert.Equal(t, http.StatusOK, w.Code)
+	tokens = respObjectTokens{}
+	w = PerformRequest(router, "GET", "/object/"+cid+"/tokens", "")
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+	assert.Len(t, tokens.Tokens, 1)
+
+	w = PerformRequest(router, "GET", "/object/"+unknown+"/tokens", "")
+	assert.Equal(t, 451, w.Code)
+}
+
+// TestTxHistory tests querying transactions and their timelines
+func TestTxHistory(t *testing.T) {
+
+	router := SetupRouter()
+	registerTestCallbackDestination(t, "http://127.0.0.1:7890/cb")
+	for {
+		if _, code := leaseQueue(router, "drain", 100); code != http.StatusOK {
+			break
+		}
+	}
+
+	wallet, _ := utils.NormalizeEthereumAddr(fmt.Sprintf("%040x", time.Now().UnixNano()))
+	uids := []string{}
+	for i := 0; i < 3; i++ {
+		spec := reqTransactionEnqueueSpecAirdropErc20{
+			Uid:         utils.GenerateBase64Rand(),
+			WalletAddr:  wallet,
+			WalletKind:  "ethereum",
+			Quantity:    i + 1,
+			CallbackUri: "http://127.0.0.1:7890/cb/erc20",
+		}
+		uids = append(uids, spec.Uid)
+		body, _ := json.Marshal(&reqTransactionEnqueue{Kind: "erc20", Spec: &spec})
+		w := PerformRequest(router, "POST", "/transaction/enqueue", string(body))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	items, code := leaseQueue(router, "worker1", 1)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, uids[0], items[0].Spec.(map[string]interface{})["uid"])
+
+	// single transaction with timeline
+	w := PerformRequest(router, "GET", "/transaction/"+uids[0], "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tx respTransaction
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &tx))
+	assert.Equal(t, "inFlight", tx.Status)
+	if assert.Len(t, tx.Events, 2) {
+		assert.Equal(t, "pending", tx.Events[0].Status)
+		assert.Equal(t, "inFlight", tx.Events[1].Status)
+		assert.Equal(t, "worker1", tx.Events[1].Worker)
+	}
+
+	w = PerformRequest(router, "GET", "/transaction/nope"+uids[0], "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// filtered list, newest first
+	var list respTransactions
+	w = PerformRequest(router, "GET", "/transactions?walletAddr="+wallet, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, int64(3), list.Total)
+	if assert.Len(t, list.Transactions, 3) {
+		assert.Equal(t, uids[2], list.Transactions[0].Uid)
+	}
+
+	w = PerformRequest(router, "GET", "/transactions?status=pending&kind=erc20&limit=1&offset=1&walletAddr="+wallet, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	list = respTransactions{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, int64(2), list.Total)
+	if assert.Len(t, list.Transactions, 1) {
+		assert.Equal(t, uids[1], list.Transactions[0].Uid)
+	}
+
+	from := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	w = PerformRequest(router, "GET", "/transactions?from="+from+"&walletAddr="+wallet, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	list = respTransactions{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, int64(0), list.Total)
+
+	w = PerformRequest(router, "GET", "/transactions?status=lost", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = PerformRequest(router, "GET", "/transactions?from=yesterday", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestTxCallbackRace tests that concurrent reports of a transaction, like a worker retrying after a timeout, queue a
+// single callback
+func TestTxCallbackRace(t *testing.T) {
+
+	router := SetupRouter()
+	registerTestCallbackDestination(t, "http://127.0.0.1:7890/cb")
+	for {
+		if _, code := leaseQueue(router, "drain", 100); code != http.StatusOK {
+			break
+		}
+	}
+
+	spec := reqTransactionEnqueueSpecAirdropErc20{
+		Uid:         utils.GenerateBase64Rand(),
+		WalletAddr:  "d36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8",
+		WalletKind:  "ethereum",
+		Quantity:    5,
+		CallbackUri: "http://127.0.0.1:7890/cb/erc20",
+	}
+	body, _ := json.Marshal(&reqTransactionEnqueue{Kind: "erc20", Spec: &spec})
+	w := PerformRequest(router, "POST", "/transaction/enqueue", string(body))
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, code := leaseQueue(router, "worker1", 1)
+	assert.Equal(t, http.StatusOK, code)
+
+	body, _ = json.Marshal(&reqTransactionQueuedItemCallback{Uid: spec.Uid, TxId: "0x1", Status: "ok", Cost: "21000"})
+	codes := make([]int, 8)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = PerformRequest(router, "POST", "/transaction/cb", string(body)).Code
+		}(i)
+	}
+	wg.Wait()
+	ok := 0
+	for _, code := range codes {
+		if code == http.StatusOK {
+			ok++
+		} else {
+			assert.Equal(t, http.StatusConflict, code)
+		}
+	}
+	assert.Equal(t, 1, ok)
+
+	var tx models.Transaction
+	models.Db.Where("uid = ?", spec.Uid).First(&tx)
+	var callbacks, reported int64
+	models.Db.Model(&models.TransactionCallback{}).Where("transaction_id = ?", tx.ID).Count(&callbacks)
+	models.Db.Model(&models.TransactionEvent{}).Where("transaction_id = ? AND status = ?", tx.ID,
+		models.TRANSACTION_STATUS_PENDING_CB).Count(&reported)
+	assert.Equal(t, int64(1), callbacks)
+	assert.Equal(t, int64(1), reported)
+}
+
+// TestTxCallbackDelivery tests callbacks are retried with backoff, dead-lettered and redelivered
+func TestTxCallbackDelivery(t *testing.T) {
+
+	router := SetupRouter()
+	for {
+		if _, code := leaseQueue(router, "drain", 100); code != http.StatusOK {
+			break
+		}
+	}
+	models.Db.Model(&models.TransactionCallback{}).Where("status = ?", models.CALLBACK_STATUS_PENDING).
+		Update("status", models.CALLBACK_STATUS_DEAD)
+
+	var mutex sync.Mutex
+	var destination *models.CallbackDestination
+	failures := 1
+	received := []reqTransactionQueuedItemCallback{}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		body, err := webhook.VerifyRequest(r, []byte(destination.Secret), webhook.DefaultTolerance)
+		if !assert.Nil(t, err) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var request reqTransactionQueuedItemCallback
+		json.Unmarshal(body, &request)
+		received = append(received, request)
+	}))
+	defer receiver.Close()
+	destination = registerTestCallbackDestination(t, receiver.URL)
+
+	enqueueAndReport := func() string {
+		spec := reqTransactionEnqueueSpecAirdropErc20{
+			Uid:         utils.GenerateBase64Rand(),
+			WalletAddr:  "d36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8",
+			WalletKind:  "ethereum",
+			Quantity:    5,
+			CallbackUri: receiver.URL,
+		}
+		body, _ := json.Marshal(&reqTransactionEnqueue{Kind: "erc20", Spec: &spec})
+		w := PerformRequest(router, "POST", "/transaction/enqueue", string(body))
+		assert.Equal(t, http.StatusOK, w.Code)
+		_, code := leaseQueue(router, "worker1", 1)
+		assert.Equal(t, http.StatusOK, code)
+
+		body, _ = json.Marshal(&reqTransactionQueuedItemCallback{Uid: spec.Uid, TxId: "0x1", Status: "ok", Cost: "21000"})
+		w = PerformRequest(router, "POST", "/transaction/cb", string(body))
+		assert.Equal(t, http.StatusOK, w.Code)
+		w = PerformRequest(router, "POST", "/transaction/cb", string(body))
+		assert.Equal(t, http.StatusConflict, w.Code)
+		return spec.Uid
+	}
+	status := func(uid string) respTransaction {
+		w := PerformRequest(router, "GET", "/transaction/"+uid, "")
+		var tx respTransaction
+		json.Unmarshal(w.Body.Bytes(), &tx)
+		return tx
+	}
+	makeDue := func() {
+		models.Db.Model(&models.TransactionCallback{}).Where("status = ?", models.CALLBACK_STATUS_PENDING).
+			Update("next_attempt_at", time.Now().Add(-time.Second))
+	}
+
+	// first attempt fails, the retry is delivered
+	uid := enqueueAndReport()
+	DispatchTransactionCallbacks()
+	tx := status(uid)
+	assert.Equal(t, "pendingCallback", tx.Status)
+	assert.Equal(t, 1, tx.ErrorCount)
+	assert.Contains(t, tx.LastError, "503")
+	assert.NotNil(t, tx.LastErrorAt)
+
+	// not due yet
+	DispatchTransactionCallbacks()
+	assert.Len(t, received, 0)
+	makeDue()
+	DispatchTransactionCallbacks()
+	assert.Equal(t, "done", status(uid).Status)
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, uid, received[0].Uid)
+		assert.Equal(t, "21000", received[0].Cost)
+	}
+
+	// dead after max attempts, then redelivered by hand
+	viper.Set("services.sync.questori.maxAttempts", 2)
+	defer viper.Set("services.sync.questori.maxAttempts", 10)
+	failures = 2
+	uid = enqueueAndReport()
+	DispatchTransactionCallbacks()
+	makeDue()
+	DispatchTransactionCallbacks()
+	tx = status(uid)
+	assert.Equal(t, "callbackDead", tx.Status)
+	assert.Equal(t, 2, tx.ErrorCount)
+
+	w := PerformRequest(router, "POST", "/admin/transaction/"+uid+"/redeliver", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "pendingCallback", status(uid).Status)
+	DispatchTransactionCallbacks()
+	tx = status(uid)
+	assert.Equal(t, "done", tx.Status)
+	assert.Len(t, received, 2)
+	assert.Equal(t, "callback delivered", tx.Events[len(tx.Events)-1].Message)
+
+	w = PerformRequest(router, "POST", "/admin/transaction/nope/redeliver", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestTransactionCallbackRetryAt(t *testing.T) {
+
+	viper.Set("services.sync.questori.errorRetrySeconds", 20)
+	viper.Set("services.sync.questori.maxRetrySeconds", 100)
+	viper.Set("services.sync.questori.maxAttempts", 5)
+	defer viper.Set("services.sync.questori.maxRetrySeconds", 3600)
+	defer viper.Set("services.sync.questori.maxAttempts", 10)
+
+	now := time.Now()
+	for attempts, secs := range map[int]int{1: 20, 2: 40, 3: 80, 4: 100} {
+		retryAt := transactionCallbackRetryAt(now, attempts)
+		if assert.NotNil(t, retryAt) {
+			assert.Equal(t, time.Duration(secs)*time.Second, retryAt.Sub(now), "attempt %d", attempts)
+		}
+	}
+	assert.Nil(t, transactionCallbackRetryAt(now, 5))
+}
+
+// TestCallbackDestinations tests registering callback destinations and rotating their secrets
+func TestCallbackDestinations(t *testing.T) {
+
+	router := SetupRouter()
+
+	var signatures []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signatures = append(signatures, r.Header.Get(webhook.HeaderSignature))
+	}))
+	defer receiver.Close()
+
+	// register, the secret is only shown once
+	name := "dest-" + utils.GenerateBase64Rand()
+	w := PerformRequest(router, "POST", "/admin/callbackDestination", `{"name": "`+name+`", "url": "`+receiver.URL+`/hooks"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var d respCallbackDestination
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &d))
+	assert.True(t, strings.HasPrefix(d.Secret, "whsec_"))
+
+	w = PerformRequest(router, "GET", "/admin/callbackDestinations", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), name)
+	assert.NotContains(t, w.Body.String(), d.Secret)
+
+	for _, bad := range []string{"ftp://example.com", "/hooks", "http://user:pw@example.com", "http://example.com/?a=1"} {
+		w = PerformRequest(router, "POST", "/admin/callbackDestination", `{"name": "bad", "url": "`+bad+`"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code, bad)
+	}
+
+	// only URIs under the destination are accepted
+	enqueue := func(callbackUri string) int {
+		spec := reqTransactionEnqueueSpecAirdropErc20{
+			Uid:         utils.GenerateBase64Rand(),
+			WalletAddr:  "d36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8",
+			WalletKind:  "ethereum",
+			Quantity:    1,
+			CallbackUri: callbackUri,
+		}
+		body, _ := json.Marshal(&reqTransactionEnqueue{Kind: "erc20", Spec: &spec})
+		return PerformRequest(router, "POST", "/transaction/enqueue", string(body)).Code
+	}
+	assert.Equal(t, http.StatusOK, enqueue(receiver.URL+"/hooks/erc20"))
+	assert.Equal(t, http.StatusBadRequest, enqueue(receiver.URL+"/hooksevil"))
+	assert.Equal(t, http.StatusBadRequest, enqueue(receiver.URL+"/other"))
+	assert.Equal(t, http.StatusBadRequest, enqueue("http://203.0.113.1/hooks"))
+
+	// rotated secrets sign with both until the grace period ends
+	var destination models.CallbackDestination
+	models.Db.First(&destination, d.Id)
+	w = PerformRequest(router, "POST", fmt.Sprintf("/admin/callbackDestination/%d/rotateSecret", d.Id), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var rotated respCallbackDestination
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &rotated))
+	assert.NotEqual(t, d.Secret, rotated.Secret)
+	assert.NotNil(t, rotated.PreviousSecretExpiresAt)
+
+	models.Db.First(&destination, d.Id)
+	secrets := destination.Secrets(time.Now())
+	assert.Equal(t, [][]byte{[]byte(rotated.Secret), []byte(d.Secret)}, secrets)
+	assert.Len(t, destination.Secrets(time.Now().Add(48*time.Hour)), 1)
+
+	// disabled destinations take no new transactions
+	w = PerformRequest(router, "DELETE", fmt.Sprintf("/admin/callbackDestination/%d", d.Id), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusBadRequest, enqueue(receiver.URL+"/hooks/erc20"))
+	w = PerformRequest(router, "DELETE", "/admin/callbackDestination/999999999", "")
+	assert.Equal(t, 451, w.Code)
+}
+
+// TestLegacyCallbackDestinations tests that transactions and callbacks saved before destinations existed get one
+func TestLegacyCallbackDestinations(t *testing.T) {
+
+	router := SetupRouter()
+
+	host := "legacy-" + strings.ToLower(utils.GenerateBase64Rand()) + ".example.com"
+	tx := models.Transaction{
+		Uid:         utils.GenerateBase64Rand(),
+		Kind:        "erc20",
+		Status:      models.TRANSACTION_STATUS_PENDING_CB,
+		CallbackUri: "https://" + host + "/cb/erc20",
+	}
+	assert.Nil(t, models.Db.Create(&tx).Error)
+	cb := models.TransactionCallback{TransactionID: tx.ID, Uri: "https://" + host + "/cb/erc721",
+		Status: models.CALLBACK_STATUS_PENDING, NextAttemptAt: time.Now().Add(time.Hour)}
+	assert.Nil(t, models.Db.Create(&cb).Error)
+
+	n, err := models.RegisterLegacyCallbackDestinations(models.Db)
+	assert.Nil(t, err)
+	assert.True(t, n >= 1)
+	models.Db.First(&tx, tx.ID)
+	models.Db.First(&cb, cb.ID)
+	assert.NotZero(t, tx.CallbackDestinationID)
+	assert.Equal(t, tx.CallbackDestinationID, cb.DestinationID)
+	var d models.CallbackDestination
+	models.Db.First(&d, cb.DestinationID)
+	assert.Equal(t, "https://"+host+"/", d.Url)
+
+	// once registered, nothing is left to register and the host takes new transactions
+	n, err = models.RegisterLegacyCallbackDestinations(models.Db)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	spec := reqTransactionEnqueueSpecAirdropErc20{
+		Uid:         utils.GenerateBase64Rand(),
+		WalletAddr:  "d36E5AEaBa5f35997e374Bb5D1A10B770aACE6e8",
+		WalletKind:  "ethereum",
+		Quantity:    1,
+		CallbackUri: "https://" + host + "/cb/erc20",
+	}
+	body, _ := json.Marshal(&reqTransactionEnqueue{Kind: "erc20", Spec: &spec})
+	w := PerformRequest(router, "POST", "/transaction/enqueue", string(body))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// legacy receivers keep getting the App-Key while it is configured
+	assert.True(t, d.LegacyAppKey)
+	var appKey string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appKey = r.Header.Get("App-Key")
+	}))
+	defer receiver.Close()
+	legacyCb := models.TransactionCallback{TransactionID: tx.ID, Uri: receiver.URL + "/cb/erc20",
+		Status: models.CALLBACK_STATUS_PENDING, NextAttemptAt: time.Now().Add(time.Hour)}
+	assert.Nil(t, models.Db.Create(&legacyCb).Error)
+	n, err = models.RegisterLegacyCallbackDestinations(models.Db)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	models.Db.First(&legacyCb, legacyCb.ID)
+
+	viper.Set("services.sync.questori.apiKey", "legacy-key")
+	assert.Nil(t, deliverTransactionCallback(http.DefaultClient, &legacyCb))
+	assert.Equal(t, "legacy-key", appKey)
+	viper.Set("services.sync.questori.apiKey", "")
+	assert.Nil(t, deliverTransactionCallback(http.DefaultClient, &legacyCb))
+	assert.Equal(t, "", appKey)
+}
+
+// TestTxCampaigns tests that transactions in a campaign are held to its limits and reported costs count against its
+// budget
+func TestTxCampaigns(t *testing.T) {
+
+	router := SetupRouter()
+	registerTestCallbackDestination(t, "http://127.0.0.1:7890/cb")
+	for {
+		if _, code := leaseQueue(router, "drain", 100); code != http.StatusOK {
+			break
+		}
+	}
+
+	name := "campaign-" + utils.GenerateBase64Rand()
+	w := PerformRequest(router, "POST", "/admin/campaign", `{"name": "`+name+`", "chainId": "1337", "budget": "50000",
+		"estimatedCost": "10000", "maxPerWallet": 2, "maxPerDay": 4, "maxTokenQuantity": 10, "maxTotalTokenQuantity": 25}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var campaign respCampaign
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &campaign))
+	assert.Equal(t, "0", campaign.Spent)
+
+	for _, bad := range []string{
+		`{"name": "` + name + `"}`,
+		`{"name": ""}`,
+		`{"name": "bad", "budget": "0.5", "estimatedCost": "1"}`,
+		`{"name": "bad", "budget": "100"}`,
+		`{"name": "bad", "budget": "100", "estimatedCost": "0"}`,
+		`{"name": "bad", "maxPerDay": -1}`,
+		`{"name": "bad", "chainId": "137"}`,
+		`{"name": "bad", "startsAt": "2026-10-19T00:00:00Z", "endsAt": "2026-10-18T00:00:00Z"}`,
+	} {
+		w = PerformRequest(router, "POST", "/admin/campaign", bad)
+		assert.Equal(t, http.StatusBadRequest, w.Code, bad)
+	}
+
+	wallets := make([]string, 3)
+	for i := range wallets {
+		wallets[i], _ = utils.NormalizeEthereumAddr(fmt.Sprintf("%039x%d", time.Now().UnixNano(), i))
+	}
+	enqueue := func(campaign string, chainId string, wallet string, quantity int) (string, int) {
+		spec := reqTransactionEnqueueSpecAirdropErc20{
+			Uid:         utils.GenerateBase64Rand(),
+			WalletAddr:  wallet,
+			WalletKind:  "ethereum",
+			Quantity:    quantity,
+			CallbackUri: "http://127.0.0.1:7890/cb/erc20",
+		}
+		body, _ := json.Marshal(&reqTransactionEnqueue{Kind: "erc20", ChainId: chainId, Campaign: campaign, Spec: &spec})
+		return spec.Uid, PerformRequest(router, "POST", "/transaction/enqueue", string(body)).Code
+	}
+
+	_, code := enqueue("nope-"+name, "", wallets[0], 1)
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = enqueue(name, "1", wallets[0], 1)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// native currency sent with a call would not count against the budget
+	body, _ := json.Marshal(&reqTransactionEnqueue{Kind: "contractCall", Campaign: name, Spec: &reqTransactionEnqueueSpecContractCall{
+		Uid: utils.GenerateBase64Rand(), WalletAddr: wallets[0], WalletKind: "ethereum", ContractAddr: wallets[1],
+		Method: "pause()", Value: "1", CallbackUri: "http://127.0.0.1:7890/cb/call"}})
+	w = PerformRequest(router, "POST", "/transaction/enqueue", string(body))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	_, code = enqueue(name, "", wallets[0], 11)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	// per wallet, retries of an enqueued transaction are not counted again
+	uids := []string{}
+	for i := 0; i < 2; i++ {
+		uid, code := enqueue(name, "", wallets[0], 5)
+		assert.Equal(t, http.StatusOK, code)
+		uids = append(uids, uid)
+	}
+	_, code = enqueue(name, "", wallets[0], 5)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	var tx models.Transaction
+	models.Db.Where("uid = ?", uids[0]).First(&tx)
+	body, _ = json.Marshal(&reqTransactionEnqueue{Kind: "erc20", Campaign: name, Spec: &reqTransactionEnqueueSpecAirdropErc20{
+		Uid: tx.Uid, WalletAddr: tx.WalletAddr, WalletKind: "ethereum", Quantity: 5, CallbackUri: tx.CallbackUri}})
+	w = PerformRequest(router, "POST", "/transaction/enqueue", string(body))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// total tokens, then per day
+	_, code = enqueue(name, "", wallets[1], 10)
+	assert.Equal(t, http.StatusOK, code)
+	_, code = enqueue(name, "", wallets[1], 6)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	uid, code := enqueue(name, "", wallets[1], 5)
+	assert.Equal(t, http.StatusOK, code)
+	uids = append(uids, uid)
+	models.Db.Model(&models.Campaign{}).Where("id = ?", campaign.Id).Update("max_total_token_quantity", 0)
+	_, code = enqueue(name, "", wallets[2], 1)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	// reported costs are spent from the budget, which closes the campaign
+	items, code := leaseQueue(router, "worker1", 10)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, items, 4)
+	for i, cost := range []string{"20000", "30000"} {
+		body, _ := json.Marshal(&reqTransactionQueuedItemCallback{Uid: uids[i], TxId: "0x1", Status: "ok", Cost: cost})
+		w = PerformRequest(router, "POST", "/transaction/cb", string(body))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	// a repeated report is not spent twice
+	body, _ = json.Marshal(&reqTransactionQueuedItemCallback{Uid: uids[0], TxId: "0x1", Status: "ok", Cost: "20000"})
+	w = PerformRequest(router, "POST", "/transaction/cb", string(body))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	body, _ = json.Marshal(&reqTransactionQueuedItemCallback{Uid: uids[2], TxId: "0x1", Status: "ok", Cost: "1.5"})
+	w = PerformRequest(router, "POST", "/transaction/cb", string(body))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = PerformRequest(router, "PUT", fmt.Sprintf("/admin/campaign/%d", campaign.Id), `{"chainId": "1337", "budget": "50000", "estimatedCost": "10000"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, code = enqueue(name, "", wallets[2], 1)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	w = PerformRequest(router, "GET", "/admin/campaigns", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list respCampaigns
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &list))
+	for _, c := range list.Campaigns {
+		if c.Id == campaign.Id {
+			assert.Equal(t, "50000", c.Spent)
+			assert.Equal(t, int64(4), c.Transactions)
+			assert.Equal(t, 0, c.MaxPerDay)
+		}
 	}
-	txNftBody, _ := json.Marshal(&txErc721)
 
-	w := PerformRequest(router, "POST", "/transaction/enqueue", string(txNftBody))
+	// a bigger budget opens it again until it is disabled
+	w = PerformRequest(router, "PUT", fmt.Sprintf("/admin/campaign/%d", campaign.Id), `{"budget": "100000", "estimatedCost": "10000"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, code = enqueue(name, "", wallets[2], 1)
+	assert.Equal(t, http.StatusOK, code)
+	w = PerformRequest(router, "DELETE", fmt.Sprintf("/admin/campaign/%d", campaign.Id), "")
 	assert.Equal(t, http.StatusOK, w.Code)
+	_, code = enqueue(name, "", wallets[2], 1)
+	assert.Equal(t, http.StatusForbidden, code)
+	w = PerformRequest(router, "DELETE", "/admin/campaign/999999999", "")
+	assert.Equal(t, 451, w.Code)
 
-	// wait for the callback with contract address from the transactor
-	time.Sleep(5 * time.Second)
-	assert.NotEmpty(t, contractAddr)
+	// transactions not yet reported reserve the estimated cost, so enqueues stop before the budget is overspent
+	name = "campaign-" + utils.GenerateBase64Rand()
+	w = PerformRequest(router, "POST", "/admin/campaign", `{"name": "`+name+`", "budget": "25000", "estimatedCost": "10000"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	uids = []string{}
+	for i := 0; i < 2; i++ {
+		uid, code := enqueue(name, "", wallets[i], 1)
+		assert.Equal(t, http.StatusOK, code)
+		uids = append(uids, uid)
+	}
+	_, code = enqueue(name, "", wallets[2], 1)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	_, code = leaseQueue(router, "worker1", 10)
+	assert.Equal(t, http.StatusOK, code)
+	_, code = enqueue(name, "", wallets[2], 1)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	body, _ = json.Marshal(&reqTransactionQueuedItemCallback{Uid: uids[0], TxId: "0x1", Status: "ok", Cost: "5000"})
+	w = PerformRequest(router, "POST", "/transaction/cb", string(body))
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, code = enqueue(name, "", wallets[2], 1)
+	assert.Equal(t, http.StatusOK, code)
 }
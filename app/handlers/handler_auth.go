@@ -127,6 +127,57 @@ func SetupAuth(r *gin.Engine) *jwt.GinJWTMiddleware {
 	return authMiddleware
 }
 
+// optionalJWT runs the JWT middleware only when the request carries a token, so App-Key endpoints
+// can also learn which user is calling
+func optionalJWT(mw *jwt.GinJWTMiddleware) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" && c.Query("token") == "" {
+			if _, err := c.Cookie("jwt"); err != nil {
+				return
+			}
+		}
+		mw.MiddlewareFunc()(c)
+	}
+}
+
+// jwtUserUid returns the user UID from the JWT, ok is false when the request had no JWT
+func jwtUserUid(c *gin.Context) (uid string, ok bool) {
+	userjwt, exists := c.Get(IdentityKey)
+	if !exists {
+		return "", false
+	}
+	user, ok := userjwt.(*UserJWT)
+	if !ok || user.Uid == "" {
+		return "", false
+	}
+	return user.Uid, true
+}
+
+// canPublishAs returns true if user uid may publish objects owned by ownerId, either because it is
+// the owner or because auth.delegates lets it publish for the owner
+func canPublishAs(uid string, ownerId string, ownerProvider string) bool {
+	if uid == ownerId {
+		return true
+	}
+
+	var delegates []struct {
+		Delegate string `mapstructure:"delegate"`
+		Owner    string `mapstructure:"owner"`
+		Provider string `mapstructure:"provider"`
+	}
+	err := viper.UnmarshalKey("auth.delegates", &delegates)
+	if err != nil {
+		glog.Errorf("cannot read auth.delegates %v", err)
+		return false
+	}
+	for _, d := range delegates {
+		if d.Delegate == uid && d.Owner == ownerId && (d.Provider == "" || d.Provider == ownerProvider) {
+			return true
+		}
+	}
+	return false
+}
+
 // ValJWT validates the JWT and returns user UID
 func ValJWT(c *gin.Context) string {
 	userjwt, _ := c.Get(IdentityKey)
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+
+	"github.com/wos-project/wos-core-go/app/models"
+	"github.com/wos-project/wos-core-go/app/utils"
+)
+
+// findObjectByCid finds an arc, pin or pinned arc by cid.  Returns the object and the record holding it, for saving.
+func findObjectByCid(cid string) (*models.Object, interface{}, error) {
+
+	var arc models.Arc
+	res := models.Db.Where("cid = ?", cid).First(&arc)
+	if res.RowsAffected == 1 {
+		return &arc.Object, &arc, nil
+	}
+	var pin models.Pin
+	res = models.Db.Where("cid = ?", cid).First(&pin)
+	if res.RowsAffected == 1 {
+		return &pin.Object, &pin, nil
+	}
+	var pa models.PinnedArc
+	res = models.Db.Where("cid = ?", cid).First(&pa)
+	if res.RowsAffected == 1 {
+		return &pa.Object, &pa, nil
+	}
+	return nil, nil, fmt.Errorf("cannot find object %s", cid)
+}
+
+// validObjectPrivacy returns true if the index privacy and visibility are known values, empty means default
+func validObjectPrivacy(privacy string, visibility string) bool {
+	switch privacy {
+	case "", models.ObjectPrivacyPublic, models.ObjectPrivacyFollowers, models.ObjectPrivacyPrivate:
+	default:
+		return false
+	}
+	switch visibility {
+	case "", models.ObjectVisibilityVisible, models.ObjectVisibilityUnlisted:
+	default:
+		return false
+	}
+	return true
+}
+
+// canViewObject returns true if viewer may fetch the object, viewerUid is empty for anonymous requests
+func canViewObject(obj *models.Object, viewerUid string) bool {
+
+	if obj.Privacy == "" || obj.Privacy == models.ObjectPrivacyPublic {
+		return true
+	}
+	if viewerUid == "" {
+		return false
+	}
+	if viewerUid == obj.UploaderUid || canPublishAs(viewerUid, obj.OwnerUid, obj.OwnerProvider) {
+		return true
+	}
+	if obj.Privacy == models.ObjectPrivacyFollowers {
+		return models.IsFollowing(viewerUid, obj.OwnerUid)
+	}
+	return false
+}
+
+// searchableObjectsClause returns a where clause limiting search results in table to objects the viewer may list
+func searchableObjectsClause(table string, viewerUid string) (string, []interface{}) {
+
+	visible := fmt.Sprintf("%[1]s.visibility IN ('', '%[2]s') AND %[1]s.privacy IN ('', '%[3]s')",
+		table, models.ObjectVisibilityVisible, models.ObjectPrivacyPublic)
+	if viewerUid == "" {
+		return visible, []interface{}{}
+	}
+
+	followed := fmt.Sprintf("%[1]s.visibility IN ('', '%[2]s') AND %[1]s.privacy = '%[3]s' AND "+
+		"%[1]s.owner_uid IN (SELECT followee_uid FROM follows WHERE follower_uid = ? AND deleted_at IS NULL)",
+		table, models.ObjectVisibilityVisible, models.ObjectPrivacyFollowers)
+	return fmt.Sprintf("(%s) OR (%s) OR %s.owner_uid = ?", visible, followed, table), []interface{}{viewerUid, viewerUid}
+}
+
+// objectMediaAccess rejects media requests for objects the viewer may not fetch.
+// The request path after prefix starts with the object cid.
+func objectMediaAccess(prefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rel := strings.TrimPrefix(strings.TrimPrefix(c.Request.URL.Path, prefix), "/")
+		cid := strings.SplitN(rel, "/", 2)[0]
+
+		obj, _, err := findObjectByCid(cid)
+		if err != nil {
+			c.AbortWithStatusJSON(451, gin.H{"error": ""})
+			return
+		}
+		viewerUid, _ := jwtUserUid(c)
+		if !canViewObject(obj, viewerUid) {
+			c.AbortWithStatusJSON(451, gin.H{"error": ""})
+			return
+		}
+	}
+}
+
+// signedMediaAccess lets through media requests signed by LocalSimpleDriver.GetExpiringURL.  Unsigned requests
+// get the object privacy check, or are rejected when media.schemes.localSimple.requireSignature is set.
+func signedMediaAccess(prefix string) gin.HandlerFunc {
+	access := objectMediaAccess(prefix)
+	return func(c *gin.Context) {
+		exp := c.Query("exp")
+		sig := c.Query("sig")
+		if exp != "" || sig != "" {
+			key := strings.TrimPrefix(strings.TrimPrefix(c.Request.URL.Path, prefix), "/")
+			if !utils.VerifyLocalMediaSignature(key, exp, sig, time.Now()) {
+				c.AbortWithStatusJSON(401, gin.H{"error": ""})
+			}
+			return
+		}
+		if viper.GetBool("media.schemes.localSimple.requireSignature") {
+			c.AbortWithStatusJSON(401, gin.H{"error": ""})
+			return
+		}
+		access(c)
+	}
+}
+
+// legacyMediaRedirect redirects requests to the old <prefix>/<webPath>/<cid>/<path> media URLs to signed URLs, after
+// the object privacy check, so links handed out before media URLs were signed keep working.
+func legacyMediaRedirect(prefix string, webPath string) gin.HandlerFunc {
+	legacyPrefix := path.Join(prefix, webPath)
+	access := objectMediaAccess(legacyPrefix)
+	return func(c *gin.Context) {
+		if !strings.HasPrefix(c.Request.URL.Path, legacyPrefix+"/") {
+			return
+		}
+		key := strings.TrimPrefix(path.Clean(strings.TrimPrefix(c.Request.URL.Path, legacyPrefix)), "/")
+		access(c)
+		if c.IsAborted() {
+			return
+		}
+		u, _, err := utils.LocalSimple.GetExpiringURL(key)
+		if err != nil {
+			glog.Errorf("cannot sign legacy media url %s %v", key, err)
+			c.AbortWithStatusJSON(500, gin.H{"error": ""})
+			return
+		}
+		c.Redirect(http.StatusTemporaryRedirect, u)
+		c.Abort()
+	}
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+
+	"github.com/wos-project/wos-core-go/app/models"
+	"github.com/wos-project/wos-core-go/app/utils"
+)
+
+// erc721MetadataFilename is the name of the pinned token metadata, the token uri is ipfs://<cid>/<name>
+const erc721MetadataFilename = "metadata.json"
+
+// erc721Metadata is the ERC-721 metadata JSON schema, with the attributes and animation_url of OpenSea
+type erc721Metadata struct {
+	Name         string            `json:"name"`
+	Description  string            `json:"description,omitempty"`
+	Image        string            `json:"image,omitempty"`
+	AnimationUrl string            `json:"animation_url,omitempty"`
+	Attributes   []erc721Attribute `json:"attributes"`
+}
+
+type erc721Attribute struct {
+	TraitType   string      `json:"trait_type,omitempty"`
+	DisplayType string      `json:"display_type,omitempty"`
+	Value       interface{} `json:"value"`
+}
+
+type respObjectToken struct {
+	ChainId      string    `json:"chainId"`
+	ContractAddr string    `json:"contractAddr"`
+	TokenId      string    `json:"tokenId"`
+	WalletAddr   string    `json:"walletAddr"`
+	WalletKind   string    `json:"walletKind"`
+	TokenUri     string    `json:"tokenUri,omitempty"`
+	MintedAt     time.Time `json:"mintedAt"`
+}
+
+type respObjectTokens struct {
+	Cid    string            `json:"cid"`
+	Tokens []respObjectToken `json:"tokens"`
+}
+
+func (r *respObjectToken) MarshalFromObjectToken(t *models.ObjectToken) {
+	r.ChainId = t.ChainId
+	r.ContractAddr = t.ContractAddr
+	r.TokenId = t.TokenId
+	r.WalletAddr = t.WalletAddr
+	r.WalletKind = t.WalletKind
+	r.TokenUri = t.TokenUri
+	r.MintedAt = t.CreatedAt
+}
+
+// objectIpfsUri returns the ipfs uri of uri, a path in the body of object cid like /media/a.mp3.  Uris with a
+// scheme are already absolute.
+func objectIpfsUri(cid string, uri string) string {
+	if uri == "" || strings.Contains(uri, "://") {
+		return uri
+	}
+	return "ipfs://" + cid + path.Clean("/"+uri)
+}
+
+// objectIndexSpec returns the index of obj and its spec
+func objectIndexSpec(obj *models.Object) (*reqObject, map[string]interface{}, error) {
+	b, err := obj.Body.MarshalJSON()
+	if err != nil {
+		return nil, nil, err
+	}
+	var index reqObject
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, nil, fmt.Errorf("cannot read index of %s %v", obj.Cid, err)
+	}
+	spec, _ := index.Spec.(map[string]interface{})
+	return &index, spec, nil
+}
+
+// objectErc721Metadata returns the token metadata of obj with image.  Attributes are the kind, fidelity and creation
+// date of the object, followed by the attributes of its spec.
+func objectErc721Metadata(obj *models.Object, image string) (*erc721Metadata, error) {
+
+	index, spec, err := objectIndexSpec(obj)
+	if err != nil {
+		return nil, err
+	}
+	metadata := erc721Metadata{
+		Name:        obj.Name,
+		Description: obj.Description,
+		Image:       image,
+		Attributes:  []erc721Attribute{{TraitType: "kind", Value: index.Kind}},
+	}
+	for _, f := range index.Metadata.Fidelity {
+		metadata.Attributes = append(metadata.Attributes, erc721Attribute{TraitType: "fidelity", Value: f})
+	}
+	if !obj.CreatedAtInner.IsZero() {
+		metadata.Attributes = append(metadata.Attributes,
+			erc721Attribute{TraitType: "created", DisplayType: "date", Value: obj.CreatedAtInner.Unix()})
+	}
+
+	if representations, ok := spec["representation"].([]interface{}); ok && len(representations) > 0 {
+		if r, ok := representations[0].(map[string]interface{}); ok {
+			uri, _ := r["uri"].(string)
+			metadata.AnimationUrl = objectIpfsUri(obj.Cid, uri)
+		}
+	}
+	if attributes, ok := spec["attributes"].([]interface{}); ok {
+		for _, a := range attributes {
+			b, err := json.Marshal(a)
+			if err != nil {
+				continue
+			}
+			var attribute erc721Attribute
+			if json.Unmarshal(b, &attribute) == nil && attribute.Value != nil {
+				metadata.Attributes = append(metadata.Attributes, attribute)
+			}
+		}
+	}
+	return &metadata, nil
+}
+
+// objectCoverImage returns the path of the cover image of obj and the media cache key of its thumbnail, see
+// transactions.erc721.imageThumbnail.  The key is empty when the cover has no such thumbnail.
+func objectCoverImage(obj *models.Object) (string, string, error) {
+
+	_, spec, err := objectIndexSpec(obj)
+	if err != nil {
+		return "", "", err
+	}
+	cover, _ := spec["coverImageUri"].(string)
+	if cover == "" || strings.Contains(cover, "://") {
+		return cover, "", nil
+	}
+	cover = strings.TrimPrefix(path.Clean("/"+cover), "/")
+
+	var manifest utils.MediaManifest
+	b, err := obj.Files.MarshalJSON()
+	if err == nil {
+		err = json.Unmarshal(b, &manifest)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("cannot read file manifest %s %v", obj.Cid, err)
+	}
+	profile := viper.GetString("transactions.erc721.imageThumbnail")
+	if profile == "" {
+		profile = utils.Thumb_p1080
+	}
+	if f, ok := manifest[cover]; ok && f.Thumbnails[profile] != "" {
+		return cover, path.Join(obj.Cid, f.Thumbnails[profile]), nil
+	}
+	return cover, "", nil
+}
+
+// pinObjectErc721Metadata pins the token metadata of obj to IPFS and returns its uri.  The cover thumbnail is pinned
+// as the image, as thumbnails are only in the media cache, otherwise the image is the cover in the object itself.
+func pinObjectErc721Metadata(obj *models.Object) (string, error) {
+
+	cover, thumbnailKey, err := objectCoverImage(obj)
+	if err != nil {
+		return "", err
+	}
+	image := objectIpfsUri(obj.Cid, cover)
+	if thumbnailKey != "" {
+		tempDirPath, err := os.MkdirTemp(viper.GetString("media.uploadTemp.path"), "")
+		if err != nil {
+			return "", fmt.Errorf("cannot create temp dir %v", err)
+		}
+		defer os.RemoveAll(tempDirPath)
+
+		thumbnailPath := path.Join(tempDirPath, "image"+path.Ext(thumbnailKey))
+		if err := utils.Cache.Download(thumbnailPath, thumbnailKey); err != nil {
+			return "", fmt.Errorf("cannot get cover thumbnail %s %v", thumbnailKey, err)
+		}
+		imageCid, err := utils.Ipfs.UploadFile(thumbnailPath, "/")
+		if err != nil {
+			return "", err
+		}
+		image = "ipfs://" + imageCid + "/" + path.Base(thumbnailPath)
+	}
+
+	metadata, err := objectErc721Metadata(obj, image)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal token metadata %v", err)
+	}
+	metadataCid, err := utils.Ipfs.UploadString(string(b), erc721MetadataFilename)
+	if err != nil {
+		return "", err
+	}
+	return "ipfs://" + metadataCid + "/" + erc721MetadataFilename, nil
+}
+
+// HandleObjectTokensGet godoc
+// @Summary HandleObjectTokensGet returns the tokens minted for an Object and the wallets they were minted to
+// @Produce json
+// @Param cid path string true "object address"
+// @Success 200 object respObjectTokens success "minted tokens, oldest first"
+// @Failure 400 {string} error "Request params wrong"
+// @Failure 451 {string} error "Cannot find object"
+// @Failure 500 {string} error "Internal error"
+// @Router /object/{cid}/tokens [get]
+func HandleObjectTokensGet(c *gin.Context) {
+
+	cid := c.Param("cid")
+	if cid == "" {
+		glog.Errorf("cid parameter missing")
+		c.JSON(400, gin.H{"error": ""})
+		return
+	}
+
+	obj, _, err := findObjectByCid(cid)
+	if err != nil {
+		c.JSON(451, gin.H{"error": ""})
+		return
+	}
+	viewerUid, _ := jwtUserUid(c)
+	if !canViewObject(obj, viewerUid) {
+		c.JSON(451, gin.H{"error": ""})
+		return
+	}
+
+	tokens, err := models.FindObjectTokens(cid)
+	if err != nil {
+		glog.Error(err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	resp := respObjectTokens{Cid: cid, Tokens: make([]respObjectToken, len(tokens))}
+	for i := range tokens {
+		resp.Tokens[i].MarshalFromObjectToken(&tokens[i])
+	}
+	c.JSON(200, resp)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/wos-project/wos-core-go/app/models"
+)
+
+func TestObjectErc721Metadata(t *testing.T) {
+
+	obj := models.Object{
+		Cid:            "bafy1",
+		Name:           "example-mp4",
+		Description:    "example simple audio mp4",
+		CreatedAtInner: time.Date(2021, 12, 15, 1, 1, 1, 0, time.UTC),
+	}
+	assert.Nil(t, obj.Body.UnmarshalJSON([]byte(testArcIndex)))
+	assert.Nil(t, obj.Files.UnmarshalJSON([]byte(`{
+		"media/example-cover-image.jpg": {"path": "media/example-cover-image.jpg", "thumbnails": {"p1080": "media/example-cover-image_p1080.jpg"}}
+	}`)))
+
+	cover, key, err := objectCoverImage(&obj)
+	assert.Nil(t, err)
+	assert.Equal(t, "media/example-cover-image.jpg", cover)
+	assert.Equal(t, "bafy1/media/example-cover-image_p1080.jpg", key)
+
+	metadata, err := objectErc721Metadata(&obj, "ipfs://bafy2/image.jpg")
+	assert.Nil(t, err)
+	assert.Equal(t, &erc721Metadata{
+		Name:         "example-mp4",
+		Description:  "example simple audio mp4",
+		Image:        "ipfs://bafy2/image.jpg",
+		AnimationUrl: "ipfs://bafy1/media/hello.mp3",
+		Attributes: []erc721Attribute{
+			{TraitType: "kind", Value: "arc"},
+			{TraitType: "fidelity", Value: "phone"},
+			{TraitType: "fidelity", Value: "earbuds"},
+			{TraitType: "created", DisplayType: "date", Value: int64(1639530061)},
+			{TraitType: "place", Value: "Charlestown"},
+		},
+	}, metadata)
+
+	// without a thumbnail the image is the cover in the object
+	obj.Files = nil
+	_, key, err = objectCoverImage(&obj)
+	assert.Nil(t, err)
+	assert.Equal(t, "", key)
+
+	assert.Equal(t, "ipfs://bafy1/media/a.jpg", objectIpfsUri("bafy1", "/media/../media/a.jpg"))
+	assert.Equal(t, "ipfs://bafy1/a.jpg", objectIpfsUri("bafy1", "../../a.jpg"))
+	assert.Equal(t, "https://example.com/a.jpg", objectIpfsUri("bafy1", "https://example.com/a.jpg"))
+}
@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"path"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 
@@ -13,11 +17,15 @@ import (
 	"github.com/spf13/viper"
 
 	_ "github.com/wos-project/wos-core-go/app/docs" // docs is generated by Swag CLI, you have to import it.
+	"github.com/wos-project/wos-core-go/app/models"
 )
 
 // AuthMiddleware is JWT authorizer
 var AuthMiddleware *jwt.GinJWTMiddleware
 
+// apiKeyContextKey is where validateAPIKey stores the *models.ApiKey in the gin context
+const apiKeyContextKey = "apiKey"
+
 func helloHandler(c *gin.Context) {
 	claims := jwt.ExtractClaims(c)
 	user, _ := c.Get(IdentityKey)
@@ -28,16 +36,65 @@ func helloHandler(c *gin.Context) {
 	})
 }
 
-func validateAPIKey() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		APIKey := c.Request.Header.Get(viper.GetString("auth.apiKey.key"))
+// legacyApiKeyScopes are the scopes of the shared auth.apiKey.value unless auth.apiKey.legacyScopes sets them, all
+// that the shared key could do before keys had scopes
+var legacyApiKeyScopes = []string{models.ApiKeyScopeObjectWrite, models.ApiKeyScopeTxEnqueue, models.ApiKeyScopeTxWorker}
+
+var legacyApiKeyWarning sync.Once
+
+// legacyApiKey returns a key with the scopes of auth.apiKey.legacyScopes if clear is the deprecated shared
+// auth.apiKey.value, or nil.  It lets clients move to minted keys before the value is removed from the config.
+func legacyApiKey(clear string) *models.ApiKey {
+
+	value := viper.GetString("auth.apiKey.value")
+	if value == "" || subtle.ConstantTimeCompare([]byte(clear), []byte(value)) != 1 {
+		return nil
+	}
+	legacyApiKeyWarning.Do(func() {
+		glog.Warning("auth.apiKey.value is deprecated, mint scoped api keys with -mintApiKey and remove it")
+	})
+
+	scopes := legacyApiKeyScopes
+	if viper.IsSet("auth.apiKey.legacyScopes") {
+		scopes = []string{}
+		for _, s := range viper.GetStringSlice("auth.apiKey.legacyScopes") {
+			if !models.ValidApiKeyScope(s) {
+				glog.Errorf("ignoring unknown auth.apiKey.legacyScopes scope %s", s)
+				continue
+			}
+			scopes = append(scopes, s)
+		}
+	}
+	return &models.ApiKey{Name: "legacy", Prefix: "value", Scopes: strings.Join(scopes, ",")}
+}
 
-		if APIKey != viper.GetString("auth.apiKey.value") {
-			c.JSON(401, gin.H{"status": 401, "message": "Authentication failed"})
+// validateAPIKey checks the App-Key header against the stored api keys and requires scope
+func validateAPIKey(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clear := c.Request.Header.Get(viper.GetString("auth.apiKey.key"))
+		key, err := models.FindApiKey(clear)
+		if err != nil {
+			if legacy := legacyApiKey(clear); legacy != nil {
+				key, err = legacy, nil
+			}
+		}
+		if err != nil || !key.IsActive(time.Now()) {
+			c.AbortWithStatusJSON(401, gin.H{"status": 401, "message": "Authentication failed"})
+			return
+		}
+		if !key.HasScope(scope) {
+			c.AbortWithStatusJSON(403, gin.H{"status": 403, "message": "Api key missing scope " + scope})
 			return
 		}
 
-		return
+		// don't write on every request, the legacy key is not stored
+		if key.ID != 0 && (key.LastUsedAt == nil || time.Since(*key.LastUsedAt) > time.Minute) {
+			res := models.Db.Model(key).UpdateColumn("last_used_at", time.Now())
+			if res.Error != nil {
+				glog.Errorf("cannot update api key last used %v", res.Error)
+			}
+		}
+		c.Set(apiKeyContextKey, key)
 	}
 }
 
@@ -47,7 +104,7 @@ func SetupRouter() *gin.Engine {
 	r.Use(gin.Logger())
 	r.Use(gin.Recovery())
 
-	AuthMiddleware := SetupAuth(r)
+	AuthMiddleware = SetupAuth(r)
 	pathApiVersion := "/" + viper.GetString("apiVersion")
 
 	// these calls do not require JWT authorization (don't have to be logged in)
@@ -58,36 +115,61 @@ func SetupRouter() *gin.Engine {
 		})
 	})
 
-	v.POST("/object/archive/multipart", validateAPIKey(), HandleObjectArchiveUploadMultipart)
-	v.GET("/object/archive/:cid", HandleObjectArchiveGet)
-	v.POST("/object/index", validateAPIKey(), HandleObjectIndexPost)
-	v.GET("/object/:cid/index", HandleObjectIndexGet)
-	v.GET("/object/search", HandleObjectSearch)
-	v.POST("/object/batchUpload", validateAPIKey(), HandleObjectBatchUploadBegin)
-	v.POST("/object/batchUpload/multipart/:sessionId", validateAPIKey(), HandleObjectBatchUploadMultipart)
-	v.PUT("/object/batchUpload/:sessionId", validateAPIKey(), HandleObjectBatchUploadEnd)
+	v.POST("/object/archive/multipart", validateAPIKey(models.ApiKeyScopeObjectWrite), optionalJWT(AuthMiddleware), HandleObjectArchiveUploadMultipart)
+	v.GET("/object/archive/:cid", optionalJWT(AuthMiddleware), HandleObjectArchiveGet)
+	v.POST("/object/index", validateAPIKey(models.ApiKeyScopeObjectWrite), optionalJWT(AuthMiddleware), HandleObjectIndexPost)
+	v.GET("/object/:cid/index", optionalJWT(AuthMiddleware), HandleObjectIndexGet)
+	v.GET("/object/:cid/media/*path", optionalJWT(AuthMiddleware), HandleObjectMediaGet)
+	v.GET("/object/:cid/urls", optionalJWT(AuthMiddleware), HandleObjectUrlsGet)
+	v.GET("/object/:cid/files", optionalJWT(AuthMiddleware), HandleObjectFilesGet)
+	v.GET("/object/:cid/tokens", optionalJWT(AuthMiddleware), HandleObjectTokensGet)
+	v.GET("/object/search", optionalJWT(AuthMiddleware), HandleObjectSearch)
+	v.POST("/object/batchUpload", validateAPIKey(models.ApiKeyScopeObjectWrite), optionalJWT(AuthMiddleware), HandleObjectBatchUploadBegin)
+	v.POST("/object/batchUpload/multipart/:sessionId", validateAPIKey(models.ApiKeyScopeObjectWrite), optionalJWT(AuthMiddleware), HandleObjectBatchUploadMultipart)
+	v.PUT("/object/batchUpload/:sessionId", validateAPIKey(models.ApiKeyScopeObjectWrite), optionalJWT(AuthMiddleware), HandleObjectBatchUploadEnd)
 	v.GET("/layers", HandleLayersGet)
-
-	v.POST("/transaction/enqueue", validateAPIKey(), HandleTransactionEnqueue)
-	v.GET("/transaction/queue", validateAPIKey(), HandleTransactionQueueGet)
-	v.POST("/transaction/cb", validateAPIKey(), HandleTransactionQueueCallback)
-
-	// setup media storage static content route
+	v.GET("/media/:cid/*path", optionalJWT(AuthMiddleware), HandleMediaResizeGet)
+
+	v.POST("/user/follow/:uid", AuthMiddleware.MiddlewareFunc(), HandleUserFollow)
+	v.DELETE("/user/follow/:uid", AuthMiddleware.MiddlewareFunc(), HandleUserUnfollow)
+
+	v.POST("/transaction/enqueue", validateAPIKey(models.ApiKeyScopeTxEnqueue), HandleTransactionEnqueue)
+	v.GET("/transaction/queue", validateAPIKey(models.ApiKeyScopeTxWorker), HandleTransactionQueueGet)
+	v.POST("/transaction/cb", validateAPIKey(models.ApiKeyScopeTxWorker), HandleTransactionQueueCallback)
+	v.POST("/transaction/:uid/lease", validateAPIKey(models.ApiKeyScopeTxWorker), HandleTransactionLeaseRenew)
+	v.GET("/transaction/:uid", validateAPIKey(models.ApiKeyScopeTxEnqueue), HandleTransactionGet)
+	v.GET("/transactions", validateAPIKey(models.ApiKeyScopeTxEnqueue), HandleTransactionsGet)
+
+	admin := v.Group("/admin", validateAPIKey(models.ApiKeyScopeAdmin))
+	admin.POST("/apiKey", HandleApiKeyCreate)
+	admin.GET("/apiKeys", HandleApiKeysGet)
+	admin.DELETE("/apiKey/:id", HandleApiKeyRevoke)
+	admin.POST("/transaction/:uid/redeliver", HandleTransactionRedeliver)
+	admin.POST("/callbackDestination", HandleCallbackDestinationCreate)
+	admin.GET("/callbackDestinations", HandleCallbackDestinationsGet)
+	admin.POST("/callbackDestination/:id/rotateSecret", HandleCallbackDestinationRotateSecret)
+	admin.DELETE("/callbackDestination/:id", HandleCallbackDestinationDisable)
+	admin.POST("/campaign", HandleCampaignCreate)
+	admin.GET("/campaigns", HandleCampaignsGet)
+	admin.PUT("/campaign/:id", HandleCampaignUpdate)
+	admin.DELETE("/campaign/:id", HandleCampaignDisable)
+
+	// setup media storage static content route, requests are signed by LocalSimpleDriver.GetExpiringURL
 	localPath := viper.GetString("media.schemes.localSimple.localPath")
 	if localPath != "" {
-		webPath := viper.GetString("media.schemes.localSimple.webPath")
 		id := viper.GetString("media.schemes.localSimple.id")
 		m := r.Group(viper.GetString("media.webMediaPath"))
 		if viper.GetBool("media.requireAuth") {
 			m.Use(AuthMiddleware.MiddlewareFunc())
-			{
-				//TODO: don't like this duplicated line
-				m.Static(path.Join("/", id, webPath), localPath)
-			}
 		} else {
-			// with this line
-			m.Static(path.Join("/", id, webPath), localPath)
+			m.Use(optionalJWT(AuthMiddleware))
+		}
+		staticPath := path.Join("/", id)
+		if webPath := viper.GetString("media.schemes.localSimple.webPath"); webPath != "" {
+			m.Use(legacyMediaRedirect(path.Join(viper.GetString("media.webMediaPath"), staticPath), webPath))
 		}
+		m.Use(signedMediaAccess(path.Join(viper.GetString("media.webMediaPath"), staticPath)))
+		m.Static(staticPath, localPath)
 	}
 
 	// swagger uses basic auth
@@ -14,21 +14,55 @@ import (
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/wos-project/wos-core-go/app/models"
 )
 
+var testApiKey string
+
+// TestApiKey returns an admin api key for tests, minting it the first time
+func TestApiKey() string {
+	if testApiKey == "" {
+		key, _, err := models.CreateApiKey("test", []string{models.ApiKeyScopeAdmin}, nil)
+		if err != nil {
+			panic(err)
+		}
+		testApiKey = key
+	}
+	return testApiKey
+}
+
 func PerformRequest(r http.Handler, method, relativePath, body string) *httptest.ResponseRecorder {
 	return PerformRequestFull(r, method, "/"+viper.GetString("apiVersion")+relativePath, body)
 }
 
 func PerformRequestFull(r http.Handler, method, fullpath, body string) *httptest.ResponseRecorder {
+	return PerformRequestWithApiKey(r, method, fullpath, body, TestApiKey())
+}
+
+func PerformRequestWithApiKey(r http.Handler, method, fullpath, body string, apiKey string) *httptest.ResponseRecorder {
 	req, _ := http.NewRequest(method, fullpath, strings.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set(viper.GetString("auth.apiKey.key"), viper.GetString("auth.apiKey.value"))
+	req.Header.Set(viper.GetString("auth.apiKey.key"), apiKey)
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 	return w
 }
 
+func PerformRequestWithJWT(r http.Handler, method, relativePath, body string, userUid string) (*httptest.ResponseRecorder, error) {
+	token, _, err := AuthMiddleware.TokenGenerator(&UserJWT{Uid: userUid})
+	if err != nil {
+		return nil, err
+	}
+	req, _ := http.NewRequest(method, "/"+viper.GetString("apiVersion")+relativePath, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(viper.GetString("auth.apiKey.key"), TestApiKey())
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w, nil
+}
+
 func UploadFile(r http.Handler, method, p string, localPath string, remotePath string) (*httptest.ResponseRecorder, error) {
 
 	webpath := path.Join("/", viper.GetString("apiVersion"), p)
@@ -67,7 +101,7 @@ func UploadFile(r http.Handler, method, p string, localPath string, remotePath s
 	}
 
 	req.Header.Set("Content-Type", w.FormDataContentType())
-	req.Header.Set(viper.GetString("auth.apiKey.key"), viper.GetString("auth.apiKey.value"))
+	req.Header.Set(viper.GetString("auth.apiKey.key"), TestApiKey())
 
 	recorder := httptest.NewRecorder()
 	r.ServeHTTP(recorder, req)
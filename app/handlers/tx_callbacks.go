@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+
+	"github.com/wos-project/wos-core-go/app/models"
+	"github.com/wos-project/wos-core-go/app/webhook"
+)
+
+// defaults of services.sync.questori callback delivery
+const (
+	defaultCallbackMaxAttempts   = 10
+	defaultCallbackRetrySecs     = 20
+	defaultCallbackMaxRetrySecs  = 3600
+	defaultCallbackTimeoutSecs   = 10
+	transactionCallbackBatchSize = 50
+)
+
+// callbackSetting returns services.sync.questori.<key>, or def if not set
+func callbackSetting(key string, def int) int {
+	if v := viper.GetInt("services.sync.questori." + key); v > 0 {
+		return v
+	}
+	return def
+}
+
+// transactionCallbackRetryAt returns when to retry a callback that failed attempts times, doubling from
+// errorRetrySeconds up to maxRetrySeconds, or nil when it failed maxAttempts times and is dead
+func transactionCallbackRetryAt(now time.Time, attempts int) *time.Time {
+
+	if attempts >= callbackSetting("maxAttempts", defaultCallbackMaxAttempts) {
+		return nil
+	}
+	max := time.Duration(callbackSetting("maxRetrySeconds", defaultCallbackMaxRetrySecs)) * time.Second
+	delay := time.Duration(callbackSetting("errorRetrySeconds", defaultCallbackRetrySecs)) * time.Second
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	retryAt := now.Add(delay)
+	return &retryAt
+}
+
+// deliverTransactionCallback posts the callback body to its URI signed with the secrets of its destination, any status
+// but 2xx is a failure
+func deliverTransactionCallback(client *http.Client, cb *models.TransactionCallback) error {
+
+	destination, err := models.CallbackDestinationOf(cb)
+	if err != nil {
+		return err
+	}
+	if destination == nil {
+		return fmt.Errorf("no enabled callback destination for %s", cb.Uri)
+	}
+
+	body := []byte(cb.Body)
+	req, err := http.NewRequest("POST", cb.Uri, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("bad callback request %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	webhook.SignRequest(req, strconv.FormatUint(uint64(cb.ID), 10), destination.Secrets(time.Now()), body, time.Now())
+	if key := viper.GetString("services.sync.questori.apiKey"); destination.LegacyAppKey && key != "" {
+		req.Header.Set("App-Key", key)
+	}
+	r, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed returning call %v", err)
+	}
+	defer r.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(r.Body, 64<<10))
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		return fmt.Errorf("failed returning call %v", r.StatusCode)
+	}
+	return nil
+}
+
+// DispatchTransactionCallbacks delivers the due transaction callbacks, run on services.sync.questori.cron
+func DispatchTransactionCallbacks() {
+
+	timeout := time.Duration(callbackSetting("timeoutSecs", defaultCallbackTimeoutSecs)) * time.Second
+	client := &http.Client{Timeout: timeout}
+
+	// claimed for longer than delivering the whole batch can take, so an instance dying mid batch only delays it
+	cbs, err := models.ClaimDueTransactionCallbacks(time.Now(), transactionCallbackBatchSize,
+		time.Duration(transactionCallbackBatchSize+1)*timeout)
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+	for i := range cbs {
+		cb := &cbs[i]
+		err := deliverTransactionCallback(client, cb)
+		var retryAt *time.Time
+		if err != nil {
+			retryAt = transactionCallbackRetryAt(time.Now(), cb.Attempts+1)
+			if retryAt == nil {
+				glog.Errorf("transaction %d callback dead after %d attempts %v", cb.TransactionID, cb.Attempts+1, err)
+			} else {
+				glog.Warningf("transaction %d callback attempt %d %v", cb.TransactionID, cb.Attempts+1, err)
+			}
+		}
+		if err := models.FinishTransactionCallbackAttempt(cb, err, retryAt); err != nil {
+			glog.Error(err)
+		}
+	}
+}
+
+// HandleTransactionRedeliver godoc
+// @Summary HandleTransactionRedeliver delivers the callback of a transaction again, also after it was dead-lettered
+// @Produce json
+// @Param App-Key header string true "Application key header"
+// @Param uid path string true "uid the transaction was enqueued with"
+// @Success 200 object respTransaction success "transaction details"
+// @Failure 401 {string} error "Unauthorized"
+// @Failure 404 {string} error "Transaction or callback not found"
+// @Failure 500 {string} error "Internal Server Error"
+// @Router /admin/transaction/{uid}/redeliver [post]
+func HandleTransactionRedeliver(c *gin.Context) {
+
+	var tx models.Transaction
+	resp := models.Db.Where("uid = ?", c.Param("uid")).Limit(1).Find(&tx)
+	if resp.Error != nil {
+		glog.Errorf("cannot get transaction %v", resp.Error)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	if resp.RowsAffected == 0 {
+		c.JSON(404, gin.H{"error": "transaction not found"})
+		return
+	}
+
+	cb, err := models.RedeliverTransactionCallback(&tx)
+	if err != nil {
+		glog.Error(err)
+		c.JSON(500, gin.H{"error": ""})
+		return
+	}
+	if cb == nil {
+		c.JSON(404, gin.H{"error": "transaction has no callback yet"})
+		return
+	}
+
+	var r respTransaction
+	r.MarshalFromTransaction(&tx)
+	c.JSON(200, r)
+}
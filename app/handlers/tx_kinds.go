@@ -0,0 +1,476 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/wos-project/wos-core-go/app/models"
+	"github.com/wos-project/wos-core-go/app/utils"
+)
+
+const maxErc1155Tokens = 100
+
+// transactionKind is a kind of transaction the transactor runs.  Kind-specific fields without a column of their own
+// are kept in Transaction.Metadata, the request under "spec", what was made for the worker under "prepared" and what
+// the worker reported under "result".
+type transactionKind interface {
+	// Enqueue validates the spec of an enqueue request and fills tx from it
+	Enqueue(spec []byte, tx *models.Transaction) error
+	// Prepare does the slow work a new transaction needs before it is queued, like pinning.  It runs once the enqueue
+	// passed every check and is not a retry.
+	Prepare(tx *models.Transaction) error
+	// QueuedSpec returns the spec of tx the transactor worker runs
+	QueuedSpec(tx *models.Transaction, callbackUri string) (interface{}, error)
+	// ApplyCallback records the result the worker reported in tx and returns the tokens it minted, which are saved
+	// with tx
+	ApplyCallback(request *reqTransactionQueuedItemCallback, tx *models.Transaction) ([]models.ObjectToken, error)
+}
+
+// transactionKinds are the kinds of transactions by name
+var transactionKinds = map[string]transactionKind{
+	"erc20":        erc20Transaction{},
+	"erc721":       erc721Transaction{},
+	"erc1155":      erc1155Transaction{},
+	"contractCall": contractCallTransaction{},
+}
+
+type reqTransactionEnqueueSpecAirdropErc1155 struct {
+	Uid          string         `json:"uid"`
+	WalletAddr   string         `json:"walletAddr"`
+	WalletKind   string         `json:"walletKind"`
+	ContractAddr string         `json:"contractAddr"`
+	Tokens       []erc1155Token `json:"tokens"`
+	CallbackUri  string         `json:"callbackUri"`
+}
+
+type reqTransactionEnqueueSpecContractCall struct {
+	Uid          string        `json:"uid"`
+	WalletAddr   string        `json:"walletAddr"`
+	WalletKind   string        `json:"walletKind"`
+	ContractAddr string        `json:"contractAddr"`
+	Method       string        `json:"method"`
+	Args         []interface{} `json:"args"`
+	Value        string        `json:"value"`
+	CallbackUri  string        `json:"callbackUri"`
+}
+
+type erc1155Token struct {
+	TokenId  string `json:"tokenId"`
+	Quantity int    `json:"quantity"`
+	IpfsCid  string `json:"ipfsCid,omitempty"`
+}
+
+type respTransactionQueuedItemAirdropErc1155 struct {
+	Uid          string         `json:"uid"`
+	CallbackUri  string         `json:"callbackUri"`
+	WalletAddr   string         `json:"walletAddr"`
+	WalletKind   string         `json:"walletKind"`
+	ContractAddr string         `json:"contractAddr,omitempty"`
+	Tokens       []erc1155Token `json:"tokens"`
+}
+
+type respTransactionQueuedItemContractCall struct {
+	Uid          string        `json:"uid"`
+	CallbackUri  string        `json:"callbackUri"`
+	WalletAddr   string        `json:"walletAddr"`
+	WalletKind   string        `json:"walletKind"`
+	ContractAddr string        `json:"contractAddr"`
+	Method       string        `json:"method"`
+	Args         []interface{} `json:"args"`
+	Value        string        `json:"value,omitempty"`
+}
+
+// enqueueTransactionBase fills the fields every kind has, with the wallet address in the canonical form of its kind
+func enqueueTransactionBase(tx *models.Transaction, uid string, walletAddr string, walletKind string,
+	callbackUri string) error {
+
+	if walletAddr == "" || walletKind == "" {
+		return fmt.Errorf("walletAddr and walletKind required")
+	}
+	addr, err := utils.NormalizeWalletAddr(walletKind, walletAddr)
+	if err != nil {
+		return fmt.Errorf("walletAddr %v", err)
+	}
+	tx.Uid = uid
+	tx.WalletAddr = addr
+	tx.WalletKind = walletKind
+	tx.CallbackUri = callbackUri
+	return nil
+}
+
+// normalizeContractAddr returns a contract address on the chains of wallet kind in canonical form, or "" for none
+func normalizeContractAddr(walletKind string, contractAddr string) (string, error) {
+	if contractAddr == "" {
+		return "", nil
+	}
+	addr, err := utils.NormalizeWalletAddr(walletKind, contractAddr)
+	if err != nil {
+		return "", fmt.Errorf("contractAddr %v", err)
+	}
+	return addr, nil
+}
+
+// setTransactionMetadata keeps v in the metadata of tx under key, in the form it has after reading it back from the
+// database
+func setTransactionMetadata(tx *models.Transaction, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	if tx.Metadata == nil {
+		tx.Metadata = models.JSONMap{}
+	}
+	tx.Metadata[key] = m
+	return nil
+}
+
+// getTransactionMetadata reads what setTransactionMetadata kept under key into v
+func getTransactionMetadata(tx *models.Transaction, key string, v interface{}) error {
+	b, err := json.Marshal(tx.Metadata[key])
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("bad %s %s of tx %s %v", tx.Kind, key, tx.Uid, err)
+	}
+	return nil
+}
+
+// setTransactionResult keeps the worker result of tx under key
+func setTransactionResult(tx *models.Transaction, key string, value interface{}) {
+	if tx.Metadata == nil {
+		tx.Metadata = models.JSONMap{}
+	}
+	result, ok := tx.Metadata["result"].(map[string]interface{})
+	if !ok {
+		result = map[string]interface{}{}
+	}
+	result[key] = value
+	tx.Metadata["result"] = result
+}
+
+// parseUint256 parses a decimal uint256 such as a token id or wei value
+func parseUint256(s string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 256 {
+		return nil, fmt.Errorf("%q is not a decimal uint256", s)
+	}
+	return n, nil
+}
+
+// erc20Transaction airdrops fungible tokens
+type erc20Transaction struct{}
+
+func (erc20Transaction) Enqueue(spec []byte, tx *models.Transaction) error {
+	var erc20 reqTransactionEnqueueSpecAirdropErc20
+	if err := json.Unmarshal(spec, &erc20); err != nil {
+		return err
+	}
+	if erc20.Quantity <= 0 {
+		return fmt.Errorf("tokenQuantity must be positive")
+	}
+	tx.TokenQuantity = erc20.Quantity
+	return enqueueTransactionBase(tx, erc20.Uid, erc20.WalletAddr, erc20.WalletKind, erc20.CallbackUri)
+}
+
+func (erc20Transaction) Prepare(tx *models.Transaction) error {
+	return nil
+}
+
+func (erc20Transaction) QueuedSpec(tx *models.Transaction, callbackUri string) (interface{}, error) {
+	return &respTransactionQueuedItemAirdropErc20{
+		Uid:         tx.Uid,
+		WalletAddr:  tx.WalletAddr,
+		WalletKind:  tx.WalletKind,
+		Quantity:    tx.TokenQuantity,
+		CallbackUri: callbackUri,
+	}, nil
+}
+
+func (erc20Transaction) ApplyCallback(request *reqTransactionQueuedItemCallback,
+	tx *models.Transaction) ([]models.ObjectToken, error) {
+	setTransactionResult(tx, "tokenQuantity", request.TokenQuantity)
+	return nil, nil
+}
+
+// erc721Transaction mints an NFT of an object
+type erc721Transaction struct{}
+
+// erc721Prepared is the token metadata pinned for the worker to mint with
+type erc721Prepared struct {
+	TokenUri string `json:"tokenUri"`
+}
+
+func (erc721Transaction) Enqueue(spec []byte, tx *models.Transaction) error {
+	var erc721 reqTransactionEnqueueSpecAirdropErc721
+	if err := json.Unmarshal(spec, &erc721); err != nil {
+		return err
+	}
+	if erc721.IpfsCid == "" {
+		return fmt.Errorf("ipfsCid required")
+	}
+	err := enqueueTransactionBase(tx, erc721.Uid, erc721.WalletAddr, erc721.WalletKind, erc721.CallbackUri)
+	if err != nil {
+		return err
+	}
+
+	// token metadata is public and permanent, so only public objects are minted
+	obj, _, err := findObjectByCid(erc721.IpfsCid)
+	if err != nil {
+		return fmt.Errorf("ipfsCid %s is not an indexed object", erc721.IpfsCid)
+	}
+	if !canViewObject(obj, "") {
+		return fmt.Errorf("ipfsCid %s is not a public object", erc721.IpfsCid)
+	}
+	tx.IpfsCid = erc721.IpfsCid
+	return nil
+}
+
+// Prepare pins the token metadata of the object
+func (erc721Transaction) Prepare(tx *models.Transaction) error {
+	obj, _, err := findObjectByCid(tx.IpfsCid)
+	if err != nil {
+		return err
+	}
+	tokenUri, err := pinObjectErc721Metadata(obj)
+	if err != nil {
+		return fmt.Errorf("cannot pin token metadata of %s %v", tx.IpfsCid, err)
+	}
+	return setTransactionMetadata(tx, "prepared", &erc721Prepared{TokenUri: tokenUri})
+}
+
+func (erc721Transaction) QueuedSpec(tx *models.Transaction, callbackUri string) (interface{}, error) {
+	var spec erc721Prepared
+	if err := getTransactionMetadata(tx, "prepared", &spec); err != nil {
+		return nil, err
+	}
+	return &respTransactionQueuedItemAirdropErc721{
+		Uid:         tx.Uid,
+		WalletAddr:  tx.WalletAddr,
+		WalletKind:  tx.WalletKind,
+		IpfsCid:     tx.IpfsCid,
+		TokenUri:    spec.TokenUri,
+		CallbackUri: callbackUri,
+	}, nil
+}
+
+// ApplyCallback returns the token minted for the object, failed transactions minted none.  The object of the
+// transaction is not the worker's to change.
+func (erc721Transaction) ApplyCallback(request *reqTransactionQueuedItemCallback,
+	tx *models.Transaction) ([]models.ObjectToken, error) {
+
+	if request.IpfsCid != "" && request.IpfsCid != tx.IpfsCid {
+		glog.Warningf("tx %s callback cid %s, not %s", tx.Uid, request.IpfsCid, tx.IpfsCid)
+	}
+	if request.TokenId == "" {
+		return nil, nil
+	}
+	if request.Status != transactionResultOk {
+		glog.Warningf("tx %s callback status %s with tokenId %s, no token recorded", tx.Uid, request.Status,
+			request.TokenId)
+		return nil, nil
+	}
+	tokenId, err := parseUint256(request.TokenId)
+	if err != nil {
+		return nil, fmt.Errorf("tokenId %v", err)
+	}
+	if tx.ContractAddr == "" {
+		return nil, fmt.Errorf("contractAddr required with tokenId")
+	}
+	setTransactionResult(tx, "tokenId", tokenId.String())
+
+	var spec erc721Prepared
+	if err := getTransactionMetadata(tx, "prepared", &spec); err != nil {
+		return nil, err
+	}
+	return []models.ObjectToken{{
+		ObjectCid:     tx.IpfsCid,
+		TransactionID: tx.ID,
+		ChainId:       tx.ChainId,
+		ContractAddr:  tx.ContractAddr,
+		TokenId:       tokenId.String(),
+		WalletAddr:    tx.WalletAddr,
+		WalletKind:    tx.WalletKind,
+		TokenUri:      spec.TokenUri,
+	}}, nil
+}
+
+// erc1155Transaction airdrops several tokens of a multi-token contract in one transaction
+type erc1155Transaction struct{}
+
+type erc1155Spec struct {
+	ContractAddr string         `json:"contractAddr,omitempty"`
+	Tokens       []erc1155Token `json:"tokens"`
+}
+
+func (erc1155Transaction) Enqueue(spec []byte, tx *models.Transaction) error {
+	var erc1155 reqTransactionEnqueueSpecAirdropErc1155
+	if err := json.Unmarshal(spec, &erc1155); err != nil {
+		return err
+	}
+	err := enqueueTransactionBase(tx, erc1155.Uid, erc1155.WalletAddr, erc1155.WalletKind, erc1155.CallbackUri)
+	if err != nil {
+		return err
+	}
+	contractAddr, err := normalizeContractAddr(tx.WalletKind, erc1155.ContractAddr)
+	if err != nil {
+		return err
+	}
+	if len(erc1155.Tokens) == 0 || len(erc1155.Tokens) > maxErc1155Tokens {
+		return fmt.Errorf("between 1 and %d tokens required", maxErc1155Tokens)
+	}
+	seen := map[string]bool{}
+	quantity := 0
+	for i, token := range erc1155.Tokens {
+		id, err := parseUint256(token.TokenId)
+		if err != nil {
+			return fmt.Errorf("token %d id %v", i, err)
+		}
+		if seen[id.String()] {
+			return fmt.Errorf("token %d id %s repeated", i, token.TokenId)
+		}
+		seen[id.String()] = true
+		if token.Quantity <= 0 {
+			return fmt.Errorf("token %d quantity must be positive", i)
+		}
+		erc1155.Tokens[i].TokenId = id.String()
+		quantity += token.Quantity
+	}
+
+	tx.ContractAddr = contractAddr
+	tx.TokenQuantity = quantity
+	return setTransactionMetadata(tx, "spec", &erc1155Spec{ContractAddr: contractAddr, Tokens: erc1155.Tokens})
+}
+
+func (erc1155Transaction) Prepare(tx *models.Transaction) error {
+	return nil
+}
+
+func (erc1155Transaction) QueuedSpec(tx *models.Transaction, callbackUri string) (interface{}, error) {
+	var spec erc1155Spec
+	if err := getTransactionMetadata(tx, "spec", &spec); err != nil {
+		return nil, err
+	}
+	return &respTransactionQueuedItemAirdropErc1155{
+		Uid:          tx.Uid,
+		WalletAddr:   tx.WalletAddr,
+		WalletKind:   tx.WalletKind,
+		ContractAddr: tx.ContractAddr,
+		Tokens:       spec.Tokens,
+		CallbackUri:  callbackUri,
+	}, nil
+}
+
+func (erc1155Transaction) ApplyCallback(request *reqTransactionQueuedItemCallback,
+	tx *models.Transaction) ([]models.ObjectToken, error) {
+	return nil, nil
+}
+
+// contractCallTransaction calls any method of a contract, for what has no kind of its own
+type contractCallTransaction struct{}
+
+// contractMethodSignature matches method signatures like mint(address,uint256[])
+var contractMethodSignature = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\(([A-Za-z0-9_\[\]]+(,[A-Za-z0-9_\[\]]+)*)?\)$`)
+
+type contractCallSpec struct {
+	ContractAddr string        `json:"contractAddr"`
+	Method       string        `json:"method"`
+	Args         []interface{} `json:"args"`
+	Value        string        `json:"value,omitempty"`
+}
+
+func (contractCallTransaction) Enqueue(spec []byte, tx *models.Transaction) error {
+	var call reqTransactionEnqueueSpecContractCall
+	if err := json.Unmarshal(spec, &call); err != nil {
+		return err
+	}
+	if call.ContractAddr == "" {
+		return fmt.Errorf("contractAddr required")
+	}
+	err := enqueueTransactionBase(tx, call.Uid, call.WalletAddr, call.WalletKind, call.CallbackUri)
+	if err != nil {
+		return err
+	}
+	contractAddr, err := normalizeContractAddr(tx.WalletKind, call.ContractAddr)
+	if err != nil {
+		return err
+	}
+	match := contractMethodSignature.FindStringSubmatch(call.Method)
+	if match == nil {
+		return fmt.Errorf("method %q is not a signature like mint(address,uint256)", call.Method)
+	}
+	params := 0
+	if match[1] != "" {
+		params = len(strings.Split(match[1], ","))
+	}
+	if len(call.Args) != params {
+		return fmt.Errorf("method %s takes %d args, got %d", call.Method, params, len(call.Args))
+	}
+	if call.Value != "" {
+		if _, err := parseUint256(call.Value); err != nil {
+			return fmt.Errorf("value %v", err)
+		}
+	}
+	if call.Args == nil {
+		call.Args = []interface{}{}
+	}
+
+	tx.ContractAddr = contractAddr
+	return setTransactionMetadata(tx, "spec", &contractCallSpec{
+		ContractAddr: contractAddr,
+		Method:       call.Method,
+		Args:         call.Args,
+		Value:        call.Value,
+	})
+}
+
+// transactionSendsValue returns true if tx sends native currency with its call, which campaign budgets don't count
+func transactionSendsValue(tx *models.Transaction) bool {
+	if tx.Kind != "contractCall" {
+		return false
+	}
+	var call contractCallSpec
+	if err := getTransactionMetadata(tx, "spec", &call); err != nil || call.Value == "" {
+		return false
+	}
+	value, err := parseUint256(call.Value)
+	return err != nil || value.Sign() > 0
+}
+
+func (contractCallTransaction) Prepare(tx *models.Transaction) error {
+	return nil
+}
+
+func (contractCallTransaction) QueuedSpec(tx *models.Transaction, callbackUri string) (interface{}, error) {
+	var spec contractCallSpec
+	if err := getTransactionMetadata(tx, "spec", &spec); err != nil {
+		return nil, err
+	}
+	return &respTransactionQueuedItemContractCall{
+		Uid:          tx.Uid,
+		WalletAddr:   tx.WalletAddr,
+		WalletKind:   tx.WalletKind,
+		ContractAddr: tx.ContractAddr,
+		Method:       spec.Method,
+		Args:         spec.Args,
+		Value:        spec.Value,
+		CallbackUri:  callbackUri,
+	}, nil
+}
+
+func (contractCallTransaction) ApplyCallback(request *reqTransactionQueuedItemCallback,
+	tx *models.Transaction) ([]models.ObjectToken, error) {
+	if request.Result != nil {
+		setTransactionResult(tx, "output", request.Result)
+	}
+	return nil, nil
+}
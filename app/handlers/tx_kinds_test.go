@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/wos-project/wos-core-go/app/models"
+)
+
+// enqueueTestKind enqueues spec as kind and reads the transaction back like the database does
+func enqueueTestKind(t *testing.T, kind string, spec string) (*models.Transaction, error) {
+	tx := models.Transaction{Kind: kind}
+	if err := transactionKinds[kind].Enqueue([]byte(spec), &tx); err != nil {
+		return nil, err
+	}
+	v, err := tx.Metadata.Value()
+	assert.Nil(t, err)
+	if v != nil {
+		var stored models.JSONMap
+		assert.Nil(t, stored.Scan(v))
+		tx.Metadata = stored
+	}
+	return &tx, nil
+}
+
+func TestTransactionKindErc1155(t *testing.T) {
+
+	spec := `{"uid": "u1", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "contractAddr": "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359",
+		"tokens": [{"tokenId": "1", "quantity": 2}, {"tokenId": "0115792089237316195423570985008687907853269984665640564039457584007913129639935", "quantity": 3, "ipfsCid": "cid"}],
+		"callbackUri": "http://127.0.0.1:7890/cb"}`
+	tx, err := enqueueTestKind(t, "erc1155", spec)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "u1", tx.Uid)
+	assert.Equal(t, "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359", tx.ContractAddr)
+	assert.Equal(t, 5, tx.TokenQuantity)
+
+	queued, err := transactionKinds["erc1155"].QueuedSpec(tx, "http://core/cb")
+	assert.Nil(t, err)
+	assert.Equal(t, &respTransactionQueuedItemAirdropErc1155{
+		Uid:          "u1",
+		CallbackUri:  "http://core/cb",
+		WalletAddr:   "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		WalletKind:   "ethereum",
+		ContractAddr: "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		Tokens: []erc1155Token{
+			{TokenId: "1", Quantity: 2},
+			{TokenId: "115792089237316195423570985008687907853269984665640564039457584007913129639935", Quantity: 3, IpfsCid: "cid"},
+		},
+	}, queued)
+
+	// retries match, other tokens do not
+	again, _ := enqueueTestKind(t, "erc1155", spec)
+	again.Metadata["result"] = map[string]interface{}{"txId": "0x1"}
+	assert.True(t, tx.SameRequest(again))
+	other, _ := enqueueTestKind(t, "erc1155", `{"uid": "u1", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "contractAddr": "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359",
+		"tokens": [{"tokenId": "1", "quantity": 3}, {"tokenId": "2", "quantity": 2}], "callbackUri": "http://127.0.0.1:7890/cb"}`)
+	assert.False(t, tx.SameRequest(other))
+
+	for _, bad := range []string{
+		`{"uid": "u1", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "tokens": []}`,
+		`{"uid": "u1", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "tokens": [{"tokenId": "-1", "quantity": 1}]}`,
+		`{"uid": "u1", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "tokens": [{"tokenId": "0x1", "quantity": 1}]}`,
+		`{"uid": "u1", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "tokens": [{"tokenId": "1", "quantity": 0}]}`,
+		`{"uid": "u1", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "tokens": [{"tokenId": "1", "quantity": 1}, {"tokenId": "01", "quantity": 1}]}`,
+		`{"uid": "u1", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "tokens": [{"tokenId": "115792089237316195423570985008687907853269984665640564039457584007913129639936", "quantity": 1}]}`,
+		`{"uid": "u1", "tokens": [{"tokenId": "1", "quantity": 1}]}`,
+		`{"uid": "u1", "walletAddr": "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD", "walletKind": "ethereum", "tokens": [{"tokenId": "1", "quantity": 1}]}`,
+		`{"uid": "u1", "walletAddr": "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "walletKind": "solana", "tokens": [{"tokenId": "1", "quantity": 1}]}`,
+	} {
+		_, err = enqueueTestKind(t, "erc1155", bad)
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestTransactionKindContractCall(t *testing.T) {
+
+	tx, err := enqueueTestKind(t, "contractCall", `{"uid": "u2", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum",
+		"contractAddr": "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359", "method": "mint(address,uint256[])", "args": ["0xabc", [1, 2]], "value": "1000"}`)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, transactionSendsValue(tx))
+	queued, err := transactionKinds["contractCall"].QueuedSpec(tx, "http://core/cb")
+	assert.Nil(t, err)
+	b, _ := json.Marshal(queued)
+	assert.JSONEq(t, `{"uid": "u2", "callbackUri": "http://core/cb", "walletAddr": "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "walletKind": "ethereum",
+		"contractAddr": "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359", "method": "mint(address,uint256[])", "args": ["0xabc", [1, 2]], "value": "1000"}`, string(b))
+
+	// worker output is kept apart from the spec
+	tokens, err := transactionKinds["contractCall"].ApplyCallback(&reqTransactionQueuedItemCallback{Result: "0x01"}, tx)
+	assert.Nil(t, err)
+	assert.Empty(t, tokens)
+	assert.Equal(t, map[string]interface{}{"output": "0x01"}, tx.Metadata["result"])
+
+	_, err = enqueueTestKind(t, "contractCall", `{"uid": "u2", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum",
+		"contractAddr": "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359", "method": "pause()"}`)
+	if assert.Nil(t, err) {
+		assert.False(t, transactionSendsValue(tx))
+	}
+	tx, err = enqueueTestKind(t, "contractCall", `{"uid": "u2", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum",
+		"contractAddr": "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359", "method": "pause()", "value": "0"}`)
+	if assert.Nil(t, err) {
+		assert.False(t, transactionSendsValue(tx))
+	}
+
+	for _, bad := range []string{
+		`{"uid": "u2", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "method": "pause()"}`,
+		`{"uid": "u2", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "contractAddr": "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359", "method": "pause"}`,
+		`{"uid": "u2", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "contractAddr": "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359", "method": "mint(address)"}`,
+		`{"uid": "u2", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "contractAddr": "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359", "method": "pause()", "args": [1]}`,
+		`{"uid": "u2", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "contractAddr": "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359", "method": "pause()", "value": "-1"}`,
+		`{"uid": "u2", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "contractAddr": "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359", "method": "a();b()"}`,
+		`{"uid": "u2", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "contractAddr": "0x1234", "method": "pause()"}`,
+	} {
+		_, err = enqueueTestKind(t, "contractCall", bad)
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestTransactionKindErc20(t *testing.T) {
+
+	tx, err := enqueueTestKind(t, "erc20", `{"uid": "u3", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum", "tokenQuantity": 7}`)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 7, tx.TokenQuantity)
+		assert.Nil(t, tx.Metadata)
+	}
+	_, err = enqueueTestKind(t, "erc20", `{"uid": "u3", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum"}`)
+	assert.NotNil(t, err)
+	_, err = enqueueTestKind(t, "erc721", `{"uid": "u3", "walletAddr": "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "walletKind": "ethereum"}`)
+	assert.NotNil(t, err)
+}
+
+func TestTransactionKindErc721Callback(t *testing.T) {
+
+	tx := &models.Transaction{Kind: "erc721", Uid: "u4", IpfsCid: "cid", ChainId: "1337",
+		WalletAddr: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", WalletKind: "ethereum",
+		ContractAddr: "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359"}
+	assert.Nil(t, setTransactionMetadata(tx, "prepared", &erc721Prepared{TokenUri: "ipfs://meta/metadata.json"}))
+
+	// failed mints have no token
+	tokens, err := transactionKinds["erc721"].ApplyCallback(&reqTransactionQueuedItemCallback{Status: "failed", TokenId: "7"}, tx)
+	assert.Nil(t, err)
+	assert.Empty(t, tokens)
+
+	tokens, err = transactionKinds["erc721"].ApplyCallback(&reqTransactionQueuedItemCallback{Status: "ok", TokenId: "07"}, tx)
+	assert.Nil(t, err)
+	if assert.Len(t, tokens, 1) {
+		assert.Equal(t, "7", tokens[0].TokenId)
+		assert.Equal(t, "cid", tokens[0].ObjectCid)
+		assert.Equal(t, "ipfs://meta/metadata.json", tokens[0].TokenUri)
+	}
+	_, err = transactionKinds["erc721"].ApplyCallback(&reqTransactionQueuedItemCallback{Status: "ok", TokenId: "0x7"}, tx)
+	assert.NotNil(t, err)
+}
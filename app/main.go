@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/autotls"
@@ -39,16 +41,31 @@ import (
 func main() {
 
 	config.ConfigPath = flag.String("config", "config.yaml", "path to YAML config file")
+	mintApiKey := flag.String("mintApiKey", "", "mint an api key with this name, print it and exit")
+	apiKeyScopes := flag.String("apiKeyScopes", models.ApiKeyScopeAdmin, "comma separated scopes of key minted with -mintApiKey")
+	simulateTransactor := flag.Bool("simulateTransactor", false, "run a simulated transactor and callback receiver, to test transactions offline")
 	flag.Parse()
 	config.InitializeConfiguration()
 
 	glog.Infof("starting %s mode", viper.GetString("mode"))
 
 	utils.InitMediaStorage()
+	if err := utils.CheckScrubCommands(); err != nil {
+		glog.Fatal(err)
+	}
 
 	models.InitializeDatabase()
 	defer models.CloseDatabase()
 
+	if *mintApiKey != "" {
+		key, _, err := models.CreateApiKey(*mintApiKey, strings.Split(*apiKeyScopes, ","), nil)
+		if err != nil {
+			glog.Fatalf("cannot mint api key %v", err)
+		}
+		fmt.Println(key)
+		return
+	}
+
 	r := handlers.SetupRouter()
 	r.Use(ginglog.Logger(3 * time.Second))
 
@@ -59,11 +76,26 @@ func main() {
 
 	// cron jobs
 	go handlers.CleanupOldTempFiles();
-	c := cron.New()
-	c.AddFunc(viper.GetString("schedules.cleanupOldTempFiles"), func() {
+	// schedules may have an optional seconds field
+	c := cron.New(cron.WithParser(cron.NewParser(
+		cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)))
+	c.AddFunc(viper.GetString("schedules.cleanupOldTempFiles.cron"), func() {
 		handlers.CleanupOldTempFiles();
 	})
+	_, err := c.AddFunc(viper.GetString("schedules.requeueTransactionLeases.cron"), handlers.RequeueExpiredTransactionLeases)
+	if err != nil {
+		glog.Fatalf("cannot schedule requeueTransactionLeases %v", err)
+	}
+	_, err = c.AddJob(viper.GetString("services.sync.questori.cron"),
+		cron.NewChain(cron.SkipIfStillRunning(cron.DefaultLogger)).Then(cron.FuncJob(handlers.DispatchTransactionCallbacks)))
+	if err != nil {
+		glog.Fatalf("cannot schedule transaction callbacks %v", err)
+	}
 	c.Start()
+
+	if *simulateTransactor {
+		startSimulator(context.Background())
+	}
 	
 	switch viper.GetString("host.mode") {
 	case "letsEncrypt":
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ApiKeyScopeObjectWrite = "object:write" // create objects
+	ApiKeyScopeTxEnqueue   = "tx:enqueue"   // enqueue and query transactions
+	ApiKeyScopeTxWorker    = "tx:worker"    // transactor dequeue and callbacks
+	ApiKeyScopeAdmin       = "admin"        // everything, including minting keys
+)
+
+// ApiKeyScopes are all the valid scopes
+var ApiKeyScopes = []string{
+	ApiKeyScopeObjectWrite,
+	ApiKeyScopeTxEnqueue,
+	ApiKeyScopeTxWorker,
+	ApiKeyScopeAdmin,
+}
+
+const apiKeyPrefixLen = 8
+
+// ApiKey is a named application key.  Only the SHA256 of the key is stored, the prefix is used to find it.
+type ApiKey struct {
+	gorm.Model
+	Name       string     `gorm:"column:name; index" binding:"required"`
+	Prefix     string     `gorm:"column:prefix; uniqueIndex"`
+	KeyHash    string     `gorm:"column:key_hash"`
+	Scopes     string     `gorm:"column:scopes"`
+	ExpiresAt  *time.Time `gorm:"column:expires_at"`
+	RevokedAt  *time.Time `gorm:"column:revoked_at"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at"`
+}
+
+// hashApiKey returns hex SHA256 of the clear key
+func hashApiKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// ValidApiKeyScope returns true if scope is one of ApiKeyScopes
+func ValidApiKeyScope(scope string) bool {
+	for _, s := range ApiKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateApiKey mints a new key and saves its hash.  The clear key is returned and cannot be recovered later.
+func CreateApiKey(name string, scopes []string, expiresAt *time.Time) (string, *ApiKey, error) {
+
+	for _, s := range scopes {
+		if !ValidApiKeyScope(s) {
+			return "", nil, fmt.Errorf("unknown api key scope %s", s)
+		}
+	}
+
+	b := make([]byte, apiKeyPrefixLen/2+32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot generate api key %v", err)
+	}
+	prefix := hex.EncodeToString(b[:apiKeyPrefixLen/2])
+	clear := prefix + "." + base64.RawURLEncoding.EncodeToString(b[apiKeyPrefixLen/2:])
+
+	key := ApiKey{
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hashApiKey(clear),
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: expiresAt,
+	}
+	res := Db.Save(&key)
+	if res.Error != nil {
+		return "", nil, fmt.Errorf("cannot save api key %v", res.Error)
+	}
+	return clear, &key, nil
+}
+
+// FindApiKey finds the key record matching the clear key
+func FindApiKey(clear string) (*ApiKey, error) {
+
+	dot := strings.Index(clear, ".")
+	if dot != apiKeyPrefixLen {
+		return nil, fmt.Errorf("malformed api key")
+	}
+
+	var key ApiKey
+	res := Db.Where("prefix = ?", clear[:dot]).First(&key)
+	if res.Error != nil {
+		return nil, fmt.Errorf("cannot find api key %v", res.Error)
+	}
+	if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashApiKey(clear))) != 1 {
+		return nil, fmt.Errorf("api key does not match")
+	}
+	return &key, nil
+}
+
+// ScopeList returns the scopes of the key
+func (k *ApiKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(k.Scopes, ",")
+}
+
+// HasScope returns true if the key has scope or is an admin key
+func (k *ApiKey) HasScope(scope string) bool {
+	for _, s := range k.ScopeList() {
+		if s == scope || s == ApiKeyScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// IsActive returns true if the key is not revoked or expired at time now
+func (k *ApiKey) IsActive(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	if k.ExpiresAt != nil && now.After(*k.ExpiresAt) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,242 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"gorm.io/gorm"
+)
+
+const callbackSecretPrefix = "whsec_"
+
+// CallbackDestination is a registered receiver of transaction callbacks.  Transactions may only call back URIs under
+// the URL of an enabled destination, and their callbacks are signed with its secret, see package webhook.  The secret
+// is kept in the clear, it is needed to sign.
+type CallbackDestination struct {
+	gorm.Model
+	Name                    string     `gorm:"column:name; uniqueIndex" binding:"required"`
+	Url                     string     `gorm:"column:url" binding:"required"`
+	Secret                  string     `gorm:"column:secret"`
+	PreviousSecret          string     `gorm:"column:previous_secret"`
+	PreviousSecretExpiresAt *time.Time `gorm:"column:previous_secret_expires_at"`
+	DisabledAt              *time.Time `gorm:"column:disabled_at"`
+	LegacyAppKey            bool       `gorm:"column:legacy_app_key"` // also send the App-Key of services.sync.questori.apiKey
+}
+
+// generateCallbackSecret returns a new random secret
+func generateCallbackSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot generate callback secret %v", err)
+	}
+	return callbackSecretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// parseCallbackUrl parses an absolute http(s) URL without credentials, query or fragment
+func parseCallbackUrl(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("bad callback url %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("callback url %s must be absolute http or https", s)
+	}
+	if u.User != nil || u.Fragment != "" {
+		return nil, fmt.Errorf("callback url %s must not have credentials or fragment", s)
+	}
+	return u, nil
+}
+
+// CreateCallbackDestination registers destination name for callback URIs under rawUrl with a new secret
+func CreateCallbackDestination(name string, rawUrl string) (*CallbackDestination, error) {
+
+	u, err := parseCallbackUrl(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if u.RawQuery != "" {
+		return nil, fmt.Errorf("callback destination url %s must not have a query", rawUrl)
+	}
+	secret, err := generateCallbackSecret()
+	if err != nil {
+		return nil, err
+	}
+	d := CallbackDestination{
+		Name:   name,
+		Url:    u.String(),
+		Secret: secret,
+	}
+	if res := Db.Create(&d); res.Error != nil {
+		return nil, fmt.Errorf("cannot save callback destination %v", res.Error)
+	}
+	return &d, nil
+}
+
+// RotateSecret gives d a new secret.  Callbacks are also signed with the old secret until grace has passed, so
+// receivers can switch without dropping callbacks.
+func (d *CallbackDestination) RotateSecret(grace time.Duration) error {
+
+	secret, err := generateCallbackSecret()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(grace)
+	d.PreviousSecret = d.Secret
+	d.PreviousSecretExpiresAt = &expiresAt
+	d.Secret = secret
+	if res := Db.Save(d); res.Error != nil {
+		return fmt.Errorf("cannot rotate callback destination secret %v", res.Error)
+	}
+	return nil
+}
+
+// Secrets returns the secrets to sign callbacks with at now, newest first
+func (d *CallbackDestination) Secrets(now time.Time) [][]byte {
+	secrets := [][]byte{[]byte(d.Secret)}
+	if d.PreviousSecret != "" && d.PreviousSecretExpiresAt != nil && now.Before(*d.PreviousSecretExpiresAt) {
+		secrets = append(secrets, []byte(d.PreviousSecret))
+	}
+	return secrets
+}
+
+// Matches returns true if uri is on the scheme and host of d and under its path
+func (d *CallbackDestination) Matches(uri string) bool {
+
+	base, err := url.Parse(d.Url)
+	if err != nil {
+		return false
+	}
+	u, err := parseCallbackUrl(uri)
+	if err != nil {
+		return false
+	}
+	if u.Scheme != base.Scheme || !strings.EqualFold(u.Host, base.Host) {
+		return false
+	}
+	// receivers resolve dot segments, so compare clean paths
+	prefix := strings.TrimSuffix(path.Clean("/"+base.Path), "/")
+	p := path.Clean("/" + u.Path)
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// FindCallbackDestination returns the enabled destination with the longest URL matching uri, or nil
+func FindCallbackDestination(uri string) (*CallbackDestination, error) {
+
+	var destinations []CallbackDestination
+	res := Db.Where("disabled_at IS NULL").Find(&destinations)
+	if res.Error != nil {
+		return nil, fmt.Errorf("cannot get callback destinations %v", res.Error)
+	}
+	var found *CallbackDestination
+	for i := range destinations {
+		d := &destinations[i]
+		if d.Matches(uri) && (found == nil || len(d.Url) > len(found.Url)) {
+			found = d
+		}
+	}
+	return found, nil
+}
+
+// RegisterLegacyCallbackDestinations registers a destination for each callback host of transactions and callbacks
+// saved before destinations existed, like the questori receiver, and assigns them to it.  Without it their callbacks
+// are dead-lettered and enqueues calling back the same hosts are rejected.  It returns how many destinations were
+// registered, get their secrets by rotating them.  Until their receivers verify signatures, callbacks to registered
+// destinations keep the App-Key header legacy receivers check, see LegacyAppKey.
+func RegisterLegacyCallbackDestinations(db *gorm.DB) (int, error) {
+
+	var txUris, cbUris []string
+	err := db.Model(&Transaction{}).Where("callback_destination_id = 0 AND callback_uri <> ''").
+		Distinct().Pluck("callback_uri", &txUris).Error
+	if err != nil {
+		return 0, fmt.Errorf("cannot get legacy transaction callback uris %v", err)
+	}
+	err = db.Model(&TransactionCallback{}).Where("destination_id = 0 AND uri <> ''").
+		Distinct().Pluck("uri", &cbUris).Error
+	if err != nil {
+		return 0, fmt.Errorf("cannot get legacy callback uris %v", err)
+	}
+
+	var destinations []CallbackDestination
+	if err := db.Where("disabled_at IS NULL").Find(&destinations).Error; err != nil {
+		return 0, fmt.Errorf("cannot get callback destinations %v", err)
+	}
+	registered := 0
+	destinationOf := func(uri string) (uint, error) {
+		for i := range destinations {
+			if destinations[i].Matches(uri) {
+				return destinations[i].ID, nil
+			}
+		}
+		u, err := parseCallbackUrl(uri)
+		if err != nil {
+			return 0, err
+		}
+		secret, err := generateCallbackSecret()
+		if err != nil {
+			return 0, err
+		}
+		d := CallbackDestination{
+			Name:   "legacy-" + u.Scheme + "-" + strings.ToLower(u.Host),
+			Url:          u.Scheme + "://" + u.Host + "/",
+			Secret:       secret,
+			LegacyAppKey: true,
+		}
+		if err := db.Create(&d).Error; err != nil {
+			return 0, fmt.Errorf("cannot save callback destination %v", err)
+		}
+		glog.Warningf("registered callback destination %s %d for %s, rotate its secret to sign callbacks", d.Name, d.ID, d.Url)
+		destinations = append(destinations, d)
+		registered++
+		return d.ID, nil
+	}
+
+	for _, uri := range txUris {
+		id, err := destinationOf(uri)
+		if err != nil {
+			glog.Warningf("transactions calling back %s keep no destination %v", uri, err)
+			continue
+		}
+		err = db.Model(&Transaction{}).Where("callback_destination_id = 0 AND callback_uri = ?", uri).
+			Update("callback_destination_id", id).Error
+		if err != nil {
+			return registered, fmt.Errorf("cannot assign callback destination %v", err)
+		}
+	}
+	for _, uri := range cbUris {
+		id, err := destinationOf(uri)
+		if err != nil {
+			glog.Warningf("callbacks to %s keep no destination %v", uri, err)
+			continue
+		}
+		err = db.Model(&TransactionCallback{}).Where("destination_id = 0 AND uri = ?", uri).
+			Update("destination_id", id).Error
+		if err != nil {
+			return registered, fmt.Errorf("cannot assign callback destination %v", err)
+		}
+	}
+	return registered, nil
+}
+
+// CallbackDestinationOf returns the enabled destination cb is delivered to, or nil when there is none anymore.
+// Callbacks saved before destinations existed go to the destination matching their URI.
+func CallbackDestinationOf(cb *TransactionCallback) (*CallbackDestination, error) {
+
+	if cb.DestinationID == 0 {
+		return FindCallbackDestination(cb.Uri)
+	}
+	var destinations []CallbackDestination
+	res := Db.Where("id = ? AND disabled_at IS NULL", cb.DestinationID).Limit(1).Find(&destinations)
+	if res.Error != nil {
+		return nil, fmt.Errorf("cannot get callback destination %v", res.Error)
+	}
+	if len(destinations) == 0 || !destinations[0].Matches(cb.Uri) {
+		return nil, nil
+	}
+	return &destinations[0], nil
+}
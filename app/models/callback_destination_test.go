@@ -0,0 +1,32 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallbackDestinationMatches(t *testing.T) {
+
+	d := CallbackDestination{Url: "https://hooks.example.com/wos"}
+	for uri, matches := range map[string]bool{
+		"https://hooks.example.com/wos":            true,
+		"https://hooks.example.com/wos/erc721":     true,
+		"https://HOOKS.example.com/wos/a?b=c":      true,
+		"https://hooks.example.com/wosx":           false,
+		"https://hooks.example.com/":               false,
+		"http://hooks.example.com/wos":             false,
+		"https://hooks.example.com.evil.io/wos":    false,
+		"https://hooks.example.com:8443/wos":       false,
+		"https://user@hooks.example.com/wos":       false,
+		"https://hooks.example.com/wos/../private": false,
+		"https://hooks.example.com/wos/./a":        true,
+		"hooks.example.com/wos":                    false,
+	} {
+		assert.Equal(t, matches, d.Matches(uri), uri)
+	}
+
+	root := CallbackDestination{Url: "http://127.0.0.1:7890/"}
+	assert.True(t, root.Matches("http://127.0.0.1:7890/cb/erc721"))
+	assert.False(t, root.Matches("http://127.0.0.1:7891/cb/erc721"))
+}
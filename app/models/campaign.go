@@ -0,0 +1,243 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrCampaignClosed is returned for transactions enqueued in a campaign that is disabled, outside its dates or
+	// has spent its budget
+	ErrCampaignClosed = errors.New("campaign closed")
+	// ErrCampaignLimit is returned for transactions that would take a campaign over one of its limits
+	ErrCampaignLimit = errors.New("campaign limit reached")
+)
+
+// Campaign groups transactions under spending and rate limits, so a misbehaving client cannot enqueue without bound.
+// Budget, Spent and EstimatedCost are decimal amounts in the smallest unit of the native currency of the chain, like
+// wei.  Spent sums the costs transactors report, and until a transaction is reported EstimatedCost of the budget is
+// reserved for it, so enqueues stop before the budget could be overspent.  Zero limits and an empty budget are
+// unlimited.
+type Campaign struct {
+	gorm.Model
+	Name                  string     `gorm:"column:name; uniqueIndex" binding:"required"`
+	ChainId               string     `gorm:"column:chain_id"`
+	Budget                string     `gorm:"column:budget"`
+	Spent                 string     `gorm:"column:spent"`
+	EstimatedCost         string     `gorm:"column:estimated_cost"`
+	MaxPerWallet          int        `gorm:"column:max_per_wallet"`
+	MaxPerDay             int        `gorm:"column:max_per_day"`
+	MaxTokenQuantity      int        `gorm:"column:max_token_quantity"`
+	MaxTotalTokenQuantity int        `gorm:"column:max_total_token_quantity"`
+	StartsAt              *time.Time `gorm:"column:starts_at"`
+	EndsAt                *time.Time `gorm:"column:ends_at"`
+	DisabledAt            *time.Time `gorm:"column:disabled_at"`
+}
+
+// ParseCost parses a cost or budget, a non-negative decimal integer
+func ParseCost(s string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 {
+		return nil, fmt.Errorf("bad cost %s, must be a non-negative integer", s)
+	}
+	return n, nil
+}
+
+// Validate checks the limits and dates of c
+func (c *Campaign) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("campaign name required")
+	}
+	if c.Budget != "" {
+		if _, err := ParseCost(c.Budget); err != nil {
+			return err
+		}
+		estimate, err := ParseCost(c.EstimatedCost)
+		if err != nil || estimate.Sign() == 0 {
+			return fmt.Errorf("campaign with a budget needs a positive estimatedCost per transaction")
+		}
+	}
+	if c.MaxPerWallet < 0 || c.MaxPerDay < 0 || c.MaxTokenQuantity < 0 || c.MaxTotalTokenQuantity < 0 {
+		return fmt.Errorf("campaign limits must not be negative")
+	}
+	if c.StartsAt != nil && c.EndsAt != nil && !c.EndsAt.After(*c.StartsAt) {
+		return fmt.Errorf("campaign must end after it starts")
+	}
+	return nil
+}
+
+// Open returns nil if c takes transactions at now, or why not wrapping ErrCampaignClosed
+func (c *Campaign) Open(now time.Time) error {
+	switch {
+	case c.DisabledAt != nil:
+		return fmt.Errorf("%w, %s is disabled", ErrCampaignClosed, c.Name)
+	case c.StartsAt != nil && now.Before(*c.StartsAt):
+		return fmt.Errorf("%w, %s starts at %s", ErrCampaignClosed, c.Name, c.StartsAt.UTC().Format(time.RFC3339))
+	case c.EndsAt != nil && !now.Before(*c.EndsAt):
+		return fmt.Errorf("%w, %s ended at %s", ErrCampaignClosed, c.Name, c.EndsAt.UTC().Format(time.RFC3339))
+	}
+	if c.Budget != "" {
+		budget, err := ParseCost(c.Budget)
+		if err != nil {
+			return err
+		}
+		if c.spent().Cmp(budget) >= 0 {
+			return fmt.Errorf("%w, %s spent its budget of %s", ErrCampaignClosed, c.Name, c.Budget)
+		}
+	}
+	return nil
+}
+
+// spent returns what c spent so far
+func (c *Campaign) spent() *big.Int {
+	if spent, err := ParseCost(c.Spent); err == nil {
+		return spent
+	}
+	return new(big.Int)
+}
+
+// CreateCampaign saves a new campaign c
+func CreateCampaign(c *Campaign) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	c.Spent = "0"
+	if res := Db.Create(c); res.Error != nil {
+		return fmt.Errorf("cannot save campaign %s %v", c.Name, res.Error)
+	}
+	return nil
+}
+
+// FindCampaign returns the campaign called name, or nil if there is none
+func FindCampaign(name string) (*Campaign, error) {
+	var campaigns []Campaign
+	res := Db.Where("name = ?", name).Limit(1).Find(&campaigns)
+	if res.Error != nil {
+		return nil, fmt.Errorf("cannot get campaign %s %v", name, res.Error)
+	}
+	if len(campaigns) == 0 {
+		return nil, nil
+	}
+	return &campaigns[0], nil
+}
+
+// CampaignTransactionCounts returns how many transactions were enqueued in each campaign with ids, failed ones
+// included, but not those the queue rejected
+func CampaignTransactionCounts(ids []uint) (map[uint]int64, error) {
+	var rows []struct {
+		CampaignID uint
+		Count      int64
+	}
+	counts := map[uint]int64{}
+	if len(ids) == 0 {
+		return counts, nil
+	}
+	res := Db.Model(&Transaction{}).
+		Select("campaign_id, COUNT(*) AS count").
+		Where("campaign_id IN ? AND status <> ?", ids, TRANSACTION_STATUS_ERROR).
+		Group("campaign_id").
+		Find(&rows)
+	if res.Error != nil {
+		return nil, fmt.Errorf("cannot count campaign transactions %v", res.Error)
+	}
+	for _, r := range rows {
+		counts[r.CampaignID] = r.Count
+	}
+	return counts, nil
+}
+
+// checkCampaignLimits locks the campaign of tx in db and returns an error if enqueuing tx at now breaks its limits.
+// The lock is held until db commits, so concurrent enqueues in a campaign are counted one after the other.
+func checkCampaignLimits(db *gorm.DB, tx *Transaction, now time.Time) error {
+
+	var c Campaign
+	if err := db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&c, tx.CampaignID).Error; err != nil {
+		return err
+	}
+	if err := c.Open(now); err != nil {
+		return err
+	}
+	if c.MaxTokenQuantity > 0 && tx.TokenQuantity > c.MaxTokenQuantity {
+		return fmt.Errorf("%w, %s allows at most %d tokens per transaction", ErrCampaignLimit, c.Name, c.MaxTokenQuantity)
+	}
+
+	counted := db.Model(&Transaction{}).Where("campaign_id = ? AND status <> ?", c.ID, TRANSACTION_STATUS_ERROR)
+	if c.MaxPerWallet > 0 {
+		var n int64
+		if err := counted.Session(&gorm.Session{}).Where("wallet_addr = ?", tx.WalletAddr).Count(&n).Error; err != nil {
+			return err
+		}
+		if n >= int64(c.MaxPerWallet) {
+			return fmt.Errorf("%w, %s allows %d transactions per wallet", ErrCampaignLimit, c.Name, c.MaxPerWallet)
+		}
+	}
+	if c.MaxPerDay > 0 {
+		var n int64
+		if err := counted.Session(&gorm.Session{}).Where("created_at > ?", now.Add(-24*time.Hour)).Count(&n).Error; err != nil {
+			return err
+		}
+		if n >= int64(c.MaxPerDay) {
+			return fmt.Errorf("%w, %s allows %d transactions in 24 hours", ErrCampaignLimit, c.Name, c.MaxPerDay)
+		}
+	}
+	if c.MaxTotalTokenQuantity > 0 {
+		var total int64
+		err := counted.Session(&gorm.Session{}).Select("COALESCE(SUM(token_quantity), 0)").Scan(&total).Error
+		if err != nil {
+			return err
+		}
+		if total+int64(tx.TokenQuantity) > int64(c.MaxTotalTokenQuantity) {
+			return fmt.Errorf("%w, %s has %d of %d tokens left", ErrCampaignLimit, c.Name,
+				int64(c.MaxTotalTokenQuantity)-total, c.MaxTotalTokenQuantity)
+		}
+	}
+	if c.Budget != "" {
+		var unreported int64
+		err := counted.Session(&gorm.Session{}).
+			Where("status IN ?", []int{TRANSACTION_STATUS_PENDING, TRANSACTION_STATUS_IN_FLIGHT}).
+			Count(&unreported).Error
+		if err != nil {
+			return err
+		}
+		if !c.reserves(unreported + 1) {
+			return fmt.Errorf("%w, %s has %d transactions to report and not enough budget left for more",
+				ErrCampaignLimit, c.Name, unreported)
+		}
+	}
+	return nil
+}
+
+// reserves returns true if the budget of c has the estimated cost of n more transactions left
+func (c *Campaign) reserves(n int64) bool {
+	budget, err := ParseCost(c.Budget)
+	if err != nil {
+		return false
+	}
+	estimate, err := ParseCost(c.EstimatedCost)
+	if err != nil {
+		return false
+	}
+	committed := new(big.Int).Mul(estimate, big.NewInt(n))
+	committed.Add(committed, c.spent())
+	return committed.Cmp(budget) <= 0
+}
+
+// addCampaignSpend adds cost to what campaign id spent in db
+func addCampaignSpend(db *gorm.DB, id uint, cost string) error {
+
+	amount, err := ParseCost(cost)
+	if err != nil {
+		return err
+	}
+	var c Campaign
+	if err := db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&c, id).Error; err != nil {
+		return err
+	}
+	spent := new(big.Int).Add(c.spent(), amount)
+	return db.Model(&c).Update("spent", spent.String()).Error
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCampaignOpen(t *testing.T) {
+
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+
+	c := Campaign{Name: "launch", Budget: "1000", Spent: "999", EstimatedCost: "1", StartsAt: &before, EndsAt: &after}
+	assert.Nil(t, c.Validate())
+	assert.Nil(t, c.Open(now))
+
+	for _, closed := range []Campaign{
+		{Name: "launch", Budget: "1000", Spent: "1000"},
+		{Name: "launch", Budget: "0"},
+		{Name: "launch", StartsAt: &after},
+		{Name: "launch", EndsAt: &now},
+		{Name: "launch", DisabledAt: &before},
+	} {
+		err := closed.Open(now)
+		assert.True(t, errors.Is(err, ErrCampaignClosed), "%v", err)
+	}
+
+	// no budget and no dates is always open
+	assert.Nil(t, (&Campaign{Name: "launch", Spent: "5"}).Open(now))
+
+	for _, bad := range []Campaign{
+		{},
+		{Name: "launch", Budget: "1e18", EstimatedCost: "1"},
+		{Name: "launch", Budget: "1000"},
+		{Name: "launch", Budget: "1000", EstimatedCost: "0"},
+		{Name: "launch", Budget: "-1"},
+		{Name: "launch", MaxPerWallet: -1},
+		{Name: "launch", StartsAt: &after, EndsAt: &before},
+	} {
+		assert.NotNil(t, bad.Validate(), "%+v", bad)
+	}
+}
+
+func TestCampaignReserves(t *testing.T) {
+
+	c := Campaign{Name: "launch", Budget: "1000", Spent: "400", EstimatedCost: "200"}
+	assert.True(t, c.reserves(3))
+	assert.False(t, c.reserves(4))
+	c.EstimatedCost = ""
+	assert.False(t, c.reserves(1))
+}
+
+func TestParseCost(t *testing.T) {
+
+	n, err := ParseCost("115792089237316195423570985008687907853269984665640564039457584007913129639935")
+	assert.Nil(t, err)
+	assert.Equal(t, 256, n.BitLen())
+	for _, bad := range []string{"", "0x10", "1.5", "-3", " 1"} {
+		_, err := ParseCost(bad)
+		assert.NotNil(t, err, bad)
+	}
+}
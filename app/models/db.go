@@ -35,6 +35,148 @@ var (
 				return err
 			},
 		},
+		{
+			ID: "20261019000001",
+			Migrate: func(tx *gorm.DB) error {
+				err := tx.AutoMigrate(
+					&Arc{},
+					&Pin{},
+					&PinnedArc{},
+				)
+				return err
+			},
+		},
+		{
+			ID: "20261019000002",
+			Migrate: func(tx *gorm.DB) error {
+				err := tx.AutoMigrate(
+					&ApiKey{},
+				)
+				return err
+			},
+		},
+		{
+			ID: "20261019000003",
+			Migrate: func(tx *gorm.DB) error {
+				err := tx.AutoMigrate(
+					&Arc{},
+					&Pin{},
+					&PinnedArc{},
+					&Follow{},
+				)
+				return err
+			},
+		},
+		{
+			ID: "20261019000004",
+			Migrate: func(tx *gorm.DB) error {
+				err := tx.AutoMigrate(
+					&Transaction{},
+				)
+				return err
+			},
+		},
+		{
+			// uid becomes unique, newer duplicates keep their row with the id appended to the uid
+			ID: "20261019000005",
+			Migrate: func(tx *gorm.DB) error {
+				err := tx.Exec(`UPDATE transactions SET uid = uid || '-dup-' || id
+					WHERE id NOT IN (SELECT MIN(id) FROM transactions GROUP BY uid)`).Error
+				if err != nil {
+					return err
+				}
+				if tx.Migrator().HasIndex(&Transaction{}, "idx_transactions_uid") {
+					err = tx.Migrator().DropIndex(&Transaction{}, "idx_transactions_uid")
+					if err != nil {
+						return err
+					}
+				}
+				return tx.Migrator().CreateIndex(&Transaction{}, "Uid")
+			},
+		},
+		{
+			ID: "20261019000006",
+			Migrate: func(tx *gorm.DB) error {
+				err := tx.AutoMigrate(
+					&TransactionEvent{},
+				)
+				return err
+			},
+		},
+		{
+			ID: "20261019000007",
+			Migrate: func(tx *gorm.DB) error {
+				err := tx.AutoMigrate(
+					&TransactionCallback{},
+				)
+				return err
+			},
+		},
+		{
+			ID: "20261019000008",
+			Migrate: func(tx *gorm.DB) error {
+				err := tx.AutoMigrate(
+					&CallbackDestination{},
+					&Transaction{},
+					&TransactionCallback{},
+				)
+				return err
+			},
+		},
+		{
+			ID: "20261019000009",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&Transaction{})
+			},
+		},
+		{
+			ID: "20261019000010",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&ObjectToken{})
+			},
+		},
+		{
+			ID: "20261019000011",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&Campaign{}, &Transaction{})
+			},
+		},
+		{
+			ID: "20261019000012",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&Campaign{})
+			},
+		},
+		{
+			ID: "20261019000013",
+			Migrate: func(tx *gorm.DB) error {
+				err := tx.AutoMigrate(&CallbackDestination{})
+				if err != nil {
+					return err
+				}
+				_, err = RegisterLegacyCallbackDestinations(tx)
+				return err
+			},
+		},
+		{
+			// a user follows another once, newer duplicates are unfollowed
+			ID: "20261019000014",
+			Migrate: func(tx *gorm.DB) error {
+				err := tx.Exec(`UPDATE follows SET deleted_at = NOW()
+					WHERE deleted_at IS NULL AND id NOT IN (SELECT MIN(id) FROM follows
+					WHERE deleted_at IS NULL GROUP BY follower_uid, followee_uid)`).Error
+				if err != nil {
+					return err
+				}
+				return tx.Migrator().CreateIndex(&Follow{}, "idx_follows_pair")
+			},
+		},
+		{
+			ID: "20261019000015",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&MediaUpload{})
+			},
+		},
 	}
 
 	// Db is the global database reference
@@ -48,6 +190,13 @@ var (
 		"place",
 		"layer",
 		"transaction",
+		"api_keys",
+		"follows",
+		"transaction_events",
+		"transaction_callbacks",
+		"callback_destinations",
+		"object_tokens",
+		"campaigns",
 	}
 )
 
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Follow records that the follower user follows the followee user.  A user follows another at most once, unfollowed
+// rows are soft deleted and don't count.
+type Follow struct {
+	gorm.Model
+	FollowerUid string `gorm:"column:follower_uid; index; uniqueIndex:idx_follows_pair,where:deleted_at IS NULL" binding:"required"`
+	FolloweeUid string `gorm:"column:followee_uid; index; uniqueIndex:idx_follows_pair,where:deleted_at IS NULL" binding:"required"`
+}
+
+// AddFollow makes followerUid follow followeeUid, following again does nothing
+func AddFollow(followerUid string, followeeUid string) error {
+	res := Db.Clauses(clause.OnConflict{DoNothing: true}).Create(&Follow{FollowerUid: followerUid, FolloweeUid: followeeUid})
+	if res.Error != nil {
+		return fmt.Errorf("cannot save follow %v", res.Error)
+	}
+	return nil
+}
+
+// IsFollowing returns true if followerUid follows followeeUid
+func IsFollowing(followerUid string, followeeUid string) bool {
+	var count int64
+	res := Db.Model(&Follow{}).Where("follower_uid = ? AND followee_uid = ?", followerUid, followeeUid).Count(&count)
+	return res.Error == nil && count > 0
+}
@@ -11,8 +11,9 @@ const (
 
 type MediaUpload struct {
 	gorm.Model
-	SessionID string  `gorm:"column:session_id; index"`
-	Path      string  `gorm:"column:path; index"`
-	Status    byte    `gorm:"column:status`
-	Metadata  JSONMap `gorm:"column:metadata"`
+	SessionID   string  `gorm:"column:session_id; index"`
+	Path        string  `gorm:"column:path; index"`
+	Status      byte    `gorm:"column:status`
+	Metadata    JSONMap `gorm:"column:metadata"`
+	UploaderUid string  `gorm:"column:uploader_uid"` // JWT user that began the upload, empty for App-Key only
 }
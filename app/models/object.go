@@ -6,11 +6,23 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	ObjectPrivacyPublic    = "public"    // anyone may fetch
+	ObjectPrivacyFollowers = "followers" // owner and followers of owner may fetch
+	ObjectPrivacyPrivate   = "private"   // only owner may fetch
+
+	ObjectVisibilityVisible  = "visible"  // listed in search results
+	ObjectVisibilityUnlisted = "unlisted" // fetchable by cid, not listed in search results
+)
+
 type Object struct {
 	gorm.Model
 	Cid            string    `gorm:"column:cid; index" binding:"required"`
 	OwnerUid       string    `gorm:"column:owner_uid; index" binding:"required"`
 	OwnerProvider  string    `gorm:"column:owner_provider; index" binding:"required"`
+	UploaderUid    string    `gorm:"column:uploader_uid; index"`
+	Privacy        string    `gorm:"column:privacy; index"`
+	Visibility     string    `gorm:"column:visibility; index"`
 	Name           string    `gorm:"column:name; index" binding:"required"`
 	Description    string    `gorm:"description; index"`
 	CoverImageUri  string    `gorm:"coverImageUri"`
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ObjectToken is a token minted for an indexed object, so clients can show who owns it
+type ObjectToken struct {
+	gorm.Model
+	ObjectCid     string `gorm:"column:object_cid; index"`
+	TransactionID uint   `gorm:"column:transaction_id; index"`
+	ChainId       string `gorm:"column:chain_id; uniqueIndex:idx_object_tokens_token"`
+	ContractAddr  string `gorm:"column:contract_addr; uniqueIndex:idx_object_tokens_token"`
+	TokenId       string `gorm:"column:token_id; uniqueIndex:idx_object_tokens_token"`
+	WalletAddr    string `gorm:"column:wallet_addr; index"`
+	WalletKind    string `gorm:"column:wallet_kind"`
+	TokenUri      string `gorm:"column:token_uri"`
+}
+
+// recordObjectToken saves token in db, a token minted again on the same chain and contract replaces the earlier record
+func recordObjectToken(db *gorm.DB, token *ObjectToken) error {
+	res := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chain_id"}, {Name: "contract_addr"}, {Name: "token_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"object_cid", "transaction_id", "wallet_addr", "wallet_kind", "token_uri", "updated_at"}),
+	}).Create(token)
+	if res.Error != nil {
+		return fmt.Errorf("cannot record token %s of %s %v", token.TokenId, token.ObjectCid, res.Error)
+	}
+	return nil
+}
+
+// FindObjectTokens returns the tokens minted for object cid, oldest first
+func FindObjectTokens(cid string) ([]ObjectToken, error) {
+	var tokens []ObjectToken
+	res := Db.Where("object_cid = ?", cid).Order("created_at, id").Find(&tokens)
+	if res.Error != nil {
+		return nil, fmt.Errorf("cannot find tokens of %s %v", cid, res.Error)
+	}
+	return tokens, nil
+}
@@ -1,37 +1,199 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 const (
-	TRANSACTION_STATUS_PENDING    = 1
-	TRANSACTION_STATUS_DONE       = 2
-	TRANSACTION_STATUS_ERROR      = 3
-	TRANSACTION_STATUS_PENDING_CB = 4
+	TRANSACTION_STATUS_PENDING       = 1
+	TRANSACTION_STATUS_DONE          = 2
+	TRANSACTION_STATUS_ERROR         = 3
+	TRANSACTION_STATUS_PENDING_CB    = 4
+	TRANSACTION_STATUS_IN_FLIGHT     = 5 // leased to a transactor worker
+	TRANSACTION_STATUS_CALLBACK_DEAD = 6 // done, but the callback could not be delivered
 )
 
+// TransactionStatusNames are the names of the statuses shown to clients
+var TransactionStatusNames = map[byte]string{
+	TRANSACTION_STATUS_PENDING:       "pending",
+	TRANSACTION_STATUS_DONE:          "done",
+	TRANSACTION_STATUS_ERROR:         "error",
+	TRANSACTION_STATUS_PENDING_CB:    "pendingCallback",
+	TRANSACTION_STATUS_IN_FLIGHT:     "inFlight",
+	TRANSACTION_STATUS_CALLBACK_DEAD: "callbackDead",
+}
+
 const (
 	TRANSACTION_KIND_NFT   = 1
 	TRANSACTION_KIND_TOKEN = 2
 )
 
+var (
+	// ErrTransactionReported is returned for a worker reporting a transaction that was already reported
+	ErrTransactionReported = errors.New("transaction already reported")
+	// ErrTransactionLeaseLost is returned for a worker renewing the lease of a transaction it no longer has
+	ErrTransactionLeaseLost = errors.New("transaction lease lost")
+)
+
 type Transaction struct {
 	gorm.Model
-	Uid           string    `gorm:"column:uid; index" binding:"required"`
-	Kind          string    `gorm:"column:kind" binding:"required"`
-	Status        byte      `gorm:"column:status" binding:"required"`
-	WalletAddr    string    `json:"column:wallet_addr" binding:"required"`
-	WalletKind    string    `json:"column:wallet_kind" binding:"required"`
-	CallbackUri   string    `json:"column:callback_uri" binding:"required"`
-	IpfsCid       string    `json:"column:ipfs_cid"`
-	TokenQuantity int       `json:"column:token_quantity"`
-	Metadata      JSONMap   `gorm:"column:metadata"`
-	Cost          string    `json:"column:cost"`
-	ContractAddr  string    `json:"column:contract_addr"`
-	ErrorCount    int       `json:"column:error_count"`
-	LastErrorAt   time.Time `json:"column:last_error_at"`
-	LastError     string    `json:"column:last_error"`
+	Uid                   string     `gorm:"column:uid; uniqueIndex" binding:"required"`
+	Kind                  string     `gorm:"column:kind" binding:"required"`
+	Status                byte       `gorm:"column:status; index" binding:"required"`
+	WalletAddr            string     `json:"column:wallet_addr" binding:"required"`
+	WalletKind            string     `json:"column:wallet_kind" binding:"required"`
+	ChainId               string     `gorm:"column:chain_id; index"`
+	CallbackUri           string     `json:"column:callback_uri" binding:"required"`
+	CallbackDestinationID uint       `gorm:"column:callback_destination_id; index"`
+	CampaignID            uint       `gorm:"column:campaign_id; index"`
+	IpfsCid               string     `json:"column:ipfs_cid"`
+	TokenQuantity         int        `json:"column:token_quantity"`
+	Metadata              JSONMap    `gorm:"column:metadata"`
+	Cost                  string     `json:"column:cost"`
+	ContractAddr          string     `json:"column:contract_addr"`
+	ErrorCount            int        `json:"column:error_count"`
+	LastErrorAt           time.Time  `json:"column:last_error_at"`
+	LastError             string     `json:"column:last_error"`
+	LeasedBy              string     `gorm:"column:leased_by"`
+	LeaseExpiresAt        *time.Time `gorm:"column:lease_expires_at; index"`
+}
+
+// SameRequest returns true if other asks for the same transaction as tx, so enqueuing it again is a retry
+func (tx *Transaction) SameRequest(other *Transaction) bool {
+	return tx.Uid == other.Uid &&
+		tx.Kind == other.Kind &&
+		tx.WalletAddr == other.WalletAddr &&
+		tx.WalletKind == other.WalletKind &&
+		tx.ChainId == other.ChainId &&
+		tx.CampaignID == other.CampaignID &&
+		tx.CallbackUri == other.CallbackUri &&
+		tx.IpfsCid == other.IpfsCid &&
+		tx.TokenQuantity == other.TokenQuantity &&
+		reflect.DeepEqual(tx.Metadata["spec"], other.Metadata["spec"])
+}
+
+// Reported returns true once a worker reported the result of tx
+func (tx *Transaction) Reported() bool {
+	return tx.Status == TRANSACTION_STATUS_DONE || tx.Status == TRANSACTION_STATUS_PENDING_CB ||
+		tx.Status == TRANSACTION_STATUS_CALLBACK_DEAD
+}
+
+// LeaseTransactions leases up to max of the oldest pending transactions to worker for lease.  Rows locked by other
+// workers leasing at the same time are skipped, so no transaction is handed to two workers.
+func LeaseTransactions(worker string, max int, lease time.Duration) ([]Transaction, error) {
+
+	var txs []Transaction
+	err := Db.Transaction(func(db *gorm.DB) error {
+		res := db.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ?", TRANSACTION_STATUS_PENDING).
+			Order("created_at").
+			Limit(max).
+			Find(&txs)
+		if res.Error != nil || len(txs) == 0 {
+			return res.Error
+		}
+
+		expiresAt := time.Now().Add(lease)
+		ids := make([]uint, len(txs))
+		events := make([]TransactionEvent, len(txs))
+		for i := range txs {
+			ids[i] = txs[i].ID
+			txs[i].Status = TRANSACTION_STATUS_IN_FLIGHT
+			txs[i].LeasedBy = worker
+			txs[i].LeaseExpiresAt = &expiresAt
+			events[i] = newTransactionEvent(&txs[i], "leased until "+expiresAt.UTC().Format(time.RFC3339))
+		}
+		err := db.Model(&Transaction{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":           TRANSACTION_STATUS_IN_FLIGHT,
+			"leased_by":        worker,
+			"lease_expires_at": expiresAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return db.Create(&events).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot lease transactions %v", err)
+	}
+	return txs, nil
+}
+
+// RenewTransactionLease extends the lease of the in-flight transaction uid held by worker to lease from now.  The
+// error wraps ErrTransactionLeaseLost when the transaction was requeued, leased to another worker or reported.  The
+// transaction is nil if there is none with uid.
+func RenewTransactionLease(uid string, worker string, lease time.Duration) (*Transaction, error) {
+
+	var tx Transaction
+	err := Db.Transaction(func(db *gorm.DB) error {
+		res := db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("uid = ?", uid).Limit(1).Find(&tx)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		if tx.Status != TRANSACTION_STATUS_IN_FLIGHT || tx.LeasedBy != worker {
+			return fmt.Errorf("%w, %s is %s leased by %s", ErrTransactionLeaseLost, uid,
+				TransactionStatusNames[tx.Status], tx.LeasedBy)
+		}
+		expiresAt := time.Now().Add(lease)
+		tx.LeaseExpiresAt = &expiresAt
+		if err := db.Model(&tx).Update("lease_expires_at", expiresAt).Error; err != nil {
+			return err
+		}
+		event := newTransactionEvent(&tx, "lease renewed until "+expiresAt.UTC().Format(time.RFC3339))
+		return db.Create(&event).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrTransactionLeaseLost) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("cannot renew transaction lease %s %v", uid, err)
+	}
+	if tx.ID == 0 {
+		return nil, nil
+	}
+	return &tx, nil
+}
+
+// RequeueExpiredTransactionLeases puts in-flight transactions whose lease expired before now back in the queue and
+// returns how many were requeued
+func RequeueExpiredTransactionLeases(now time.Time) (int64, error) {
+
+	var n int64
+	err := Db.Transaction(func(db *gorm.DB) error {
+		var txs []Transaction
+		res := db.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND lease_expires_at < ?", TRANSACTION_STATUS_IN_FLIGHT, now).
+			Find(&txs)
+		if res.Error != nil || len(txs) == 0 {
+			return res.Error
+		}
+
+		ids := make([]uint, len(txs))
+		events := make([]TransactionEvent, len(txs))
+		for i := range txs {
+			ids[i] = txs[i].ID
+			events[i] = newTransactionEvent(&txs[i], "lease expired, requeued")
+			events[i].Status = TRANSACTION_STATUS_PENDING
+		}
+		res = db.Model(&Transaction{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":           TRANSACTION_STATUS_PENDING,
+			"leased_by":        "",
+			"lease_expires_at": nil,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		n = res.RowsAffected
+		return db.Create(&events).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("cannot requeue expired transactions %v", err)
+	}
+	return n, nil
 }
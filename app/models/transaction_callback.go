@@ -0,0 +1,184 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	CALLBACK_STATUS_PENDING   = 1
+	CALLBACK_STATUS_DELIVERED = 2
+	CALLBACK_STATUS_DEAD      = 3 // gave up after too many attempts, see RedeliverTransactionCallback
+)
+
+// TransactionCallback is an outbox entry reporting a transaction result to its callback URI.  It is saved with the
+// transaction status, so results are not lost when the callback receiver is down.
+type TransactionCallback struct {
+	gorm.Model
+	TransactionID uint       `gorm:"column:transaction_id; index"`
+	DestinationID uint       `gorm:"column:destination_id"`
+	Uri           string     `gorm:"column:uri"`
+	Body          string     `gorm:"column:body"`
+	Status        byte       `gorm:"column:status; index"`
+	Attempts      int        `gorm:"column:attempts"`
+	NextAttemptAt time.Time  `gorm:"column:next_attempt_at; index"`
+	LastErrorAt   *time.Time `gorm:"column:last_error_at"`
+	LastError     string     `gorm:"column:last_error"`
+	DeliveredAt   *time.Time `gorm:"column:delivered_at"`
+}
+
+// SaveTransactionWithCallback saves tx with the tokens it minted and queues a callback posting body to its callback
+// URI.  The reported cost of tx is added to what its campaign spent.  Only the first report of tx is saved, later
+// ones, like a worker retrying after a timeout, get an error wrapping ErrTransactionReported.
+func SaveTransactionWithCallback(tx *Transaction, tokens []ObjectToken, body string, message string) error {
+	err := Db.Transaction(func(db *gorm.DB) error {
+		// concurrent reports of tx wait for the row lock, then see it reported
+		var current Transaction
+		if err := db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, tx.ID).Error; err != nil {
+			return err
+		}
+		if current.Reported() {
+			return ErrTransactionReported
+		}
+		if err := db.Save(tx).Error; err != nil {
+			return err
+		}
+		for i := range tokens {
+			if err := recordObjectToken(db, &tokens[i]); err != nil {
+				return err
+			}
+		}
+		if tx.CampaignID != 0 && tx.Cost != "" {
+			if err := addCampaignSpend(db, tx.CampaignID, tx.Cost); err != nil {
+				return err
+			}
+		}
+		cb := TransactionCallback{
+			TransactionID: tx.ID,
+			DestinationID: tx.CallbackDestinationID,
+			Uri:           tx.CallbackUri,
+			Body:          body,
+			Status:        CALLBACK_STATUS_PENDING,
+			NextAttemptAt: time.Now(),
+		}
+		if err := db.Create(&cb).Error; err != nil {
+			return err
+		}
+		event := newTransactionEvent(tx, message)
+		return db.Create(&event).Error
+	})
+	if err != nil {
+		return fmt.Errorf("cannot save transaction %s %w", tx.Uid, err)
+	}
+	return nil
+}
+
+// ClaimDueTransactionCallbacks returns up to max pending callbacks due at now and pushes their next attempt out by
+// claim, so other dispatchers skip them while they are delivered
+func ClaimDueTransactionCallbacks(now time.Time, max int, claim time.Duration) ([]TransactionCallback, error) {
+
+	var cbs []TransactionCallback
+	err := Db.Transaction(func(db *gorm.DB) error {
+		res := db.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", CALLBACK_STATUS_PENDING, now).
+			Order("next_attempt_at").
+			Limit(max).
+			Find(&cbs)
+		if res.Error != nil || len(cbs) == 0 {
+			return res.Error
+		}
+		ids := make([]uint, len(cbs))
+		for i := range cbs {
+			ids[i] = cbs[i].ID
+		}
+		return db.Model(&TransactionCallback{}).Where("id IN ?", ids).
+			UpdateColumn("next_attempt_at", now.Add(claim)).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot claim transaction callbacks %v", err)
+	}
+	return cbs, nil
+}
+
+// FinishTransactionCallbackAttempt saves the outcome of delivering cb and moves its transaction to done, or records
+// deliveryErr.  Failed callbacks are retried at retryAt, or dead-lettered when retryAt is nil.
+func FinishTransactionCallbackAttempt(cb *TransactionCallback, deliveryErr error, retryAt *time.Time) error {
+
+	now := time.Now()
+	err := Db.Transaction(func(db *gorm.DB) error {
+		var tx Transaction
+		if err := db.First(&tx, cb.TransactionID).Error; err != nil {
+			return err
+		}
+
+		cb.Attempts = cb.Attempts + 1
+		message := "callback delivered"
+		if deliveryErr == nil {
+			cb.Status = CALLBACK_STATUS_DELIVERED
+			cb.DeliveredAt = &now
+			tx.Status = TRANSACTION_STATUS_DONE
+		} else {
+			cb.LastError = deliveryErr.Error()
+			cb.LastErrorAt = &now
+			tx.LastError = cb.LastError
+			tx.LastErrorAt = now
+			tx.ErrorCount = tx.ErrorCount + 1
+			if retryAt != nil {
+				cb.NextAttemptAt = *retryAt
+				message = fmt.Sprintf("callback attempt %d failed, retry at %s, %s",
+					cb.Attempts, retryAt.UTC().Format(time.RFC3339), cb.LastError)
+			} else {
+				cb.Status = CALLBACK_STATUS_DEAD
+				tx.Status = TRANSACTION_STATUS_CALLBACK_DEAD
+				message = fmt.Sprintf("callback dead after %d attempts, %s", cb.Attempts, cb.LastError)
+			}
+		}
+		if err := db.Save(cb).Error; err != nil {
+			return err
+		}
+		if err := db.Save(&tx).Error; err != nil {
+			return err
+		}
+		event := newTransactionEvent(&tx, message)
+		return db.Create(&event).Error
+	})
+	if err != nil {
+		return fmt.Errorf("cannot save transaction callback %d %v", cb.ID, err)
+	}
+	return nil
+}
+
+// RedeliverTransactionCallback queues the latest callback of tx again with fresh attempts.  Returns nil when tx has
+// no callback yet.
+func RedeliverTransactionCallback(tx *Transaction) (*TransactionCallback, error) {
+
+	var cbs []TransactionCallback
+	err := Db.Transaction(func(db *gorm.DB) error {
+		res := db.Where("transaction_id = ?", tx.ID).Order("id DESC").Limit(1).Find(&cbs)
+		if res.Error != nil || len(cbs) == 0 {
+			return res.Error
+		}
+		cbs[0].Status = CALLBACK_STATUS_PENDING
+		cbs[0].Attempts = 0
+		cbs[0].NextAttemptAt = time.Now()
+		if err := db.Save(&cbs[0]).Error; err != nil {
+			return err
+		}
+		tx.Status = TRANSACTION_STATUS_PENDING_CB
+		if err := db.Save(tx).Error; err != nil {
+			return err
+		}
+		event := newTransactionEvent(tx, "callback redelivery requested")
+		return db.Create(&event).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot redeliver transaction %s callback %v", tx.Uid, err)
+	}
+	if len(cbs) == 0 {
+		return nil, nil
+	}
+	return &cbs[0], nil
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TransactionEvent is a state change of a transaction, the events of a transaction are its timeline
+type TransactionEvent struct {
+	gorm.Model
+	TransactionID uint   `gorm:"column:transaction_id; index"`
+	Status        byte   `gorm:"column:status"`
+	Worker        string `gorm:"column:worker"`
+	Message       string `gorm:"column:message"`
+}
+
+// newTransactionEvent returns an event for the current status of tx
+func newTransactionEvent(tx *Transaction, message string) TransactionEvent {
+	return TransactionEvent{
+		TransactionID: tx.ID,
+		Status:        tx.Status,
+		Worker:        tx.LeasedBy,
+		Message:       message,
+	}
+}
+
+// CreateTransaction saves the new transaction tx with its first event.  Transactions in a campaign are only saved
+// within its limits, otherwise the error wraps ErrCampaignClosed or ErrCampaignLimit.
+func CreateTransaction(tx *Transaction) error {
+	return Db.Transaction(func(db *gorm.DB) error {
+		if tx.CampaignID != 0 {
+			if err := checkCampaignLimits(db, tx, time.Now()); err != nil {
+				return err
+			}
+		}
+		if err := db.Create(tx).Error; err != nil {
+			return err
+		}
+		event := newTransactionEvent(tx, "enqueued")
+		return db.Create(&event).Error
+	})
+}
+
+// SaveTransaction saves tx and records its status with message in its timeline
+func SaveTransaction(tx *Transaction, message string) error {
+	err := Db.Transaction(func(db *gorm.DB) error {
+		if err := db.Save(tx).Error; err != nil {
+			return err
+		}
+		event := newTransactionEvent(tx, message)
+		return db.Create(&event).Error
+	})
+	if err != nil {
+		return fmt.Errorf("cannot save transaction %s %v", tx.Uid, err)
+	}
+	return nil
+}
+
+// FindTransactionEvents returns the events of the transactions with ids, oldest first
+func FindTransactionEvents(ids []uint) ([]TransactionEvent, error) {
+	var events []TransactionEvent
+	if len(ids) == 0 {
+		return events, nil
+	}
+	res := Db.Where("transaction_id IN ?", ids).Order("created_at, id").Find(&events)
+	if res.Error != nil {
+		return nil, fmt.Errorf("cannot get transaction events %v", res.Error)
+	}
+	return events, nil
+}
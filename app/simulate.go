@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+
+	"github.com/wos-project/wos-core-go/app/models"
+	"github.com/wos-project/wos-core-go/app/simulator"
+)
+
+// simulatorDestinationName is the callback destination of the simulated receiver
+const simulatorDestinationName = "simulator"
+
+// startSimulator runs a simulated transactor worker against this server and a fake callback receiver, see simulator
+// in config.  The worker gets a fresh api key with the tx:worker scope that expires in a day.
+func startSimulator(ctx context.Context) {
+
+	listen := viper.GetString("simulator.receiver.listen")
+	if listen != "" {
+		var destination models.CallbackDestination
+		res := models.Db.Where("name = ?", simulatorDestinationName).Limit(1).Find(&destination)
+		if res.Error != nil {
+			glog.Fatalf("cannot get simulator callback destination %v", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			d, err := models.CreateCallbackDestination(simulatorDestinationName, viper.GetString("simulator.receiver.url"))
+			if err != nil {
+				glog.Fatalf("cannot register simulator callback destination %v", err)
+			}
+			destination = *d
+		}
+		receiver := simulator.NewReceiver([]byte(destination.Secret), viper.GetFloat64("simulator.receiver.failureRate"), 0)
+		go func() {
+			if err := http.ListenAndServe(listen, receiver); err != nil {
+				glog.Errorf("simulated callback receiver stopped %v", err)
+			}
+		}()
+		glog.Infof("simulated callback receiver for %s on %s", destination.Url, listen)
+	}
+
+	expiresAt := time.Now().Add(24 * time.Hour)
+	key, _, err := models.CreateApiKey("simulator", []string{models.ApiKeyScopeTxWorker}, &expiresAt)
+	if err != nil {
+		glog.Fatalf("cannot mint simulator api key %v", err)
+	}
+	baseUrl := viper.GetString("simulator.transactor.baseUrl")
+	if baseUrl == "" {
+		baseUrl = "http://127.0.0.1:" + viper.GetString("host.hosts.localhost.port") + "/" + viper.GetString("apiVersion")
+	}
+	transactor := simulator.NewTransactor(simulator.TransactorConfig{
+		BaseUrl:      baseUrl,
+		ApiKey:       key,
+		ApiKeyHeader: viper.GetString("auth.apiKey.key"),
+		WorkerId:     viper.GetString("simulator.transactor.workerId"),
+		MaxDequeue:   viper.GetInt("simulator.transactor.maxDequeue"),
+		PollInterval: time.Duration(viper.GetInt("simulator.transactor.pollSecs")) * time.Second,
+		MinLatency:   time.Duration(viper.GetInt("simulator.transactor.minLatencyMs")) * time.Millisecond,
+		MaxLatency:   time.Duration(viper.GetInt("simulator.transactor.maxLatencyMs")) * time.Millisecond,
+		FailureRate:  viper.GetFloat64("simulator.transactor.failureRate"),
+		DropRate:     viper.GetFloat64("simulator.transactor.dropRate"),
+	})
+	go transactor.Run(ctx)
+	glog.Infof("simulated transactor working %s", baseUrl)
+}
@@ -0,0 +1,80 @@
+package simulator
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/wos-project/wos-core-go/app/webhook"
+)
+
+// Delivery is a callback the receiver got
+type Delivery struct {
+	Id       string // webhook id, the same for retries of a callback
+	Path     string
+	Body     []byte
+	Verified bool // signed with the receiver secret
+	Accepted bool // answered 2xx, rejected deliveries are retried by the object store
+	At       time.Time
+}
+
+// Receiver is a fake callback destination.  It verifies callback signatures with Secret, fails FailureRate of the
+// deliveries with a 503 so they are retried, and records them all.
+type Receiver struct {
+	Secret      []byte
+	FailureRate float64
+	Tolerance   time.Duration // webhook.DefaultTolerance by default
+
+	mutex      sync.Mutex
+	rand       *rand.Rand
+	deliveries []Delivery
+}
+
+// NewReceiver returns a receiver verifying callbacks with secret that fails failureRate of them, seed 0 seeds from
+// the clock
+func NewReceiver(secret []byte, failureRate float64, seed int64) *Receiver {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Receiver{Secret: secret, FailureRate: failureRate, rand: rand.New(rand.NewSource(seed))}
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	tolerance := r.Tolerance
+	if tolerance == 0 {
+		tolerance = webhook.DefaultTolerance
+	}
+	d := Delivery{Id: req.Header.Get(webhook.HeaderId), Path: req.URL.Path, At: time.Now()}
+	body, err := webhook.VerifyRequest(req, r.Secret, tolerance)
+	if err == nil {
+		d.Verified = true
+		d.Body = body
+	} else {
+		d.Body, _ = ioutil.ReadAll(req.Body)
+	}
+
+	r.mutex.Lock()
+	failed := r.rand.Float64() < r.FailureRate
+	d.Accepted = d.Verified && !failed
+	r.deliveries = append(r.deliveries, d)
+	r.mutex.Unlock()
+
+	switch {
+	case !d.Verified:
+		w.WriteHeader(http.StatusUnauthorized)
+	case failed:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// Deliveries returns the callbacks received so far, oldest first
+func (r *Receiver) Deliveries() []Delivery {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Delivery(nil), r.deliveries...)
+}
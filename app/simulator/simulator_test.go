@@ -0,0 +1,156 @@
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/wos-project/wos-core-go/app/webhook"
+)
+
+// fakeQueue serves items once from /v1/transaction/queue and records callbacks
+type fakeQueue struct {
+	mutex     sync.Mutex
+	items     []queuedItem
+	callbacks []callback
+	headers   []http.Header
+}
+
+func (q *fakeQueue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.headers = append(q.headers, r.Header.Clone())
+	switch r.URL.Path {
+	case "/v1/transaction/queue":
+		if len(q.items) == 0 {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		json.NewEncoder(w).Encode(q.items)
+		q.items = nil
+	case "/v1/transaction/cb":
+		var cb callback
+		json.NewDecoder(r.Body).Decode(&cb)
+		q.callbacks = append(q.callbacks, cb)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestTransactor(t *testing.T) {
+
+	queue := &fakeQueue{items: []queuedItem{
+		{Kind: "erc721", Spec: map[string]interface{}{"uid": "a", "walletKind": "ethereum", "ipfsCid": "cid"}},
+		{Kind: "erc20", Spec: map[string]interface{}{"uid": "b", "walletKind": "ethereum", "tokenQuantity": 5}},
+		{Kind: "erc1155", Spec: map[string]interface{}{"uid": "c", "walletKind": "ethereum", "contractAddr": "0x1"}},
+		{Kind: "erc721", Spec: map[string]interface{}{"uid": "d", "walletKind": "solana"}},
+	}}
+	server := httptest.NewServer(queue)
+	defer server.Close()
+
+	transactor := NewTransactor(TransactorConfig{
+		BaseUrl:    server.URL + "/v1",
+		ApiKey:     "key",
+		WorkerId:   "sim1",
+		MinLatency: 10 * time.Millisecond,
+		MaxLatency: 20 * time.Millisecond,
+		Seed:       1,
+	})
+	start := time.Now()
+	n, err := transactor.RunOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+
+	assert.Equal(t, "key", queue.headers[0].Get("App-Key"))
+	assert.Equal(t, "sim1", queue.headers[0].Get("Worker-Id"))
+	if assert.Len(t, queue.callbacks, 4) {
+		for _, cb := range queue.callbacks {
+			assert.Equal(t, StatusOk, cb.Status)
+			assert.Len(t, cb.TxId, 66)
+			assert.NotEmpty(t, cb.Cost)
+		}
+		assert.Equal(t, "a", queue.callbacks[0].Uid)
+		assert.Equal(t, "1", queue.callbacks[0].TokenId)
+		assert.Equal(t, ContractAddrs["ethereum"], queue.callbacks[0].ContractAddr)
+		assert.Equal(t, 5, queue.callbacks[1].TokenQuantity)
+		assert.Equal(t, "", queue.callbacks[1].ContractAddr)
+		assert.Equal(t, "0x1", queue.callbacks[2].ContractAddr)
+		assert.Equal(t, "2", queue.callbacks[3].TokenId)
+		assert.Equal(t, ContractAddrs["solana"], queue.callbacks[3].ContractAddr)
+	}
+	assert.Equal(t, TransactorStats{Leased: 4, Reported: 4}, transactor.Stats())
+
+	// empty queue
+	n, err = transactor.RunOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// failed transactions have no tokens, dropped ones are not reported
+	queue.items = []queuedItem{{Kind: "erc721", Spec: map[string]interface{}{"uid": "e", "walletKind": "ethereum"}}}
+	failing := NewTransactor(TransactorConfig{BaseUrl: server.URL + "/v1", FailureRate: 1})
+	_, err = failing.RunOnce(context.Background())
+	assert.Nil(t, err)
+	if assert.Len(t, queue.callbacks, 5) {
+		assert.Equal(t, StatusFailed, queue.callbacks[4].Status)
+		assert.Equal(t, "", queue.callbacks[4].TokenId)
+	}
+	queue.items = []queuedItem{{Kind: "erc721", Spec: map[string]interface{}{"uid": "f", "walletKind": "ethereum"}}}
+	dropping := NewTransactor(TransactorConfig{BaseUrl: server.URL + "/v1", DropRate: 1})
+	_, err = dropping.RunOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, queue.callbacks, 5)
+	assert.Equal(t, TransactorStats{Leased: 1, Dropped: 1}, dropping.Stats())
+
+	// rejected api key
+	unauthorized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer unauthorized.Close()
+	_, err = NewTransactor(TransactorConfig{BaseUrl: unauthorized.URL}).RunOnce(context.Background())
+	assert.NotNil(t, err)
+}
+
+func TestReceiver(t *testing.T) {
+
+	secret := []byte("whsec_test")
+	receiver := NewReceiver(secret, 0, 1)
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	post := func(secrets [][]byte) int {
+		body := []byte(`{"uid": "a"}`)
+		req, _ := http.NewRequest("POST", server.URL+"/cb/erc721", bytes.NewReader(body))
+		webhook.SignRequest(req, "7", secrets, body, time.Now())
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.Nil(t, err) {
+			return 0
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, post([][]byte{[]byte("whsec_old"), secret}))
+	assert.Equal(t, http.StatusUnauthorized, post([][]byte{[]byte("whsec_other")}))
+	receiver.FailureRate = 1
+	assert.Equal(t, http.StatusServiceUnavailable, post([][]byte{secret}))
+
+	deliveries := receiver.Deliveries()
+	if assert.Len(t, deliveries, 3) {
+		assert.Equal(t, "7", deliveries[0].Id)
+		assert.Equal(t, "/cb/erc721", deliveries[0].Path)
+		assert.Equal(t, `{"uid": "a"}`, string(deliveries[0].Body))
+		assert.True(t, deliveries[0].Verified && deliveries[0].Accepted)
+		assert.False(t, deliveries[1].Verified || deliveries[1].Accepted)
+		assert.True(t, deliveries[2].Verified)
+		assert.False(t, deliveries[2].Accepted)
+	}
+}
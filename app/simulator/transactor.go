@@ -0,0 +1,318 @@
+// Package simulator stands in for the services around the transaction queue, so the whole enqueue, dequeue,
+// callback and retry cycle runs offline.  Transactor leases transactions from /transaction/queue like a real
+// transactor worker, but instead of sending them to a chain it waits a while and reports made up tx hashes, costs and
+// token ids to /transaction/cb.  Receiver is a callback destination that verifies and records the callbacks the
+// object store delivers.
+//
+//	t := simulator.NewTransactor(simulator.TransactorConfig{BaseUrl: "http://localhost:8080/v1", ApiKey: key})
+//	go t.Run(ctx)
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// StatusOk and StatusFailed are the statuses simulated transactions report
+	StatusOk     = "ok"
+	StatusFailed = "failed"
+
+	defaultApiKeyHeader = "App-Key"
+	defaultWorkerId     = "simulator"
+	defaultMaxDequeue   = 10
+	defaultPollInterval = 5 * time.Second
+)
+
+// ContractAddrs are the contracts simulated mints and calls report by wallet kind, when the transaction has none
+var ContractAddrs = map[string]string{
+	"ethereum": "0x5f5e1e8b1c4d0a36d3a43b4f2ba7f5e36c9a7e3b",
+	"solana":   "TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5DA",
+}
+
+// TransactorConfig configures a simulated transactor
+type TransactorConfig struct {
+	BaseUrl      string        // api root, like http://localhost:8080/v1
+	ApiKey       string        // api key with the tx:worker scope
+	ApiKeyHeader string        // header of the api key, App-Key by default
+	WorkerId     string        // sent as Worker-Id
+	MaxDequeue   int           // most transactions leased at once
+	PollInterval time.Duration // wait between polls of an empty queue
+	MinLatency   time.Duration // each transaction takes between MinLatency and MaxLatency
+	MaxLatency   time.Duration
+	FailureRate  float64 // fraction of transactions reported failed
+	DropRate     float64 // fraction of transactions never reported, as if the worker died, so their lease expires
+	Seed         int64   // seeds failures and latencies, 0 seeds from the clock
+	Client       *http.Client
+}
+
+// queuedItem is a transaction leased from /transaction/queue
+type queuedItem struct {
+	Kind           string                 `json:"kind"`
+	ChainId        string                 `json:"chainId"`
+	Spec           map[string]interface{} `json:"spec"`
+	LeaseExpiresAt time.Time              `json:"leaseExpiresAt"`
+}
+
+// callback is what a transactor posts to /transaction/cb
+type callback struct {
+	Uid           string      `json:"uid"`
+	TxId          string      `json:"txId"`
+	ContractAddr  string      `json:"contractAddr,omitempty"`
+	Status        string      `json:"status"`
+	IpfsCid       string      `json:"ipfsCid,omitempty"`
+	Cost          string      `json:"cost,omitempty"`
+	TokenQuantity int         `json:"tokenQuantity,omitempty"`
+	TokenId       string      `json:"tokenId,omitempty"`
+	Result        interface{} `json:"result,omitempty"`
+}
+
+// TransactorStats counts what a simulated transactor did
+type TransactorStats struct {
+	Leased   int
+	Reported int
+	Failed   int
+	Dropped  int
+}
+
+// Transactor is a simulated transactor worker
+type Transactor struct {
+	config TransactorConfig
+
+	mutex       sync.Mutex
+	rand        *mathrand.Rand
+	nextTokenId int64
+	stats       TransactorStats
+}
+
+// NewTransactor returns a simulated transactor with config, missing settings get defaults
+func NewTransactor(config TransactorConfig) *Transactor {
+	if config.ApiKeyHeader == "" {
+		config.ApiKeyHeader = defaultApiKeyHeader
+	}
+	if config.WorkerId == "" {
+		config.WorkerId = defaultWorkerId
+	}
+	if config.MaxDequeue <= 0 {
+		config.MaxDequeue = defaultMaxDequeue
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultPollInterval
+	}
+	if config.MaxLatency < config.MinLatency {
+		config.MaxLatency = config.MinLatency
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Transactor{config: config, rand: mathrand.New(mathrand.NewSource(seed)), nextTokenId: 1}
+}
+
+// Stats returns what the transactor did so far
+func (t *Transactor) Stats() TransactorStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.stats
+}
+
+// Run works the queue until ctx is done
+func (t *Transactor) Run(ctx context.Context) error {
+	for {
+		n, err := t.RunOnce(ctx)
+		if err != nil {
+			glog.Errorf("simulated transactor %s %v", t.config.WorkerId, err)
+		}
+		if n > 0 && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(t.config.PollInterval):
+		}
+	}
+}
+
+// RunOnce leases up to MaxDequeue transactions and reports them, and returns how many it leased
+func (t *Transactor) RunOnce(ctx context.Context) (int, error) {
+
+	items, err := t.lease(ctx)
+	if err != nil {
+		return 0, err
+	}
+	t.count(func(s *TransactorStats) { s.Leased += len(items) })
+	for i := range items {
+		if err := t.process(ctx, &items[i]); err != nil {
+			return len(items), err
+		}
+	}
+	return len(items), nil
+}
+
+// lease gets transactions from the queue
+func (t *Transactor) lease(ctx context.Context) ([]queuedItem, error) {
+
+	u := fmt.Sprintf("%s/transaction/queue?max=%d", t.config.BaseUrl, t.config.MaxDequeue)
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// 201 is an empty queue
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("queue status %d %s", resp.StatusCode, string(b))
+	}
+	var items []queuedItem
+	if err := json.Unmarshal(b, &items); err != nil {
+		return nil, fmt.Errorf("bad queue items %v", err)
+	}
+	return items, nil
+}
+
+// process waits the latency of item and reports it, unless it is dropped
+func (t *Transactor) process(ctx context.Context, item *queuedItem) error {
+
+	uid, _ := item.Spec["uid"].(string)
+	latency, failed, dropped := t.roll()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(latency):
+	}
+	if dropped {
+		glog.Infof("simulated transactor %s dropped %s", t.config.WorkerId, uid)
+		t.count(func(s *TransactorStats) { s.Dropped++ })
+		return nil
+	}
+
+	cb := t.result(item, failed)
+	b, err := json.Marshal(cb)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", t.config.BaseUrl+"/transaction/cb", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	// a conflict is a transaction another worker reported after our lease expired
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("callback of %s status %d %s", uid, resp.StatusCode, string(body))
+	}
+	t.count(func(s *TransactorStats) {
+		s.Reported++
+		if failed {
+			s.Failed++
+		}
+	})
+	return nil
+}
+
+// roll picks the latency of a transaction and whether it fails or is dropped
+func (t *Transactor) roll() (time.Duration, bool, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	latency := t.config.MinLatency
+	if spread := t.config.MaxLatency - t.config.MinLatency; spread > 0 {
+		latency += time.Duration(t.rand.Int63n(int64(spread)))
+	}
+	dropped := t.rand.Float64() < t.config.DropRate
+	failed := t.rand.Float64() < t.config.FailureRate
+	return latency, failed, dropped
+}
+
+// result makes up the callback of item, failed transactions have a tx hash and cost but no tokens
+func (t *Transactor) result(item *queuedItem, failed bool) *callback {
+
+	cb := callback{Status: StatusOk, TxId: randomHex(32)}
+	cb.Uid, _ = item.Spec["uid"].(string)
+	cb.ContractAddr, _ = item.Spec["contractAddr"].(string)
+	if cb.ContractAddr == "" && item.Kind != "erc20" {
+		walletKind, _ := item.Spec["walletKind"].(string)
+		cb.ContractAddr = ContractAddrs[walletKind]
+	}
+
+	t.mutex.Lock()
+	gas := 21000 + t.rand.Int63n(200000)
+	gwei := 1 + t.rand.Int63n(100)
+	t.mutex.Unlock()
+	cost := new(big.Int).Mul(big.NewInt(gas), big.NewInt(gwei*1000000000))
+	cb.Cost = cost.String()
+
+	if failed {
+		cb.Status = StatusFailed
+		return &cb
+	}
+	switch item.Kind {
+	case "erc20":
+		if q, ok := item.Spec["tokenQuantity"].(float64); ok {
+			cb.TokenQuantity = int(q)
+		}
+	case "erc721":
+		t.mutex.Lock()
+		cb.TokenId = strconv.FormatInt(t.nextTokenId, 10)
+		t.nextTokenId++
+		t.mutex.Unlock()
+	case "contractCall":
+		cb.Result = "0x"
+	}
+	return &cb
+}
+
+// do sends req as the worker
+func (t *Transactor) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set(t.config.ApiKeyHeader, t.config.ApiKey)
+	req.Header.Set("Worker-Id", t.config.WorkerId)
+	return t.config.Client.Do(req)
+}
+
+func (t *Transactor) count(f func(s *TransactorStats)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	f(&t.stats)
+}
+
+// randomHex returns 0x and n random bytes in hex, like a tx hash
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return "0x" + hex.EncodeToString(b)
+}
@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+)
+
+// default limits of commands, see commands.limits
+const (
+	defaultCommandTimeoutSecs    = 300
+	defaultCommandMaxConcurrent  = 4
+	defaultCommandMaxOutputBytes = 16 << 20
+	defaultCommandMaxStderrBytes = 64 << 10
+)
+
+// CommandError is a failed run of a commands.exec command
+type CommandError struct {
+	Name           string   // commands.exec name
+	Args           []string // args the command ran with
+	ExitCode       int      // -1 when the command did not exit by itself
+	TimedOut       bool     // killed after its timeout
+	OutputExceeded bool     // killed after writing more than the output cap
+	Stderr         string   // start of stderr
+	Err            error
+}
+
+func (e *CommandError) Error() string {
+	reason := fmt.Sprintf("exit %d", e.ExitCode)
+	if e.ExitCode == -1 {
+		reason = "failed"
+	}
+	if e.TimedOut {
+		reason = "timed out"
+	} else if e.OutputExceeded {
+		reason = "output exceeded"
+	}
+	msg := fmt.Sprintf("command %s %s %v", e.Name, reason, e.Err)
+	if e.Stderr != "" {
+		msg += " stderr: " + strings.TrimSpace(e.Stderr)
+	}
+	return msg
+}
+
+// cappedBuffer keeps the first max bytes written.  With exceed set, writing more calls it and is an error,
+// otherwise the rest is dropped.
+type cappedBuffer struct {
+	buf      bytes.Buffer
+	max      int
+	exceed   func()
+	exceeded bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	room := b.max - b.buf.Len()
+	if len(p) > room {
+		b.exceeded = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		if b.exceed != nil {
+			b.exceed()
+			return room, fmt.Errorf("output exceeds %d bytes", b.max)
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+var commandSlotsMutex sync.Mutex
+var commandSlots chan struct{}
+
+// acquireCommandSlot waits until fewer than commands.limits.maxConcurrent commands run, or ctx is done.
+// The returned func releases the slot.
+func acquireCommandSlot(ctx context.Context) (func(), error) {
+
+	// select picks at random when a slot is free and ctx is done too
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	max := viper.GetInt("commands.limits.maxConcurrent")
+	if max <= 0 {
+		max = defaultCommandMaxConcurrent
+	}
+	commandSlotsMutex.Lock()
+	if commandSlots == nil || cap(commandSlots) != max {
+		commandSlots = make(chan struct{}, max)
+	}
+	slots := commandSlots
+	commandSlotsMutex.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// commandLimit returns commands.exec.<name>.<key>, or commands.limits.<key>, or def
+func commandLimit(name string, key string, def int) int {
+	if v := viper.GetInt("commands.exec." + name + "." + key); v > 0 {
+		return v
+	}
+	if v := viper.GetInt("commands.limits." + key); v > 0 {
+		return v
+	}
+	return def
+}
+
+// commandArgs returns the args of command commands.exec.<name>.args with placeholders replaced by values.
+// The configured args are not modified.
+func commandArgs(name string, values map[string]string) []string {
+
+	configured := viper.GetStringSlice("commands.exec." + name + ".args")
+	args := make([]string, len(configured))
+	for i, arg := range configured {
+		if v, ok := values[arg]; ok {
+			args[i] = v
+		} else {
+			args[i] = arg
+		}
+	}
+	return args
+}
+
+// RunCommandContext runs command commands.exec.<name> with placeholders in its args replaced by values and extraArgs
+// appended, and returns its stdout.  Values and extra args come from uploads, so they may not look like options.
+// Files must be absolute paths, as the command runs in an empty temp working directory with only PATH and HOME set.
+// Commands are killed when ctx is done, after their timeoutSecs, or when stdout exceeds maxOutputBytes, and at most
+// maxConcurrent run at once, see commands.limits.  Failures are a *CommandError.
+func RunCommandContext(ctx context.Context, name string, values map[string]string, extraArgs ...string) ([]byte, error) {
+
+	command := viper.GetString("commands.exec." + name + ".command")
+	if command == "" {
+		return nil, fmt.Errorf("command %s not configured", name)
+	}
+	for _, v := range values {
+		if strings.HasPrefix(v, "-") {
+			return nil, fmt.Errorf("command %s value %q looks like an option", name, v)
+		}
+	}
+	for _, v := range extraArgs {
+		if v == "" || strings.HasPrefix(v, "-") {
+			return nil, fmt.Errorf("command %s arg %q looks like an option", name, v)
+		}
+	}
+	args := append(commandArgs(name, values), extraArgs...)
+
+	release, err := acquireCommandSlot(ctx)
+	if err != nil {
+		return nil, &CommandError{Name: name, Args: args, ExitCode: -1, Err: err}
+	}
+	defer release()
+
+	workDir, err := ioutil.TempDir(viper.GetString("media.uploadTemp.path"), "cmd")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create command working dir %v", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	timeout := time.Duration(commandLimit(name, "timeoutSecs", defaultCommandTimeoutSecs)) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stdout := &cappedBuffer{max: commandLimit(name, "maxOutputBytes", defaultCommandMaxOutputBytes), exceed: cancel}
+	stderr := &cappedBuffer{max: commandLimit(name, "maxStderrBytes", defaultCommandMaxStderrBytes)}
+	cmd := exec.Command(command, args...)
+	cmd.Dir = workDir
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + workDir}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	setProcessGroup(cmd)
+
+	// children like imagemagick delegates are killed too, or they keep the output pipes and Wait open
+	glog.V(2).Infof("running %s %v", name, cmd.Args)
+	err = ctx.Err()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				killProcessGroup(cmd)
+			case <-done:
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+
+		// a command that finished while ctx was done may not have been killed, its result is not wanted
+		if err == nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}
+	glog.V(2).Infof("%s stderr %s", name, stderr.buf.String())
+	if err != nil || stdout.exceeded {
+		cerr := &CommandError{
+			Name:           name,
+			Args:           args,
+			ExitCode:       -1,
+			TimedOut:       ctx.Err() == context.DeadlineExceeded,
+			OutputExceeded: stdout.exceeded,
+			Stderr:         stderr.buf.String(),
+			Err:            err,
+		}
+		if cerr.Err == nil {
+			cerr.Err = fmt.Errorf("output exceeds %d bytes", stdout.max)
+		}
+		if cmd.ProcessState != nil {
+			cerr.ExitCode = cmd.ProcessState.ExitCode()
+		}
+		return stdout.buf.Bytes(), cerr
+	}
+	return stdout.buf.Bytes(), nil
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCommand(t *testing.T) {
+
+	os.MkdirAll("/var/tmp/mediatmp", 0755)
+	setTestCommand(t, "testEcho", "echo", []string{"hello", "name"})
+	setTestCommand(t, "testSh", "sh", []string{"-c", "script"})
+
+	out, err := RunCommandContext(context.Background(), "testEcho", map[string]string{"name": "world"}, "again")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world again\n", string(out))
+
+	// values that could be read as options are refused
+	_, err = RunCommandContext(context.Background(), "testEcho", map[string]string{"name": "-n"})
+	assert.NotNil(t, err)
+	_, err = RunCommandContext(context.Background(), "testEcho", nil, "--version")
+	assert.NotNil(t, err)
+	_, err = RunCommandContext(context.Background(), "notConfigured", nil)
+	assert.NotNil(t, err)
+
+	// runs in an empty working dir without the server environment
+	out, err = RunCommandContext(context.Background(), "testSh", map[string]string{"script": "ls -A; echo $HOME; echo ${WOS_SECRET:-none}"})
+	assert.Nil(t, err)
+	assert.Regexp(t, "^/.+/cmd[0-9]+\nnone\n$", string(out))
+
+	// failures carry exit code and stderr
+	_, err = RunCommandContext(context.Background(), "testSh", map[string]string{"script": "echo broken >&2; exit 3"})
+	cerr, ok := err.(*CommandError)
+	if assert.True(t, ok) {
+		assert.Equal(t, 3, cerr.ExitCode)
+		assert.Equal(t, "broken\n", cerr.Stderr)
+		assert.Contains(t, cerr.Error(), "stderr: broken")
+	}
+
+	// timeouts
+	viper.Set("commands.exec.testSh.timeoutSecs", 1)
+	start := time.Now()
+	_, err = RunCommandContext(context.Background(), "testSh", map[string]string{"script": "sleep 10"})
+	cerr, ok = err.(*CommandError)
+	if assert.True(t, ok) {
+		assert.True(t, cerr.TimedOut)
+	}
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	_, err = RunCommandContext(ctx, "testSh", map[string]string{"script": "sleep 10"})
+	assert.NotNil(t, err)
+
+	// already canceled, fast commands don't run or succeed either
+	for i := 0; i < 20; i++ {
+		_, err = RunCommandContext(ctx, "testEcho", map[string]string{"name": "world"})
+		assert.NotNil(t, err)
+	}
+
+	// output cap stops the command
+	viper.Set("commands.exec.testSh.maxOutputBytes", 1000)
+	out, err = RunCommandContext(context.Background(), "testSh", map[string]string{"script": "yes"})
+	cerr, ok = err.(*CommandError)
+	if assert.True(t, ok) {
+		assert.True(t, cerr.OutputExceeded)
+	}
+	assert.Equal(t, 1000, len(out))
+	viper.Set("commands.exec.testSh.timeoutSecs", 0)
+	viper.Set("commands.exec.testSh.maxOutputBytes", 0)
+}
+
+func TestRunCommandConcurrency(t *testing.T) {
+
+	os.MkdirAll("/var/tmp/mediatmp", 0755)
+	setTestCommand(t, "testSleep", "sleep", []string{"0.2"})
+	viper.Set("commands.limits.maxConcurrent", 2)
+	defer viper.Set("commands.limits.maxConcurrent", 0)
+
+	var running, most int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := acquireCommandSlot(context.Background())
+			assert.Nil(t, err)
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&most)
+				if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			release()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), most)
+
+	_, err := RunCommandContext(context.Background(), "testSleep", nil)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,16 @@
+package utils
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup does nothing, children are not tracked on windows
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// killProcessGroup kills cmd
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
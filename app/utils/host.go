@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"net/url"
+
+	"github.com/spf13/viper"
+)
+
+// HostURL returns the public URL of this server for path p, using the host.mode host settings
+func HostURL(p string) *url.URL {
+
+	hostKey := "host.hosts." + viper.GetString("host.mode")
+	host := viper.GetString(hostKey + ".domain")
+	if !(viper.GetInt(hostKey+".port") == 80 || viper.GetInt(hostKey+".port") == 443) {
+		host += ":" + viper.GetString(hostKey+".port")
+	}
+	return &url.URL{
+		Scheme: viper.GetString(hostKey + ".scheme"),
+		Host:   host,
+		Path:   p,
+	}
+}
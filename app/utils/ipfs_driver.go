@@ -84,6 +84,7 @@ func (i *IPFS_Driver) UploadString(body string, relPath string) (cid string, err
 	if err != nil {
 		return "", err
 	}
+	defer os.RemoveAll(tempDirPath)
 
 	prefixedPathPlusRelPath := path.Join(tempDirPath, filepath.Dir(relPath))
 	err = os.MkdirAll(prefixedPathPlusRelPath, 0755)
@@ -93,7 +94,7 @@ func (i *IPFS_Driver) UploadString(body string, relPath string) (cid string, err
 		return "", err
 	}
 
-	err = os.WriteFile(prefixedPathPlusRelPath, []byte(body), 0644)
+	err = os.WriteFile(path.Join(tempDirPath, relPath), []byte(body), 0644)
 	if err != nil {
 		return "", err
 	}
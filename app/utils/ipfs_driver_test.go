@@ -47,4 +47,15 @@ func TestIpfs(t *testing.T) {
 	assert.True(t, info.IsDir())
 	info, _ = os.Stat("/var/tmp/mediatmp/b/media/NewportAV.jpg")
 	assert.Equal(t, "NewportAV.jpg", info.Name())
+
+	// upload string
+	cid, err = ipfs.UploadString(`{"name": "a"}`, "metadata.json")
+	assert.Nil(t, err)
+	os.RemoveAll("/var/tmp/mediatmp")
+	os.MkdirAll("/var/tmp/mediatmp/c", 0755)
+	err = ipfs.DownloadDirectory("/var/tmp/mediatmp/c", cid)
+	assert.Nil(t, err)
+	b, err := os.ReadFile("/var/tmp/mediatmp/c/metadata.json")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"name": "a"}`, string(b))
 }
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+)
+
+var LocalSimple LocalSimpleDriver
+
+// LocalSimpleDriver stores object files on local disk, served by the media static route with signed URLs
+type LocalSimpleDriver struct {
+	localPath string
+}
+
+// Init initializes local storage
+func (l *LocalSimpleDriver) Init() error {
+	l.localPath = viper.GetString("media.schemes.localSimple.localPath")
+	if l.localPath == "" {
+		return fmt.Errorf("media.schemes.localSimple.localPath not set")
+	}
+	return os.MkdirAll(l.localPath, 0777)
+}
+
+// UploadDirectory copies all the files in a folder to the cid folder
+func (l *LocalSimpleDriver) UploadDirectory(localDirPath string, cid string) error {
+
+	if l.localPath == "" {
+		return fmt.Errorf("LocalSimpleDriver not initialized")
+	}
+
+	return filepath.Walk(localDirPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relativePath := p[len(localDirPath):]
+		dest := path.Join(l.localPath, cid, relativePath)
+		err = os.MkdirAll(path.Dir(dest), 0755)
+		if err != nil {
+			return fmt.Errorf("cannot create local media folder %s %v", dest, err)
+		}
+		_, err = CopyFile(p, dest)
+		if err != nil {
+			return fmt.Errorf("cannot copy local media %s %v", dest, err)
+		}
+		return nil
+	})
+}
+
+// ListFiles returns the paths of all files stored for cid, relative to the cid folder
+func (l *LocalSimpleDriver) ListFiles(cid string) ([]string, error) {
+
+	if l.localPath == "" {
+		return nil, fmt.Errorf("LocalSimpleDriver not initialized")
+	}
+
+	root := path.Join(l.localPath, cid)
+	files := []string{}
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, strings.TrimPrefix(p[len(root):], "/"))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list local media %s %v", cid, err)
+	}
+	return files, nil
+}
+
+// Upload copies a file to key, which is cid/path
+func (l *LocalSimpleDriver) Upload(localPath string, key string) error {
+
+	if l.localPath == "" {
+		return fmt.Errorf("LocalSimpleDriver not initialized")
+	}
+
+	dest := path.Join(l.localPath, key)
+	err := os.MkdirAll(path.Dir(dest), 0755)
+	if err != nil {
+		return fmt.Errorf("cannot create local media folder %s %v", dest, err)
+	}
+
+	// copy then rename so readers never see a partial file
+	temp, err := ioutil.TempFile(path.Dir(dest), ".upload")
+	if err != nil {
+		return fmt.Errorf("cannot create local media temp file %s %v", dest, err)
+	}
+	temp.Close()
+	_, err = CopyFile(localPath, temp.Name())
+	if err == nil {
+		err = os.Rename(temp.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("cannot copy local media %s %v", dest, err)
+	}
+	return nil
+}
+
+// Download copies the file at key to localPath
+func (l *LocalSimpleDriver) Download(localPath string, key string) error {
+
+	if l.localPath == "" {
+		return fmt.Errorf("LocalSimpleDriver not initialized")
+	}
+
+	_, err := CopyFile(path.Join(l.localPath, key), localPath)
+	if err != nil {
+		return fmt.Errorf("cannot copy local media %s %v", key, err)
+	}
+	return nil
+}
+
+// UrlExpiry returns how long URLs from GetExpiringURL are valid
+func (l *LocalSimpleDriver) UrlExpiry() time.Duration {
+	return time.Duration(viper.GetInt("media.schemes.localSimple.expireSecs")) * time.Second
+}
+
+// GetExpiringURL returns a signed URL to the media static route for key, which is cid/path
+func (l *LocalSimpleDriver) GetExpiringURL(key string) (string, http.Header, error) {
+
+	if viper.GetString("media.schemes.localSimple.signingSecret") == "" {
+		return "", nil, fmt.Errorf("media.schemes.localSimple.signingSecret not set")
+	}
+
+	exp := strconv.FormatInt(time.Now().Add(l.UrlExpiry()).Unix(), 10)
+	u := HostURL(path.Join("/", viper.GetString("media.webMediaPath"), viper.GetString("media.schemes.localSimple.id"), key))
+	q := u.Query()
+	q.Set("exp", exp)
+	q.Set("sig", signLocalMedia(key, exp))
+	u.RawQuery = q.Encode()
+
+	glog.V(2).Infof("created URL to local media %s", u.String())
+
+	return u.String(), nil, nil
+}
+
+// signLocalMedia returns base64 HMAC of key and expiry time
+func signLocalMedia(key string, exp string) string {
+	h := hmac.New(sha256.New, []byte(viper.GetString("media.schemes.localSimple.signingSecret")))
+	h.Write([]byte(key + "\n" + exp))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// VerifyLocalMediaSignature returns true if sig is the signature of key and exp, and exp is after now
+func VerifyLocalMediaSignature(key string, exp string, sig string, now time.Time) bool {
+
+	if viper.GetString("media.schemes.localSimple.signingSecret") == "" {
+		return false
+	}
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.Unix() > expUnix {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(signLocalMedia(key, exp)))
+}
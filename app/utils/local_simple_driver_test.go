@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalSimple(t *testing.T) {
+
+	os.RemoveAll("/var/tmp/mediatmp")
+	os.MkdirAll("/var/tmp/mediatmp", 0755)
+	viper.Set("media.schemes.localSimple.localPath", "/var/tmp/mediatmp/local")
+	viper.Set("media.schemes.localSimple.signingSecret", "testing123")
+
+	local := LocalSimpleDriver{}
+	err := local.Init()
+	assert.Nil(t, err)
+
+	// upload directory and list it
+	err = local.UploadDirectory("../test/object_folder", "testcid")
+	assert.Nil(t, err)
+	files, err := local.ListFiles("testcid")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"index.json", "media/hello.mp3"}, files)
+
+	// signed URL verifies, tampered or expired does not
+	u, h, err := local.GetExpiringURL("testcid/media/hello.mp3")
+	assert.Nil(t, err)
+	assert.Nil(t, h)
+	parsed, err := url.Parse(u)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(parsed.Path, "/testcid/media/hello.mp3"))
+	exp := parsed.Query().Get("exp")
+	sig := parsed.Query().Get("sig")
+	assert.True(t, VerifyLocalMediaSignature("testcid/media/hello.mp3", exp, sig, time.Now()))
+	assert.False(t, VerifyLocalMediaSignature("testcid/index.json", exp, sig, time.Now()))
+	assert.False(t, VerifyLocalMediaSignature("testcid/media/hello.mp3", exp, sig, time.Now().Add(2*local.UrlExpiry())))
+	assert.False(t, VerifyLocalMediaSignature("testcid/media/hello.mp3", exp, "", time.Now()))
+
+	// single files round trip, missing keys fail
+	err = local.Upload("../test/object_folder/index.json", "variants/testcid/index_w64h0fit.json")
+	assert.Nil(t, err)
+	err = local.Download("/var/tmp/mediatmp/downloaded.json", "variants/testcid/index_w64h0fit.json")
+	assert.Nil(t, err)
+	b, err := ioutil.ReadFile("/var/tmp/mediatmp/downloaded.json")
+	assert.Nil(t, err)
+	original, _ := ioutil.ReadFile("../test/object_folder/index.json")
+	assert.Equal(t, original, b)
+	err = local.Download("/var/tmp/mediatmp/missing.json", "variants/testcid/missing.json")
+	assert.NotNil(t, err)
+}
+
+func TestSplitThumbnailName(t *testing.T) {
+	original, format := SplitThumbnailName("media/a_p100.jpg")
+	assert.Equal(t, "media/a.jpg", original)
+	assert.Equal(t, "p100", format)
+
+	original, format = SplitThumbnailName("media.v2/a_p1080")
+	assert.Equal(t, "media.v2/a", original)
+	assert.Equal(t, "p1080", format)
+
+	original, format = SplitThumbnailName("media/a.jpg")
+	assert.Equal(t, "media/a.jpg", original)
+	assert.Equal(t, "", format)
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+)
+
+const (
+	AudioWaveformSuffix = "waveform" // basename suffix of waveform peaks, a.m4a => a_waveform.json
+	AudioLoudnessPrefix = "lufs"     // loudness variant name prefix, a.mp3 at -16 LUFS => a_lufs16.m4a
+)
+
+// Waveform is downsampled peaks of an audio file for drawing a scrubber
+type Waveform struct {
+	Duration float64   `json:"duration"` // seconds
+	Peaks    []float64 `json:"peaks"`    // max absolute amplitude in each equal time bucket, 0 to 1
+}
+
+// AudioDerivatives are the files generated from an audio file, paths are next to the audio file
+type AudioDerivatives struct {
+	Waveform string
+	Preview  string
+	Variants map[string]string // variant name, lufs16, to path
+}
+
+// audioDerivativeSuffix is the suffix of generated aac audio, mp4 when media.convertM4a2Mp4 is set
+func audioDerivativeSuffix() string {
+	if viper.GetBool("media.convertM4a2Mp4") {
+		return "mp4"
+	}
+	return "m4a"
+}
+
+// CreateAudioDerivatives creates the waveform, preview clip and loudness normalized variants of the audio at mediaPath.
+// A derivative that cannot be created is logged and left out.
+func CreateAudioDerivatives(ctx context.Context, mediaPath string) AudioDerivatives {
+
+	d := AudioDerivatives{Variants: map[string]string{}}
+
+	waveformPath := ChangeSuffix(AddSuffixToBasename(mediaPath, AudioWaveformSuffix), "json")
+	err := CreateAudioWaveform(ctx, mediaPath, waveformPath, viper.GetInt("media.audio.waveformPeaks"))
+	if err != nil {
+		glog.Warningf("Could not create waveform for audio file %s %v", mediaPath, err)
+	} else {
+		d.Waveform = waveformPath
+	}
+
+	previewPath := ChangeSuffix(AddSuffixToBasename(mediaPath, PreviewSuffix), audioDerivativeSuffix())
+	_, err = RunCommandContext(ctx, "audioPreview", map[string]string{
+		"audiofile":   mediaPath,
+		"previewfile": previewPath,
+	})
+	if err != nil {
+		os.Remove(previewPath)
+		glog.Warningf("Could not create preview for audio file %s %v", mediaPath, err)
+	} else {
+		d.Preview = previewPath
+	}
+
+	for _, target := range viper.GetIntSlice("media.audio.loudnessTargets") {
+		name := AudioLoudnessPrefix + strconv.Itoa(int(math.Abs(float64(target))))
+		variantPath := ChangeSuffix(AddSuffixToBasename(mediaPath, name), audioDerivativeSuffix())
+		_, err = RunCommandContext(ctx, "audioNormalize", map[string]string{
+			"audiofile":      mediaPath,
+			"normalizedfile": variantPath,
+			"loudnorm":       fmt.Sprintf("loudnorm=I=%d:TP=-1.5:LRA=11", target),
+		})
+		if err != nil {
+			os.Remove(variantPath)
+			glog.Warningf("Could not create %s variant for audio file %s %v", name, mediaPath, err)
+			continue
+		}
+		d.Variants[name] = variantPath
+	}
+
+	return d
+}
+
+// CreateAudioWaveform decodes the audio at mediaPath to mono PCM with ffmpeg and writes its Waveform json to waveformPath
+func CreateAudioWaveform(ctx context.Context, mediaPath string, waveformPath string, buckets int) error {
+
+	tempDir, err := ioutil.TempDir(viper.GetString("media.uploadTemp.path"), "pcm")
+	if err != nil {
+		return fmt.Errorf("cannot create pcm temp dir %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	sampleRate := viper.GetInt("media.audio.waveformSampleRate")
+	pcmPath := path.Join(tempDir, "audio.pcm")
+	_, err = RunCommandContext(ctx, "audioPcm", map[string]string{
+		"audiofile":  mediaPath,
+		"pcmfile":    pcmPath,
+		"samplerate": strconv.Itoa(sampleRate),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot decode audio %s %v", mediaPath, err)
+	}
+
+	f, err := os.Open(pcmPath)
+	if err != nil {
+		return fmt.Errorf("cannot open pcm %s %v", pcmPath, err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("cannot stat pcm %s %v", pcmPath, err)
+	}
+
+	samples := int(st.Size() / 2)
+	peaks, err := WaveformPeaks(bufio.NewReader(f), samples, buckets)
+	if err != nil {
+		return fmt.Errorf("cannot read pcm %s %v", pcmPath, err)
+	}
+	w := Waveform{Peaks: peaks}
+	if sampleRate > 0 {
+		w.Duration = float64(samples) / float64(sampleRate)
+	}
+
+	b, err := json.Marshal(w)
+	if err != nil {
+		return fmt.Errorf("cannot marshall waveform %v", err)
+	}
+	return ioutil.WriteFile(waveformPath, b, 0644)
+}
+
+// WaveformPeaks reads samples of signed 16 bit little endian mono PCM from r and returns the peak of each of buckets
+// equal spans, rounded to 3 decimals.  There are fewer buckets than asked for when there are fewer samples.
+func WaveformPeaks(r io.Reader, samples int, buckets int) ([]float64, error) {
+
+	if buckets <= 0 || samples <= 0 {
+		return []float64{}, nil
+	}
+	if buckets > samples {
+		buckets = samples
+	}
+
+	peaks := make([]float64, buckets)
+	sample := make([]byte, 2)
+	for i := 0; i < samples; i++ {
+		_, err := io.ReadFull(r, sample)
+		if err != nil {
+			return nil, err
+		}
+		b := int(int64(i) * int64(buckets) / int64(samples))
+		v := math.Abs(float64(int16(binary.LittleEndian.Uint16(sample)))) / 32768
+		if v > peaks[b] {
+			peaks[b] = v
+		}
+	}
+	for i, p := range peaks {
+		peaks[i] = math.Round(p*1000) / 1000
+	}
+	return peaks, nil
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaveformPeaks(t *testing.T) {
+
+	var pcm bytes.Buffer
+	binary.Write(&pcm, binary.LittleEndian, []int16{0, 100, -16384, 50, 32767, -32768, 0, 0})
+
+	peaks, err := WaveformPeaks(bytes.NewReader(pcm.Bytes()), 8, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{0.003, 0.5, 1, 0}, peaks)
+
+	// fewer samples than buckets
+	peaks, err = WaveformPeaks(bytes.NewReader(pcm.Bytes()), 2, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(peaks))
+
+	// short read
+	_, err = WaveformPeaks(bytes.NewReader(pcm.Bytes()), 9, 4)
+	assert.NotNil(t, err)
+
+	peaks, err = WaveformPeaks(bytes.NewReader(nil), 0, 4)
+	assert.Nil(t, err)
+	assert.Empty(t, peaks)
+}
+
+func TestAudioDerivativeSuffix(t *testing.T) {
+
+	viper.Set("media.convertM4a2Mp4", true)
+	assert.Equal(t, "mp4", audioDerivativeSuffix())
+	viper.Set("media.convertM4a2Mp4", false)
+	assert.Equal(t, "m4a", audioDerivativeSuffix())
+}
@@ -1,100 +1,143 @@
 package utils
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/golang/glog"
-
 	"github.com/spf13/viper"
 )
 
-// ConvertM4aMp4 converts an m4a file to mp4 in place by using temp folder to create new file then copies to original
-// ffmpeg -y -i input.m4a -c:a copy output.mp4
-func ConvertM4aMp4(inputPath string) (error) {
+// ConversionRule converts media of one format to another with a command of commands.exec
+type ConversionRule struct {
+	From    int    // media format detail converted
+	To      string // suffix of the conversion
+	Command string // commands.exec name
+	Input   string // args placeholder of the input file
+	Output  string // args placeholder of the output file
+	Enabled string // config flag turning the rule on
+}
 
-	var err error
+var conversionHeicJpg = ConversionRule{
+	From:    MediaFormatDetailImageHeic,
+	To:      "jpg",
+	Command: "heic2jpg",
+	Input:   "heicfile",
+	Output:  "jpgfile",
+	Enabled: "media.convertHeic2Jpg",
+}
 
-	_, filename := filepath.Split(inputPath)
+var conversionM4aMp4 = ConversionRule{
+	From:    MediaFormatDetailAudioMp4,
+	To:      "mp4",
+	Command: "m4a2mp4",
+	Input:   "m4afile",
+	Output:  "mp4file",
+	Enabled: "media.convertM4a2Mp4",
+}
 
-	// name new mp4 temp file by adding mp4 suffix
-	tempPath := path.Join(viper.GetString("media.uploadTemp.path"), filename + ".mp4")
+// ConversionRules are the conversions of the ingest conversion stage
+var ConversionRules = []ConversionRule{conversionHeicJpg, conversionM4aMp4}
 
-	// convert and save in temp
-	s := viper.GetStringSlice("commands.exec.m4a2mp4.args")
-	for i, arg := range s {
-		if arg == "m4afile" {
-			s[i] = inputPath
-		} else if arg == "mp4file" {
-			s[i] = tempPath
+// FindConversionRule returns the enabled rule converting media of mediaFormatDetail with suffix.  Files that already
+// have the suffix of the conversion are not converted, like mp4 audio.
+func FindConversionRule(mediaFormatDetail int, suffix string) (ConversionRule, bool) {
+	for _, r := range ConversionRules {
+		if r.From == mediaFormatDetail && !strings.EqualFold(suffix, r.To) && viper.GetBool(r.Enabled) {
+			return r, true
 		}
 	}
-	cmd := exec.Command(viper.GetString("commands.exec.m4a2mp4.command"), s...)
-	glog.V(2).Infof("running ffmpeg %v", cmd.Args)
-	out, err := cmd.CombinedOutput()
+	return ConversionRule{}, false
+}
+
+// Convert converts the file at inputPath to outputPath, which may be the same.  The command writes into its own temp
+// folder so concurrent conversions don't collide, and a failed conversion leaves no partial output.
+func (r ConversionRule) Convert(ctx context.Context, inputPath string, outputPath string) error {
+
+	tempDir, err := ioutil.TempDir(viper.GetString("media.uploadTemp.path"), "convert")
 	if err != nil {
-		err = fmt.Errorf("cannot run ffmpeg convert %s %v", inputPath, err)
-		goto done
+		return fmt.Errorf("cannot create convert temp dir %v", err)
 	}
-	glog.V(2).Infof("ffmpeg output %s", string(out))
+	defer os.RemoveAll(tempDir)
 
-	// copy back to original and delete temp
-	_, err = CopyFile(tempPath, inputPath)
+	tempPath := path.Join(tempDir, "converted."+r.To)
+	_, err = RunCommandContext(ctx, r.Command, map[string]string{
+		r.Input:  inputPath,
+		r.Output: tempPath,
+	})
 	if err != nil {
-		err = fmt.Errorf("cannot copy converted mp4 to original %s %v", inputPath, err)
+		return fmt.Errorf("cannot convert %s to %s %v", inputPath, r.To, err)
 	}
 
-	done:
-	err = os.Remove(tempPath) 
+	_, err = CopyFile(tempPath, outputPath)
 	if err != nil {
-		err = fmt.Errorf("cannot delete temp converted mp4 %s %v", tempPath, err)
+		if outputPath != inputPath {
+			os.Remove(outputPath)
+		}
+		return fmt.Errorf("cannot copy converted %s to %s %v", r.To, outputPath, err)
 	}
-	return err
+	return nil
 }
 
+// ConvertMediaInFolder runs the conversion rules on all files in mediaPath.  Originals are kept next to their
+// conversions, a.heic => a.heic and a.jpg, and existing files are not overwritten.  Failed conversions are logged and
+// skipped.  Returns paths of converted originals mapped to their conversions by suffix.
+// Ingest runs it after the IPFS upload, so like thumbnails conversions are only in the media cache, and the cid
+// addresses exactly what was uploaded.
+func ConvertMediaInFolder(ctx context.Context, mediaPath string) map[string]map[string]string {
+
+	// collect first, conversions are not converted again
+	var files []string
+	filepath.Walk(mediaPath, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, p)
+		}
+		return nil
+	})
+
+	conversions := map[string]map[string]string{}
+	for _, p := range files {
+		_, mediaFormatDetail, err := SniffMediaFormat(p)
+		if err != nil {
+			continue
+		}
+		rule, ok := FindConversionRule(mediaFormatDetail, GetMediaSuffix(p))
+		if !ok {
+			continue
+		}
 
-// ConvertHeicJpg converts an heic file to jpeg in place by using temp folder to create new file then copies to original
-// convert -format jpg input.heic output.jpg
-func ConvertHeicJpg(inputPath string) (error) {
-
-	var err error
-
-	_, filename := filepath.Split(inputPath)
-
-	// name new jpg temp file by adding jpg suffix
-	tempPath := path.Join(viper.GetString("media.uploadTemp.path"), filename + ".jpg")
+		outputPath := p + "." + rule.To
+		if GetMediaSuffix(p) != "" {
+			outputPath = ChangeSuffix(p, rule.To)
+		}
+		if _, err := os.Stat(outputPath); err == nil {
+			glog.Warningf("not converting %s, %s exists", p, outputPath)
+			continue
+		}
 
-	// convert and save in temp
-	s := viper.GetStringSlice("commands.exec.heic2jpg.args")
-	for i, arg := range s {
-		if arg == "heicfile" {
-			s[i] = inputPath
-		} else if arg == "jpgfile" {
-			s[i] = tempPath
+		err = rule.Convert(ctx, p, outputPath)
+		if err != nil {
+			glog.Warningf("Could not convert media file %s %v", p, err)
+			continue
 		}
+		conversions[p] = map[string]string{rule.To: outputPath}
 	}
-	cmd := exec.Command(viper.GetString("commands.exec.heic2jpg.command"), s...)
-	glog.V(2).Infof("running convert %v", cmd.Args)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		err = fmt.Errorf("cannot run convert %s %v", inputPath, err)
-		goto done
-	}
-	glog.V(2).Infof("convert output %s", string(out))
+	return conversions
+}
 
-	// copy back to original and delete temp
-	_, err = CopyFile(tempPath, inputPath)
-	if err != nil {
-		err = fmt.Errorf("cannot copy converted jpg to original %s %v", inputPath, err)
-	}
+// ConvertM4aMp4 converts an m4a file to mp4 in place
+// ffmpeg -y -i input.m4a -c:a copy output.mp4
+func ConvertM4aMp4(ctx context.Context, inputPath string) error {
+	return conversionM4aMp4.Convert(ctx, inputPath, inputPath)
+}
 
-	done:
-	err = os.Remove(tempPath) 
-	if err != nil {
-		err = fmt.Errorf("cannot delete temp converted jpg %s %v", tempPath, err)
-	}
-	return err
+// ConvertHeicJpg converts an heic file to jpeg in place
+// convert -format jpg input.heic output.jpg
+func ConvertHeicJpg(ctx context.Context, inputPath string) error {
+	return conversionHeicJpg.Convert(ctx, inputPath, inputPath)
 }
@@ -1,12 +1,13 @@
 package utils
 
 import (
-
+	"context"
 	"os"
+	"path/filepath"
 	"testing"
 
-	"github.com/spf13/viper"
 	"github.com/golang/glog"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,10 +24,73 @@ func TestMediaConvert(t *testing.T) {
 	os.MkdirAll("/var/tmp/mediatmp/arc", 0755)
 
 	CopyFile("../test/test.m4a", "/var/tmp/test.m4a")
-	err := ConvertM4aMp4("/var/tmp/test.m4a")
+	err := ConvertM4aMp4(context.Background(), "/var/tmp/test.m4a")
 	assert.Nil(t, err)
 
 	CopyFile("../test/test.heic", "/var/tmp/test.heic")
-	err = ConvertHeicJpg("/var/tmp/test.heic")
+	err = ConvertHeicJpg(context.Background(), "/var/tmp/test.heic")
 	assert.Nil(t, err)
 }
+
+// setTestCommand replaces command name with command and args until the test ends
+func setTestCommand(t *testing.T, name string, command string, args []string) {
+	oldCommand := viper.GetString("commands.exec." + name + ".command")
+	oldArgs := viper.GetStringSlice("commands.exec." + name + ".args")
+	viper.Set("commands.exec."+name+".command", command)
+	viper.Set("commands.exec."+name+".args", args)
+	t.Cleanup(func() {
+		viper.Set("commands.exec."+name+".command", oldCommand)
+		viper.Set("commands.exec."+name+".args", oldArgs)
+	})
+}
+
+func TestConvertMediaInFolder(t *testing.T) {
+
+	os.RemoveAll("/var/tmp/mediatmp")
+	os.MkdirAll("/var/tmp/mediatmp/obj/media", 0755)
+	CopyFile("../test/test.heic", "/var/tmp/mediatmp/obj/media/a.heic")
+	CopyFile("../test/test.heic", "/var/tmp/mediatmp/obj/media/b.heic")
+	CopyFile("../test/image.jpg", "/var/tmp/mediatmp/obj/media/b.jpg")
+	CopyFile("../test/hello.mp3", "/var/tmp/mediatmp/obj/media/hello.mp3")
+
+	// stands in for imagemagick, placeholders are replaced on every call.  Commands run in their own working dir.
+	jpg, _ := filepath.Abs("../test/image.jpg")
+	setTestCommand(t, "heic2jpg", "cp", []string{jpg, "jpgfile"})
+	viper.Set("media.convertHeic2Jpg", true)
+
+	conversions := ConvertMediaInFolder(context.Background(), "/var/tmp/mediatmp/obj")
+	assert.Equal(t, map[string]map[string]string{
+		"/var/tmp/mediatmp/obj/media/a.heic": {"jpg": "/var/tmp/mediatmp/obj/media/a.jpg"},
+	}, conversions)
+	_, err := os.Stat("/var/tmp/mediatmp/obj/media/a.heic")
+	assert.Nil(t, err, "original kept")
+	assert.Equal(t, []string{jpg, "jpgfile"}, viper.GetStringSlice("commands.exec.heic2jpg.args"))
+
+	// the manifest records conversions both ways, thumbnails come from the conversion
+	os.Remove("/var/tmp/mediatmp/obj/media/a.jpg")
+	manifest, err := CreateThumbnailsInFolder(context.Background(), "/var/tmp/mediatmp/obj", "testcid")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"jpg": "media/a.jpg"}, manifest["media/a.heic"].Conversions)
+	assert.Empty(t, manifest["media/a.heic"].Thumbnails)
+	assert.Equal(t, "media/a.heic", manifest["media/a.jpg"].ConvertedFrom)
+	assert.Equal(t, "media/a_p100.jpg", manifest["media/a.jpg"].Thumbnails[Thumb_p100])
+	assert.Empty(t, manifest["media/b.heic"].Conversions)
+
+	// failed conversions are reported and leave nothing behind
+	setTestCommand(t, "heic2jpg", "false", []string{"heicfile", "jpgfile"})
+	err = conversionHeicJpg.Convert(context.Background(), "/var/tmp/mediatmp/obj/media/a.heic", "/var/tmp/mediatmp/obj/media/c.jpg")
+	assert.NotNil(t, err)
+	_, err = os.Stat("/var/tmp/mediatmp/obj/media/c.jpg")
+	assert.True(t, os.IsNotExist(err))
+
+	// disabled rules don't convert
+	viper.Set("media.convertHeic2Jpg", false)
+	_, ok := FindConversionRule(MediaFormatDetailImageHeic, "heic")
+	assert.False(t, ok)
+	viper.Set("media.convertHeic2Jpg", true)
+	viper.Set("media.convertM4a2Mp4", true)
+	_, ok = FindConversionRule(MediaFormatDetailAudioMp4, "mp4")
+	assert.False(t, ok)
+	_, ok = FindConversionRule(MediaFormatDetailAudioMp4, "m4a")
+	assert.True(t, ok)
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"image"
@@ -8,7 +9,6 @@ import (
 	_ "image/jpeg"
 	_ "image/png"
 	"os"
-	"os/exec"
 	"path"
 	"strconv"
 	"strings"
@@ -19,14 +19,9 @@ import (
 	"github.com/golang/glog"
 	"github.com/rwcarlsen/goexif/exif"
 	"github.com/rwcarlsen/goexif/tiff"
-	"github.com/spf13/viper"
+	_ "golang.org/x/image/webp"
 )
 
-type ThumbnailSizeSuffix struct {
-	MaxSize int
-	Suffix  string
-}
-
 const (
 	MediaFormatBase = 1000 // base used for comparisons
 
@@ -34,24 +29,32 @@ const (
 	MediaFormatDetailImageJpeg = 1001 // jpeg
 	MediaFormatDetailImagePng  = 1002 // png
 	MediaFormatDetailImageHeic = 1003 // heic
+	MediaFormatDetailImageGif  = 1004 // gif
+	MediaFormatDetailImageWebp = 1005 // webp
 
 	MediaFormatAudio          = 2000 // audio base code
 	MediaFormatDetailAudioMp3 = 2001 // mp3
 	MediaFormatDetailAudioMp4 = 2002 // audio only mp4
 	MediaFormatDetailAudioAac = 2003 // audio only aac
 	MediaFormatDetailAudioOgg = 2004 // audio only ogg
+	MediaFormatDetailAudioWav = 2005 // wav
 
 	MediaFormatVideo          = 3000 // video base code
 	MediaFormatDetailVideoMp4 = 3001 // mp4
 	MediaFormatDetailVideoMov = 3002 // mov
 
+	MediaFormatModel           = 4000 // 3d model base code
+	MediaFormatDetailModelGlb  = 4001 // binary gltf
+	MediaFormatDetailModelGltf = 4002 // gltf json
+	MediaFormatDetailModelUsdz = 4003 // usdz
+	MediaFormatDetailModelObj  = 4004 // wavefront obj
+
 	Thumb_p100  = "p100"
 	Thumb_p1080 = "p1080"
 	Size_Actual = "actual"
 )
 
 var mapMediaFormat map[string]int
-var mapThumbnailName2Size map[string]int
 
 const ThumbnailSuffixDelim = "_"
 
@@ -60,17 +63,22 @@ func init() {
 		"jpeg": MediaFormatDetailImageJpeg,
 		"jpg":  MediaFormatDetailImageJpeg,
 		"png":  MediaFormatDetailImagePng,
+		"gif":  MediaFormatDetailImageGif,
+		"webp": MediaFormatDetailImageWebp,
 		"mp3":  MediaFormatDetailAudioMp3,
 		"m4a":  MediaFormatDetailAudioMp4,
+		"aac":  MediaFormatDetailAudioAac,
+		"ogg":  MediaFormatDetailAudioOgg,
+		"oga":  MediaFormatDetailAudioOgg,
+		"wav":  MediaFormatDetailAudioWav,
 		"mp4":  MediaFormatDetailVideoMp4,
 		"mov":  MediaFormatDetailVideoMov,
-		"MOV":  MediaFormatDetailVideoMov,
 		"heic": MediaFormatDetailImageHeic,
-	}
-
-	mapThumbnailName2Size = map[string]int{
-		Thumb_p100:  100,
-		Thumb_p1080: 1080,
+		"heif": MediaFormatDetailImageHeic,
+		"glb":  MediaFormatDetailModelGlb,
+		"gltf": MediaFormatDetailModelGltf,
+		"usdz": MediaFormatDetailModelUsdz,
+		"obj":  MediaFormatDetailModelObj,
 	}
 }
 
@@ -84,19 +92,27 @@ func GetMediaSuffix(path string) (suffix string) {
 	return ""
 }
 
-// GetMediaFormatAndMetadata returns media format (image, audio, video), detail (jpeg, png, etc.) and metadata map for a media file
-func GetMediaFormatAndMetadata(path string, suffix string) (mediaFormat int, mediaFormatDetail int, metadata map[string]string, err error) {
+// GetMediaFormatAndMetadata returns media format (image, audio, video), detail (jpeg, png, etc.) and metadata map for a media file.
+// The format is detected from the content, metadata "suffixMismatch" is set to the suffix when it names another format.
+func GetMediaFormatAndMetadata(ctx context.Context, path string, suffix string) (mediaFormat int, mediaFormatDetail int, metadata map[string]string, err error) {
 
-	mediaFormat, mediaFormatDetail, err = matchSuffixToMediaFormat("placeholder." + suffix)
+	mediaFormat, mediaFormatDetail, mismatch, err := DetectMediaFormat(path, suffix)
 	if err != nil {
 		return 0, 0, nil, err
 	}
+	if mismatch {
+		glog.Warningf("media file suffix %s does not match content format %d %s", suffix, mediaFormatDetail, path)
+	}
 	switch mediaFormat {
 	case MediaFormatImage:
 		metadata, err = getImageWidthHeight(path)
 	case MediaFormatAudio:
 		metadata, err = getMpegMetadata(path)
-		metadata2, err := getAudioMediaMetadata(path)
+		if err != nil && mediaFormatDetail != MediaFormatDetailAudioMp3 && mediaFormatDetail != MediaFormatDetailAudioMp4 {
+			// tags are optional in the other audio formats
+			metadata, err = make(map[string]string), nil
+		}
+		metadata2, err := getAudioMediaMetadata(ctx, path)
 		if err == nil {
 			for k, v := range metadata2 {
 				metadata[k] = v
@@ -104,10 +120,26 @@ func GetMediaFormatAndMetadata(path string, suffix string) (mediaFormat int, med
 		}
 	case MediaFormatVideo:
 		metadata, err = getMpegMetadata(path)
+		video, videoErr := getVideoMediaMetadata(ctx, path)
+		if videoErr == nil {
+			if err != nil {
+				metadata, err = make(map[string]string), nil
+			}
+			for k, v := range video {
+				if v != "" {
+					metadata[k] = v
+				}
+			}
+		}
+	default:
+		metadata = make(map[string]string)
 	}
 	if err != nil {
 		return 0, 0, map[string]string{}, err
 	}
+	if mismatch {
+		metadata["suffixMismatch"] = suffix
+	}
 	return mediaFormat, mediaFormatDetail, metadata, nil
 }
 
@@ -116,7 +148,7 @@ func matchSuffixToMediaFormat(path string) (mediaFormat int, mediaFormatDetail i
 
 	dot := strings.LastIndex(path, ".")
 	if dot >= 0 && len(path) > dot+1 {
-		suffix := path[dot+1:]
+		suffix := strings.ToLower(path[dot+1:])
 
 		mediaFormatDetail, ok := mapMediaFormat[suffix]
 		if !ok {
@@ -231,16 +263,9 @@ nomatch:
 }
 
 // getAudioMediaMetadata calls ffprobe to retrieve media metadata such as duration
-func getAudioMediaMetadata(path string) (map[string]string, error) {
-
-	//TODO: sanitize filename b/c of injection possibilities
+func getAudioMediaMetadata(ctx context.Context, path string) (map[string]string, error) {
 
-	s := viper.GetStringSlice("commands.exec.ffprobe.args")
-	s = append(s, path)
-	cmd := exec.Command(viper.GetString("commands.exec.ffprobe.command"), s...)
-	glog.V(2).Infof("running ffprobe %v", cmd.Args)
-	out, err := cmd.CombinedOutput()
-	glog.V(2).Infof("ffprobe output %s", string(out))
+	out, err := RunCommandContext(ctx, "ffprobe", nil, path)
 
 	meta := make(map[string]string)
 
@@ -291,96 +316,203 @@ func AddSuffixToBasename(fullpath string, suffix string) string {
 }
 
 // CreateAltSizes creates thumbnails of mediaPath, saves thumbnails, and returns paths and names of uploaded images.
-// Thumbnail size is set to max of width or height.  
+// Thumbnails are made for each configured thumbnail profile.
 // mediaPath is the path to the file, altName is an alternate name that gets thumbnail suffixes added to it
-func CreateAltSizes(mediaPath string, altName string) (imagePathsThumbs []string, altNameThumbs []string, err error) {
+func CreateAltSizes(ctx context.Context, mediaPath string, altName string) (imagePathsThumbs []string, altNameThumbs []string, err error) {
 
-	// get suffix and format
-	suffix := GetMediaSuffix(mediaPath)
-	if err != nil {
-		return []string{}, []string{}, fmt.Errorf("cannot get suffix %v %s", err, mediaPath)
-	}
-	mediaFormat, _, err := matchSuffixToMediaFormat("placeholder." + suffix)
+	// get format from content
+	mediaFormat, mediaFormatDetail, err := SniffMediaFormat(mediaPath)
 	if err != nil {
 		return []string{}, []string{}, fmt.Errorf("cannot get media format %v %s", err, mediaPath)
 	}
 
+	// models get rendered previews, videos get poster frames
+	if mediaFormatDetail == MediaFormatDetailModelGlb {
+		return createModelPreviews(ctx, mediaPath, altName)
+	}
+	if mediaFormat == MediaFormatVideo {
+		return createVideoPosters(ctx, mediaPath, altName)
+	}
+
 	// FUTURE: return if not images, we can later expand this to create audio clips
 	if mediaFormat != MediaFormatImage {
 		return []string{}, []string{}, nil
 	}
 
+	// orientation is baked into the pixels, thumbnails carry no EXIF
 	src, err := imaging.Open(mediaPath, imaging.AutoOrientation(true))
 	if err != nil {
 		return []string{}, []string{}, fmt.Errorf("cannot open file for resize " + mediaPath)
 	}
 
-	for name, size := range mapThumbnailName2Size {
+	return saveThumbnails(ctx, src, mediaPath, altName, "")
+}
+
+// createModelPreviews renders PNG previews of the GLB model at mediaPath, named like image thumbnails of the model with a
+// png suffix unless the profile sets a format, for example model.glb to model.glb_p100.png.  Keeping the model suffix
+// keeps previews apart from the thumbnails of model.png.
+func createModelPreviews(ctx context.Context, mediaPath string, altName string) (imagePathsThumbs []string, altNameThumbs []string, err error) {
 
-		maxWidth := 0
-		maxHeight := 0
-		if src.Bounds().Max.X > src.Bounds().Max.Y {
-			maxWidth = size
-		} else {
-			maxHeight = size
+	for _, p := range ThumbnailProfiles() {
+
+		preview, err := RenderGlbPreview(ctx, mediaPath, p.Size())
+		if err != nil {
+			for _, np := range imagePathsThumbs {
+				os.Remove(np)
+			}
+			return []string{}, []string{}, fmt.Errorf("cannot render model preview %s %v", mediaPath, err)
 		}
-		thumb := imaging.Resize(src, maxWidth, maxHeight, imaging.Lanczos)
-		imagePathThumb := AddSuffixToBasename(mediaPath, name)
-		err = imaging.Save(thumb, imagePathThumb)
+		imagePathThumb := modelPreviewPath(p, mediaPath)
+		err = p.Save(ctx, p.Resize(preview), imagePathThumb)
 		if err != nil {
-			// unwind saving thumbs and don't save partials
 			for _, np := range imagePathsThumbs {
 				os.Remove(np)
 			}
-			return []string{}, []string{}, fmt.Errorf("cannot save file when resizing " + imagePathThumb)
+			return []string{}, []string{}, fmt.Errorf("cannot save model preview " + imagePathThumb)
 		}
 
 		imagePathsThumbs = append(imagePathsThumbs, imagePathThumb)
-
-		altNameThumb := path.Join(AddSuffixToBasename(altName, name))
-		altNameThumbs = append(altNameThumbs, altNameThumb)
+		altNameThumbs = append(altNameThumbs, modelPreviewPath(p, altName))
 	}
 
 	return
 }
 
-// GetThumbnailName returns thumbnail name given image name and format.  Format could be p100, p1080, etc.
+// modelPreviewPath returns the preview name of the model name for profile p, model.glb => model.glb_p100.png
+func modelPreviewPath(p ThumbnailProfile, name string) string {
+	if name == "" {
+		return p.ThumbnailPath(name, "png")
+	}
+	return p.ThumbnailPath(name+".png", "png")
+}
+
+// GetThumbnailName returns thumbnail name given image name and format.  Format is a thumbnail profile name like p100,
+// p1080, etc, and the thumbnail gets the profile's suffix when it sets an output format.
 func GetThumbnailName(imageName string, format string) string {
 	if format == Size_Actual {
 		return imageName
-	} else {
-		return AddSuffixToBasename(imageName, format)
 	}
+	if p, ok := FindThumbnailProfile(format); ok {
+		return p.ThumbnailPath(imageName, "")
+	}
+	return AddSuffixToBasename(imageName, format)
+}
+
+// SplitThumbnailName returns the original name and thumbnail format of a thumbnail name, a_p100.jpg => a.jpg, p100,
+// and model.glb_p100.png => model.glb, p100 for model previews.  format is empty when name is not a thumbnail.
+func SplitThumbnailName(name string) (original string, format string) {
+
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for _, p := range ThumbnailProfiles() {
+		f := p.Name
+		if strings.HasSuffix(base, ThumbnailSuffixDelim+f) {
+			original = strings.TrimSuffix(base, ThumbnailSuffixDelim+f)
+			if mediaFormat, _, err := matchSuffixToMediaFormat(original); err == nil && mediaFormat == MediaFormatModel {
+				return original, f
+			}
+			return original + ext, f
+		}
+	}
+	return name, ""
 }
 
-// CreateThumbnailsInFolder creates thumbnails for all images in the folder.
+// CreateThumbnailsInFolder creates thumbnails for all images in the folder and returns the file manifest of the folder.
 // The thumbnails appear next to the images with _p1080 basename suffix, like this a.jpg => a_p1080.jpg
-func CreateThumbnailsInFolder(mediaPath string) error {
+// HEIC and m4a files are converted first when enabled, see ConversionRules.  It runs after the IPFS upload, so
+// thumbnails and conversions are only saved to the media cache.
+func CreateThumbnailsInFolder(ctx context.Context, mediaPath string, cid string) (MediaManifest, error) {
+
+	manifest := MediaManifest{}
+	created := map[string]bool{}
+
+	// conversions are object files of their own, with thumbnails, and know their originals
+	conversions := ConvertMediaInFolder(ctx, mediaPath)
+	convertedFrom := map[string]string{}
+	for original, converted := range conversions {
+		for _, cp := range converted {
+			convertedFrom[cp] = strings.TrimPrefix(original[len(mediaPath):], "/")
+		}
+	}
 
 	// iterate over media files and create thumbnails
 	err := filepath.Walk(mediaPath, func(p string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil
 		}
-		if info.IsDir() {
+		if info.IsDir() || created[p] {
 			return nil
 		}
 
-		// find all images and create thumbnails
+		relPath := strings.TrimPrefix(p[len(mediaPath):], "/")
+		fileInfo, err := NewMediaFileInfo(ctx, p, relPath, cid)
+		if err != nil {
+			return err
+		}
+		manifest[relPath] = fileInfo
+		fileInfo.ConvertedFrom = convertedFrom[p]
+		for suffix, cp := range conversions[p] {
+			if fileInfo.Conversions == nil {
+				fileInfo.Conversions = map[string]string{}
+			}
+			fileInfo.Conversions[suffix] = strings.TrimPrefix(cp[len(mediaPath):], "/")
+		}
+		if len(fileInfo.Conversions) > 0 {
+			// derivatives and thumbnails are made from the conversion
+			return nil
+		}
 
-		suffix := GetMediaSuffix(p)
+		// waveform, preview clip and loudness variants of audio
+		if fileInfo.MediaFormat == MediaFormatAudio {
+			rel := func(p string) string {
+				created[p] = true
+				return strings.TrimPrefix(p[len(mediaPath):], "/")
+			}
+			d := CreateAudioDerivatives(ctx, p)
+			if d.Waveform != "" {
+				fileInfo.Waveform = rel(d.Waveform)
+			}
+			if d.Preview != "" {
+				fileInfo.Preview = rel(d.Preview)
+			}
+			for name, vp := range d.Variants {
+				if fileInfo.Variants == nil {
+					fileInfo.Variants = map[string]string{}
+				}
+				fileInfo.Variants[name] = rel(vp)
+			}
+		}
 
-		mediaFormat, _, _, err := GetMediaFormatAndMetadata(p, suffix)
+		// short preview clips of videos
+		if fileInfo.MediaFormat == MediaFormatVideo {
+			previewPath, err := CreateVideoPreview(ctx, p)
+			if err != nil {
+				glog.Warningf("Could not create preview for video file %s %v", p, err)
+			} else {
+				created[previewPath] = true
+				fileInfo.Preview = strings.TrimPrefix(previewPath[len(mediaPath):], "/")
+			}
+		}
 
-		if err != nil {
-			glog.Warningf("Could not determine media file format %s %v", p, err)
-		} else if mediaFormat == MediaFormatImage {
-			_, _, err := CreateAltSizes(p, "")
-			glog.Warningf("Could not create thumbnails for media file %s %v", p, err)
+		// find all images, models and videos and create thumbnails
+		if fileInfo.MediaFormat == MediaFormatImage || fileInfo.MediaFormat == MediaFormatModel ||
+			fileInfo.MediaFormat == MediaFormatVideo {
+			thumbPaths, _, err := CreateAltSizes(ctx, p, "")
+			if err != nil {
+				glog.Warningf("Could not create thumbnails for media file %s %v", p, err)
+				return nil
+			}
+			fileInfo.Thumbnails = map[string]string{}
+			for _, tp := range thumbPaths {
+				created[tp] = true
+				thumbRelPath := strings.TrimPrefix(tp[len(mediaPath):], "/")
+				_, format := SplitThumbnailName(thumbRelPath)
+				fileInfo.Thumbnails[format] = thumbRelPath
+			}
 		}
 
 		return nil
 	})
 
-	return err
+	return manifest, err
 }
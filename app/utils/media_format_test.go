@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -8,13 +9,13 @@ import (
 )
 
 func TestMediaFormat(t *testing.T) {
-	format, formatDetail, metadata, err := GetMediaFormatAndMetadata("../test/hello.mp3", "mp3")
+	format, formatDetail, metadata, err := GetMediaFormatAndMetadata(context.Background(), "../test/hello.mp3", "mp3")
 	assert.Nil(t, err)
 	assert.Equal(t, 2000, format)
 	assert.Equal(t, 2001, formatDetail)
 	assert.Equal(t, "Test Title", metadata["title"])
 
-	format, formatDetail, metadata, err = GetMediaFormatAndMetadata("../test/image.jpg", "jpg")
+	format, formatDetail, metadata, err = GetMediaFormatAndMetadata(context.Background(), "../test/image.jpg", "jpg")
 	assert.Nil(t, err)
 	assert.Equal(t, 1000, format)
 	assert.Equal(t, 1001, formatDetail)
@@ -31,7 +32,7 @@ func TestChangeSuffix(t *testing.T) {
 func TestImageThumbs(t *testing.T) {
 	assert.Equal(t, "/adf/adf/mmm_1.jpg", AddSuffixToBasename("/adf/adf/mmm.jpg", "1"))
 	CopyFile("../test/3024x4032.jpg", "/var/tmp/image.jpg")
-	storedPaths, uploadedNames, err := CreateAltSizes("/var/tmp/image.jpg", "john.jpg")
+	storedPaths, uploadedNames, err := CreateAltSizes(context.Background(), "/var/tmp/image.jpg", "john.jpg")
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(storedPaths))
 	assert.Contains(t, storedPaths, "/var/tmp/image_p100.jpg")
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/golang/glog"
+)
+
+// MediaFileInfo describes one file of an object
+type MediaFileInfo struct {
+	Path              string            `json:"path"`
+	Size              int64             `json:"size"`
+	Mime              string            `json:"mime"`
+	MediaFormat       int               `json:"mediaFormat,omitempty"`
+	MediaFormatDetail int               `json:"mediaFormatDetail,omitempty"`
+	Width             int               `json:"width,omitempty"`
+	Height            int               `json:"height,omitempty"`
+	Orientation       string            `json:"orientation,omitempty"`
+	Duration          float64           `json:"duration,omitempty"`
+	Codec             string            `json:"codec,omitempty"`
+	Rotation          int               `json:"rotation,omitempty"`
+	Title             string            `json:"title,omitempty"`
+	Model             *ModelInfo        `json:"model,omitempty"`
+	Preview           string            `json:"preview,omitempty"`        // short preview clip
+	Waveform          string            `json:"waveform,omitempty"`       // audio waveform peaks json
+	Variants          map[string]string `json:"variants,omitempty"`       // variant name, like lufs16, to path
+	SuffixMismatch    bool              `json:"suffixMismatch,omitempty"` // suffix names another format than the content
+	Conversions       map[string]string `json:"conversions,omitempty"`    // conversion suffix, like jpg, to path
+	ConvertedFrom     string            `json:"convertedFrom,omitempty"`  // path of the original of a conversion
+	Thumbnails        map[string]string `json:"thumbnails,omitempty"`     // thumbnail format to path
+	Key               string            `json:"key"`                      // media cache key
+	Sha256            string            `json:"sha256"`
+}
+
+// MediaManifest is the file manifest of an object, keyed by path relative to the object folder
+type MediaManifest map[string]*MediaFileInfo
+
+// NewMediaFileInfo describes file p, which is at relPath in object cid
+func NewMediaFileInfo(ctx context.Context, p string, relPath string, cid string) (*MediaFileInfo, error) {
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open media file %s %v", p, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot hash media file %s %v", p, err)
+	}
+
+	info := MediaFileInfo{
+		Path:   relPath,
+		Size:   size,
+		Key:    path.Join(cid, relPath),
+		Sha256: hex.EncodeToString(h.Sum(nil)),
+	}
+
+	// prefer the registered type of the suffix, sniff when there is none
+	info.Mime = mime.TypeByExtension(path.Ext(p))
+	if info.Mime == "" {
+		f.Seek(0, 0)
+		info.Mime, err = GetFileContentType(f)
+		if err != nil {
+			info.Mime = "application/octet-stream"
+		}
+	}
+
+	mediaFormat, mediaFormatDetail, metadata, err := GetMediaFormatAndMetadata(ctx, p, GetMediaSuffix(p))
+	if err != nil {
+		glog.V(2).Infof("not a media file or no metadata %s %v", p, err)
+		return &info, nil
+	}
+	info.MediaFormat = mediaFormat
+	info.MediaFormatDetail = mediaFormatDetail
+	info.Width, _ = strconv.Atoi(metadata["width"])
+	info.Height, _ = strconv.Atoi(metadata["height"])
+	info.Orientation = metadata["orientation"]
+	info.Duration, _ = strconv.ParseFloat(metadata["duration"], 64)
+	info.Title = metadata["title"]
+	info.Codec = metadata["codec"]
+	info.Rotation, _ = strconv.Atoi(metadata["rotation"])
+	_, info.SuffixMismatch = metadata["suffixMismatch"]
+
+	if mediaFormat == MediaFormatModel {
+		info.Model, err = GetModelInfo(p, mediaFormatDetail)
+		if err != nil {
+			glog.Warningf("cannot read model %s %v", p, err)
+		}
+	}
+
+	return &info, nil
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMediaManifest(t *testing.T) {
+
+	os.RemoveAll("/var/tmp/mediatmp")
+	os.MkdirAll("/var/tmp/mediatmp/obj/media", 0755)
+	CopyFile("../test/object_folder/index.json", "/var/tmp/mediatmp/obj/index.json")
+	CopyFile("../test/hello.mp3", "/var/tmp/mediatmp/obj/media/hello.mp3")
+	CopyFile("../test/image.jpg", "/var/tmp/mediatmp/obj/media/image.jpg")
+
+	manifest, err := CreateThumbnailsInFolder(context.Background(), "/var/tmp/mediatmp/obj", "testcid")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(manifest))
+
+	index := manifest["index.json"]
+	assert.Equal(t, "application/json", index.Mime)
+	assert.Equal(t, "testcid/index.json", index.Key)
+	assert.Equal(t, 0, index.MediaFormat)
+
+	audio := manifest["media/hello.mp3"]
+	assert.Equal(t, MediaFormatAudio, audio.MediaFormat)
+	assert.Equal(t, MediaFormatDetailAudioMp3, audio.MediaFormatDetail)
+	assert.Equal(t, "Test Title", audio.Title)
+	assert.Equal(t, 64, len(audio.Sha256))
+
+	image := manifest["media/image.jpg"]
+	assert.Equal(t, MediaFormatImage, image.MediaFormat)
+	assert.Equal(t, 200, image.Width)
+	assert.Equal(t, "media/image_p100.jpg", image.Thumbnails[Thumb_p100])
+	assert.Equal(t, "media/image_p1080.jpg", image.Thumbnails[Thumb_p1080])
+	_, err = os.Stat("/var/tmp/mediatmp/obj/media/image_p100.jpg")
+	assert.Nil(t, err)
+}
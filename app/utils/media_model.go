@@ -0,0 +1,472 @@
+package utils
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ModelInfo is the metadata of a 3d model
+type ModelInfo struct {
+	BoundsMin  []float64 `json:"boundsMin,omitempty"` // bounding box in model units, before node transforms
+	BoundsMax  []float64 `json:"boundsMax,omitempty"`
+	Triangles  int       `json:"triangles"`
+	Materials  []string  `json:"materials,omitempty"`
+	Textures   []string  `json:"textures,omitempty"` // texture files, relative to the model
+	Animations []string  `json:"animations,omitempty"`
+	References []string  `json:"references,omitempty"` // all external files, relative to the model
+	Missing    []string  `json:"missing,omitempty"`    // references not found
+}
+
+// gltf is the part of a glTF 2.0 document read here
+type gltf struct {
+	Asset struct {
+		Version string `json:"version"`
+	} `json:"asset"`
+	Accessors []struct {
+		BufferView    *int      `json:"bufferView"`
+		ByteOffset    int       `json:"byteOffset"`
+		ComponentType int       `json:"componentType"`
+		Count         int       `json:"count"`
+		Type          string    `json:"type"`
+		Min           []float64 `json:"min"`
+		Max           []float64 `json:"max"`
+	} `json:"accessors"`
+	BufferViews []struct {
+		Buffer     int `json:"buffer"`
+		ByteOffset int `json:"byteOffset"`
+		ByteLength int `json:"byteLength"`
+		ByteStride int `json:"byteStride"`
+	} `json:"bufferViews"`
+	Buffers []struct {
+		Uri        string `json:"uri"`
+		ByteLength int    `json:"byteLength"`
+	} `json:"buffers"`
+	Meshes []struct {
+		Primitives []struct {
+			Attributes map[string]int `json:"attributes"`
+			Indices    *int           `json:"indices"`
+			Material   *int           `json:"material"`
+			Mode       *int           `json:"mode"`
+		} `json:"primitives"`
+	} `json:"meshes"`
+	Nodes []struct {
+		Mesh        *int      `json:"mesh"`
+		Children    []int     `json:"children"`
+		Matrix      []float64 `json:"matrix"`
+		Translation []float64 `json:"translation"`
+		Rotation    []float64 `json:"rotation"`
+		Scale       []float64 `json:"scale"`
+	} `json:"nodes"`
+	Scenes []struct {
+		Nodes []int `json:"nodes"`
+	} `json:"scenes"`
+	Scene     *int `json:"scene"`
+	Materials []struct {
+		Name                 string `json:"name"`
+		PbrMetallicRoughness struct {
+			BaseColorFactor  []float64 `json:"baseColorFactor"`
+			BaseColorTexture *struct {
+				Index int `json:"index"`
+			} `json:"baseColorTexture"`
+		} `json:"pbrMetallicRoughness"`
+	} `json:"materials"`
+	Textures []struct {
+		Source *int `json:"source"`
+	} `json:"textures"`
+	Images []struct {
+		Uri        string `json:"uri"`
+		BufferView *int   `json:"bufferView"`
+		MimeType   string `json:"mimeType"`
+	} `json:"images"`
+	Animations []struct {
+		Name string `json:"name"`
+	} `json:"animations"`
+}
+
+const (
+	glbMagic     = 0x46546c67 // glTF
+	glbChunkJson = 0x4e4f534a // JSON
+	glbChunkBin  = 0x004e4942 // BIN
+)
+
+// GetModelInfo returns the metadata of the 3d model at p.  References are checked against the folder of p.
+func GetModelInfo(p string, mediaFormatDetail int) (*ModelInfo, error) {
+
+	switch mediaFormatDetail {
+	case MediaFormatDetailModelGlb:
+		doc, _, err := readGlb(p)
+		if err != nil {
+			return nil, err
+		}
+		return gltfModelInfo(doc, path.Dir(p)), nil
+	case MediaFormatDetailModelGltf:
+		doc, err := readGltf(p)
+		if err != nil {
+			return nil, err
+		}
+		return gltfModelInfo(doc, path.Dir(p)), nil
+	case MediaFormatDetailModelUsdz:
+		return usdzModelInfo(p)
+	case MediaFormatDetailModelObj:
+		return objModelInfo(p)
+	}
+	return nil, fmt.Errorf("not a model format %d %s", mediaFormatDetail, p)
+}
+
+// readGlb returns the JSON document and binary chunk of a GLB file
+func readGlb(p string) (*gltf, []byte, error) {
+
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read glb %s %v", p, err)
+	}
+	if len(b) < 20 || binary.LittleEndian.Uint32(b[0:4]) != glbMagic {
+		return nil, nil, fmt.Errorf("not a glb file %s", p)
+	}
+
+	var doc *gltf
+	var bin []byte
+	for off := 12; off+8 <= len(b); {
+		chunkLen := int(binary.LittleEndian.Uint32(b[off : off+4]))
+		chunkType := binary.LittleEndian.Uint32(b[off+4 : off+8])
+		off += 8
+		if chunkLen < 0 || off+chunkLen > len(b) {
+			return nil, nil, fmt.Errorf("glb chunk overruns file %s", p)
+		}
+		switch chunkType {
+		case glbChunkJson:
+			doc = &gltf{}
+			err = json.Unmarshal(b[off:off+chunkLen], doc)
+			if err != nil {
+				return nil, nil, fmt.Errorf("cannot unmarshall glb json %s %v", p, err)
+			}
+		case glbChunkBin:
+			bin = b[off : off+chunkLen]
+		}
+		off += chunkLen
+	}
+	if doc == nil {
+		return nil, nil, fmt.Errorf("glb has no json chunk %s", p)
+	}
+	return doc, bin, nil
+}
+
+// readGltf returns the JSON document of a glTF file
+func readGltf(p string) (*gltf, error) {
+
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read gltf %s %v", p, err)
+	}
+	var doc gltf
+	err = json.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshall gltf %s %v", p, err)
+	}
+	return &doc, nil
+}
+
+// gltfModelInfo summarizes a glTF document, external uris are resolved against dir
+func gltfModelInfo(doc *gltf, dir string) *ModelInfo {
+
+	info := ModelInfo{}
+
+	for _, m := range doc.Meshes {
+		for _, prim := range m.Primitives {
+			pos, ok := prim.Attributes["POSITION"]
+			if !ok || pos < 0 || pos >= len(doc.Accessors) {
+				continue
+			}
+			a := doc.Accessors[pos]
+			info.addBounds(a.Min, a.Max)
+
+			n := a.Count
+			if prim.Indices != nil && *prim.Indices >= 0 && *prim.Indices < len(doc.Accessors) {
+				n = doc.Accessors[*prim.Indices].Count
+			}
+			mode := 4
+			if prim.Mode != nil {
+				mode = *prim.Mode
+			}
+			switch mode {
+			case 4: // triangles
+				info.Triangles += n / 3
+			case 5, 6: // strip, fan
+				if n > 2 {
+					info.Triangles += n - 2
+				}
+			}
+		}
+	}
+
+	for i, m := range doc.Materials {
+		name := m.Name
+		if name == "" {
+			name = fmt.Sprintf("material%d", i)
+		}
+		info.Materials = append(info.Materials, name)
+	}
+	for i, a := range doc.Animations {
+		name := a.Name
+		if name == "" {
+			name = fmt.Sprintf("animation%d", i)
+		}
+		info.Animations = append(info.Animations, name)
+	}
+
+	for _, b := range doc.Buffers {
+		if b.Uri != "" && !strings.HasPrefix(b.Uri, "data:") {
+			info.addReference(dir, gltfUriPath(b.Uri))
+		}
+	}
+	for _, img := range doc.Images {
+		if img.Uri != "" && !strings.HasPrefix(img.Uri, "data:") {
+			info.Textures = append(info.Textures, gltfUriPath(img.Uri))
+			info.addReference(dir, gltfUriPath(img.Uri))
+		}
+	}
+
+	return &info
+}
+
+// gltfUriPath returns the file path of a relative glTF uri, which may be percent encoded
+func gltfUriPath(uri string) string {
+	p, err := url.PathUnescape(uri)
+	if err != nil {
+		return uri
+	}
+	return p
+}
+
+// usdzModelInfo lists the layers and textures in a usdz package.  Only text layers are read for materials and animations.
+func usdzModelInfo(p string) (*ModelInfo, error) {
+
+	z, err := zip.OpenReader(p)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open usdz %s %v", p, err)
+	}
+	defer z.Close()
+
+	info := ModelInfo{}
+	entries := map[string]bool{}
+	var assets []string
+
+	for _, f := range z.File {
+		entries[f.Name] = true
+		switch strings.ToLower(path.Ext(f.Name)) {
+		case ".png", ".jpg", ".jpeg":
+			info.Textures = append(info.Textures, f.Name)
+		case ".usda":
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("cannot open usdz layer %s %s %v", p, f.Name, err)
+			}
+			b, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return nil, fmt.Errorf("cannot read usdz layer %s %s %v", p, f.Name, err)
+			}
+			materials, animations, refs := scanUsda(b)
+			info.Materials = append(info.Materials, materials...)
+			info.Animations = append(info.Animations, animations...)
+			for _, r := range refs {
+				assets = append(assets, path.Join(path.Dir(f.Name), r))
+			}
+		}
+	}
+
+	// usdz references resolve inside the package
+	for _, a := range assets {
+		info.References = append(info.References, a)
+		if !entries[a] {
+			info.Missing = append(info.Missing, a)
+		}
+	}
+	return &info, nil
+}
+
+var usdaDefRegexp = regexp.MustCompile(`def\s+(Material|SkelAnimation)\s+"([^"]+)"`)
+var usdaAssetRegexp = regexp.MustCompile(`@([^@\s]+)@`)
+
+// scanUsda returns material and animation names and relative asset paths of a text USD layer
+func scanUsda(b []byte) (materials []string, animations []string, refs []string) {
+
+	for _, m := range usdaDefRegexp.FindAllSubmatch(b, -1) {
+		if string(m[1]) == "Material" {
+			materials = append(materials, string(m[2]))
+		} else {
+			animations = append(animations, string(m[2]))
+		}
+	}
+	for _, m := range usdaAssetRegexp.FindAllSubmatch(b, -1) {
+		r := string(m[1])
+		if !strings.Contains(r, "://") && !path.IsAbs(r) {
+			refs = append(refs, r)
+		}
+	}
+	return
+}
+
+// objModelInfo reads a Wavefront OBJ and its material libraries
+func objModelInfo(p string) (*ModelInfo, error) {
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open obj %s %v", p, err)
+	}
+	defer f.Close()
+
+	info := ModelInfo{}
+	dir := path.Dir(p)
+	materials := map[string]bool{}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "v":
+			if len(fields) < 4 {
+				continue
+			}
+			v := make([]float64, 3)
+			for i := range v {
+				v[i], _ = strconv.ParseFloat(fields[i+1], 64)
+			}
+			info.addBounds(v, v)
+		case "f":
+			if len(fields) > 3 {
+				info.Triangles += len(fields) - 3
+			}
+		case "usemtl":
+			if len(fields) > 1 && !materials[fields[1]] {
+				materials[fields[1]] = true
+				info.Materials = append(info.Materials, fields[1])
+			}
+		case "mtllib":
+			for _, lib := range fields[1:] {
+				if info.addReference(dir, lib) {
+					info.addMtlTextures(dir, lib)
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read obj %s %v", p, err)
+	}
+	return &info, nil
+}
+
+// addMtlTextures adds the texture maps of material library lib
+func (info *ModelInfo) addMtlTextures(dir string, lib string) {
+
+	b, err := ioutil.ReadFile(path.Join(dir, lib))
+	if err != nil {
+		return
+	}
+	libDir := path.Dir(lib)
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "map_") && fields[0] != "bump" && fields[0] != "disp" {
+			continue
+		}
+		// options come before the file name, which is last
+		tex := path.Join(libDir, fields[len(fields)-1])
+		info.Textures = append(info.Textures, tex)
+		info.addReference(dir, tex)
+	}
+}
+
+// addReference records a file referenced by the model, returns true if it exists inside dir
+func (info *ModelInfo) addReference(dir string, ref string) bool {
+
+	ref = filepath.ToSlash(ref)
+	info.References = append(info.References, ref)
+
+	clean := path.Clean(ref)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(ref, "://") {
+		info.Missing = append(info.Missing, ref)
+		return false
+	}
+	st, err := os.Stat(path.Join(dir, clean))
+	if err != nil || st.IsDir() {
+		info.Missing = append(info.Missing, ref)
+		return false
+	}
+	return true
+}
+
+// addBounds grows the bounding box by min and max
+func (info *ModelInfo) addBounds(min []float64, max []float64) {
+
+	if len(min) < 3 || len(max) < 3 {
+		return
+	}
+	if info.BoundsMin == nil {
+		info.BoundsMin = []float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+		info.BoundsMax = []float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	}
+	for i := 0; i < 3; i++ {
+		info.BoundsMin[i] = math.Min(info.BoundsMin[i], min[i])
+		info.BoundsMax[i] = math.Max(info.BoundsMax[i], max[i])
+	}
+}
+
+// CheckModelsInFolder checks that all files referenced by the 3d models in folder mediaPath are in the folder
+func CheckModelsInFolder(mediaPath string) error {
+
+	return filepath.Walk(mediaPath, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+		mediaFormat, mediaFormatDetail, _, err := DetectMediaFormat(p, GetMediaSuffix(p))
+		if err != nil || mediaFormat != MediaFormatModel {
+			return nil
+		}
+		info, err := GetModelInfo(p, mediaFormatDetail)
+		if err != nil {
+			return fmt.Errorf("cannot read model %s %v", p, err)
+		}
+		if len(info.Missing) > 0 {
+			return fmt.Errorf("model %s references missing files %s", strings.TrimPrefix(p[len(mediaPath):], "/"),
+				strings.Join(info.Missing, ", "))
+		}
+		return nil
+	})
+}
+
+// isObjText returns true when b looks like the start of a Wavefront OBJ file
+func isObjText(b []byte) bool {
+
+	if bytes.IndexByte(b, 0) >= 0 {
+		return false
+	}
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		fields := bytes.Fields(line)
+		if len(fields) == 0 || fields[0][0] == '#' {
+			continue
+		}
+		switch string(fields[0]) {
+		case "v", "vt", "vn", "f", "o", "g", "s", "mtllib", "usemtl":
+			return true
+		}
+		return false
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCube returns positions and indices of a unit cube centered on the origin
+func testCube() ([]float32, []uint16) {
+	positions := []float32{
+		-0.5, -0.5, -0.5, 0.5, -0.5, -0.5, 0.5, 0.5, -0.5, -0.5, 0.5, -0.5,
+		-0.5, -0.5, 0.5, 0.5, -0.5, 0.5, 0.5, 0.5, 0.5, -0.5, 0.5, 0.5,
+	}
+	indices := []uint16{
+		0, 2, 1, 0, 3, 2, // back
+		4, 5, 6, 4, 6, 7, // front
+		0, 1, 5, 0, 5, 4, // bottom
+		3, 7, 6, 3, 6, 2, // top
+		0, 4, 7, 0, 7, 3, // left
+		1, 2, 6, 1, 6, 5, // right
+	}
+	return positions, indices
+}
+
+// testGltf returns a glTF document and its binary buffer holding a cube.  bufferUri is empty for GLB.
+func testGltf(bufferUri string, imageUri string) (map[string]interface{}, []byte) {
+
+	positions, indices := testCube()
+	var bin bytes.Buffer
+	binary.Write(&bin, binary.LittleEndian, positions)
+	binary.Write(&bin, binary.LittleEndian, indices)
+
+	buffer := map[string]interface{}{"byteLength": bin.Len()}
+	if bufferUri != "" {
+		buffer["uri"] = bufferUri
+	}
+	doc := map[string]interface{}{
+		"asset":   map[string]interface{}{"version": "2.0"},
+		"scene":   0,
+		"scenes":  []interface{}{map[string]interface{}{"nodes": []int{0}}},
+		"nodes":   []interface{}{map[string]interface{}{"mesh": 0}},
+		"buffers": []interface{}{buffer},
+		"bufferViews": []interface{}{
+			map[string]interface{}{"buffer": 0, "byteOffset": 0, "byteLength": len(positions) * 4},
+			map[string]interface{}{"buffer": 0, "byteOffset": len(positions) * 4, "byteLength": len(indices) * 2},
+		},
+		"accessors": []interface{}{
+			map[string]interface{}{"bufferView": 0, "componentType": 5126, "count": len(positions) / 3, "type": "VEC3",
+				"min": []float64{-0.5, -0.5, -0.5}, "max": []float64{0.5, 0.5, 0.5}},
+			map[string]interface{}{"bufferView": 1, "componentType": 5123, "count": len(indices), "type": "SCALAR"},
+		},
+		"meshes": []interface{}{map[string]interface{}{"primitives": []interface{}{
+			map[string]interface{}{"attributes": map[string]int{"POSITION": 0}, "indices": 1, "material": 0},
+		}}},
+		"materials": []interface{}{map[string]interface{}{"name": "red",
+			"pbrMetallicRoughness": map[string]interface{}{"baseColorFactor": []float64{1, 0, 0, 1}}}},
+		"animations": []interface{}{map[string]interface{}{"name": "spin"}},
+	}
+	if imageUri != "" {
+		doc["images"] = []interface{}{map[string]interface{}{"uri": imageUri}}
+		doc["textures"] = []interface{}{map[string]interface{}{"source": 0}}
+	}
+	return doc, bin.Bytes()
+}
+
+// writeTestGlb writes a GLB cube to p
+func writeTestGlb(t *testing.T, p string) {
+	doc, bin := testGltf("", "")
+	writeGlb(t, p, doc, bin)
+}
+
+// writeGlb writes the glTF document doc and its binary buffer bin to p as GLB
+func writeGlb(t *testing.T, p string, doc map[string]interface{}, bin []byte) {
+
+	j, err := json.Marshal(doc)
+	assert.Nil(t, err)
+	for len(j)%4 != 0 {
+		j = append(j, ' ')
+	}
+	for len(bin)%4 != 0 {
+		bin = append(bin, 0)
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, []uint32{glbMagic, 2, uint32(12 + 8 + len(j) + 8 + len(bin))})
+	binary.Write(&b, binary.LittleEndian, []uint32{uint32(len(j)), glbChunkJson})
+	b.Write(j)
+	binary.Write(&b, binary.LittleEndian, []uint32{uint32(len(bin)), glbChunkBin})
+	b.Write(bin)
+	assert.Nil(t, ioutil.WriteFile(p, b.Bytes(), 0644))
+}
+
+func TestModelGlb(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "model")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	p := path.Join(dir, "cube.glb")
+	writeTestGlb(t, p)
+
+	format, formatDetail, _, err := DetectMediaFormat(p, "glb")
+	assert.Nil(t, err)
+	assert.Equal(t, MediaFormatModel, format)
+	assert.Equal(t, MediaFormatDetailModelGlb, formatDetail)
+
+	info, err := GetModelInfo(p, formatDetail)
+	assert.Nil(t, err)
+	assert.Equal(t, 12, info.Triangles)
+	assert.Equal(t, []float64{-0.5, -0.5, -0.5}, info.BoundsMin)
+	assert.Equal(t, []float64{0.5, 0.5, 0.5}, info.BoundsMax)
+	assert.Equal(t, []string{"red"}, info.Materials)
+	assert.Equal(t, []string{"spin"}, info.Animations)
+	assert.Empty(t, info.Missing)
+
+	fileInfo, err := NewMediaFileInfo(context.Background(), p, "cube.glb", "cid1")
+	assert.Nil(t, err)
+	assert.Equal(t, MediaFormatModel, fileInfo.MediaFormat)
+	assert.Equal(t, 12, fileInfo.Model.Triangles)
+}
+
+func TestModelGltf(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "model")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	doc, bin := testGltf("cube%20data.bin", "tex/color.png")
+	j, _ := json.Marshal(doc)
+	p := path.Join(dir, "cube.gltf")
+	ioutil.WriteFile(p, j, 0644)
+	ioutil.WriteFile(path.Join(dir, "cube data.bin"), bin, 0644)
+
+	info, err := GetModelInfo(p, MediaFormatDetailModelGltf)
+	assert.Nil(t, err)
+	assert.Equal(t, 12, info.Triangles)
+	assert.Equal(t, []string{"tex/color.png"}, info.Textures)
+	assert.Equal(t, []string{"cube data.bin", "tex/color.png"}, info.References)
+	assert.Equal(t, []string{"tex/color.png"}, info.Missing)
+	assert.NotNil(t, CheckModelsInFolder(dir))
+
+	os.Mkdir(path.Join(dir, "tex"), 0755)
+	CopyFile("../test/image.jpg", path.Join(dir, "tex/color.png"))
+	assert.Nil(t, CheckModelsInFolder(dir))
+
+	// data uris are not references, references can't leave the folder
+	doc, bin = testGltf("data:application/octet-stream;base64,"+base64.StdEncoding.EncodeToString(bin), "../secret.png")
+	j, _ = json.Marshal(doc)
+	ioutil.WriteFile(p, j, 0644)
+	info, err = GetModelInfo(p, MediaFormatDetailModelGltf)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"../secret.png"}, info.References)
+	assert.Equal(t, []string{"../secret.png"}, info.Missing)
+}
+
+func TestModelObj(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "model")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	obj := "# cube\nmtllib cube.mtl\no cube\nv -1 0 0\nv 1 0 0\nv 1 2 0\nv -1 2 3\nusemtl red\nf 1 2 3 4\nusemtl red\nf 1 2 3\n"
+	mtl := "newmtl red\nKd 1 0 0\nmap_Kd -s 1 1 1 red.png\n"
+	p := path.Join(dir, "cube.obj")
+	ioutil.WriteFile(p, []byte(obj), 0644)
+	ioutil.WriteFile(path.Join(dir, "cube.mtl"), []byte(mtl), 0644)
+
+	_, formatDetail, _, err := DetectMediaFormat(p, "obj")
+	assert.Nil(t, err)
+	assert.Equal(t, MediaFormatDetailModelObj, formatDetail)
+
+	info, err := GetModelInfo(p, formatDetail)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, info.Triangles)
+	assert.Equal(t, []float64{-1, 0, 0}, info.BoundsMin)
+	assert.Equal(t, []float64{1, 2, 3}, info.BoundsMax)
+	assert.Equal(t, []string{"red"}, info.Materials)
+	assert.Equal(t, []string{"red.png"}, info.Textures)
+	assert.Equal(t, []string{"red.png"}, info.Missing)
+
+	// text that is not obj is not a model
+	ioutil.WriteFile(p, []byte("hello world\n"), 0644)
+	_, _, _, err = DetectMediaFormat(p, "obj")
+	assert.NotNil(t, err)
+}
+
+func TestModelUsdz(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "model")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	usda := `#usda 1.0
+def Xform "root" {
+	def Material "wood" {
+		asset inputs:file = @textures/wood.png@
+		asset inputs:normal = @textures/normal.png@
+	}
+	def SkelAnimation "walk" {
+	}
+}
+`
+	p := path.Join(dir, "scene.usdz")
+	f, _ := os.Create(p)
+	z := zip.NewWriter(f)
+	w, _ := z.CreateHeader(&zip.FileHeader{Name: "scene.usda", Method: zip.Store})
+	w.Write([]byte(usda))
+	w, _ = z.CreateHeader(&zip.FileHeader{Name: "textures/wood.png", Method: zip.Store})
+	w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	z.Close()
+	f.Close()
+
+	_, formatDetail, _, err := DetectMediaFormat(p, "usdz")
+	assert.Nil(t, err)
+	assert.Equal(t, MediaFormatDetailModelUsdz, formatDetail)
+
+	info, err := GetModelInfo(p, formatDetail)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"wood"}, info.Materials)
+	assert.Equal(t, []string{"walk"}, info.Animations)
+	assert.Equal(t, []string{"textures/wood.png"}, info.Textures)
+	assert.Equal(t, []string{"textures/normal.png"}, info.Missing)
+}
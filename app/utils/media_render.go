@@ -0,0 +1,455 @@
+package utils
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	renderFov         = 35.0 // default camera vertical field of view, degrees
+	renderYaw         = 35.0 // default camera angle around the model, degrees from the front
+	renderPitch       = 25.0 // default camera angle above the model, degrees
+	renderSupersample = 2    // rendered at this multiple of the preview size, then downsampled
+
+	// renderMaxTriangles caps the triangles of a model, nodes can reuse a mesh any number of times
+	renderMaxTriangles = 500000
+	// renderMaxCoord bounds positions so the extent of a model cannot overflow
+	renderMaxCoord = 1e30
+	// renderMaxFrames caps the pixels rasterized, the sum of the clipped triangle bounds, in whole frames
+	renderMaxFrames = 100
+	// renderCheckEvery is how many triangles are rasterized between checks for a done context
+	renderCheckEvery = 1024
+)
+
+type vec3 [3]float64
+
+func (a vec3) add(b vec3) vec3      { return vec3{a[0] + b[0], a[1] + b[1], a[2] + b[2]} }
+func (a vec3) sub(b vec3) vec3      { return vec3{a[0] - b[0], a[1] - b[1], a[2] - b[2]} }
+func (a vec3) scale(s float64) vec3 { return vec3{a[0] * s, a[1] * s, a[2] * s} }
+func (a vec3) dot(b vec3) float64   { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+func (a vec3) length() float64      { return math.Sqrt(a.dot(a)) }
+func (a vec3) normalize() vec3      { return a.scale(1 / a.length()) }
+func (a vec3) cross(b vec3) vec3 {
+	return vec3{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+// mat4 is column major, like glTF
+type mat4 [16]float64
+
+var mat4Identity = mat4{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+
+func (m mat4) mul(n mat4) mat4 {
+	var r mat4
+	for c := 0; c < 4; c++ {
+		for row := 0; row < 4; row++ {
+			for k := 0; k < 4; k++ {
+				r[c*4+row] += m[k*4+row] * n[c*4+k]
+			}
+		}
+	}
+	return r
+}
+
+func (m mat4) apply(v vec3) vec3 {
+	return vec3{
+		m[0]*v[0] + m[4]*v[1] + m[8]*v[2] + m[12],
+		m[1]*v[0] + m[5]*v[1] + m[9]*v[2] + m[13],
+		m[2]*v[0] + m[6]*v[1] + m[10]*v[2] + m[14],
+	}
+}
+
+// trsMatrix returns the matrix of glTF translation, rotation quaternion and scale
+func trsMatrix(t []float64, r []float64, s []float64) mat4 {
+
+	m := mat4Identity
+	if len(r) == 4 {
+		x, y, z, w := r[0], r[1], r[2], r[3]
+		m = mat4{
+			1 - 2*(y*y+z*z), 2 * (x*y + z*w), 2 * (x*z - y*w), 0,
+			2 * (x*y - z*w), 1 - 2*(x*x+z*z), 2 * (y*z + x*w), 0,
+			2 * (x*z + y*w), 2 * (y*z - x*w), 1 - 2*(x*x+y*y), 0,
+			0, 0, 0, 1,
+		}
+	}
+	if len(s) == 3 {
+		for c := 0; c < 3; c++ {
+			for row := 0; row < 3; row++ {
+				m[c*4+row] *= s[c]
+			}
+		}
+	}
+	if len(t) == 3 {
+		m[12], m[13], m[14] = t[0], t[1], t[2]
+	}
+	return m
+}
+
+// renderTriangle is a triangle in world space with its base color
+type renderTriangle struct {
+	v     [3]vec3
+	color [4]float64
+}
+
+// gltfAccessorData returns the bytes, element stride and count of accessor i, which must be in the GLB binary chunk
+func gltfAccessorData(doc *gltf, bin []byte, i int, elemSize int) ([]byte, int, int, error) {
+
+	if i < 0 || i >= len(doc.Accessors) {
+		return nil, 0, 0, fmt.Errorf("accessor %d out of range", i)
+	}
+	a := doc.Accessors[i]
+	if a.BufferView == nil || *a.BufferView < 0 || *a.BufferView >= len(doc.BufferViews) {
+		return nil, 0, 0, fmt.Errorf("accessor %d has no buffer view", i)
+	}
+	view := doc.BufferViews[*a.BufferView]
+	if view.Buffer < 0 || view.Buffer >= len(doc.Buffers) || doc.Buffers[view.Buffer].Uri != "" {
+		return nil, 0, 0, fmt.Errorf("accessor %d is not in the glb binary chunk", i)
+	}
+	stride := view.ByteStride
+	if stride == 0 {
+		stride = elemSize
+	}
+	start := view.ByteOffset + a.ByteOffset
+	end := start + (a.Count-1)*stride + elemSize
+	if a.Count == 0 {
+		end = start
+	}
+	if start < 0 || end > len(bin) || end > view.ByteOffset+view.ByteLength {
+		return nil, 0, 0, fmt.Errorf("accessor %d overruns buffer", i)
+	}
+	return bin[start:end], stride, a.Count, nil
+}
+
+// gltfPositions returns the float VEC3 positions of accessor i
+func gltfPositions(doc *gltf, bin []byte, i int) ([]vec3, error) {
+
+	if i < 0 || i >= len(doc.Accessors) || doc.Accessors[i].ComponentType != 5126 || doc.Accessors[i].Type != "VEC3" {
+		return nil, fmt.Errorf("positions accessor %d is not float vec3", i)
+	}
+	b, stride, count, err := gltfAccessorData(doc, bin, i, 12)
+	if err != nil {
+		return nil, err
+	}
+	positions := make([]vec3, count)
+	for k := range positions {
+		for c := 0; c < 3; c++ {
+			positions[k][c] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[k*stride+c*4:])))
+		}
+	}
+	return positions, nil
+}
+
+// gltfIndices returns the unsigned integer indices of accessor i
+func gltfIndices(doc *gltf, bin []byte, i int) ([]int, error) {
+
+	if i < 0 || i >= len(doc.Accessors) {
+		return nil, fmt.Errorf("indices accessor %d out of range", i)
+	}
+	var elemSize int
+	switch doc.Accessors[i].ComponentType {
+	case 5121:
+		elemSize = 1
+	case 5123:
+		elemSize = 2
+	case 5125:
+		elemSize = 4
+	default:
+		return nil, fmt.Errorf("indices accessor %d has component type %d", i, doc.Accessors[i].ComponentType)
+	}
+	b, stride, count, err := gltfAccessorData(doc, bin, i, elemSize)
+	if err != nil {
+		return nil, err
+	}
+	indices := make([]int, count)
+	for k := range indices {
+		switch elemSize {
+		case 1:
+			indices[k] = int(b[k*stride])
+		case 2:
+			indices[k] = int(binary.LittleEndian.Uint16(b[k*stride:]))
+		case 4:
+			indices[k] = int(binary.LittleEndian.Uint32(b[k*stride:]))
+		}
+	}
+	return indices, nil
+}
+
+// gltfTriangles returns the triangles of the default scene of a glTF document in world space
+func gltfTriangles(doc *gltf, bin []byte) ([]renderTriangle, error) {
+
+	var roots []int
+	if len(doc.Scenes) > 0 {
+		scene := 0
+		if doc.Scene != nil && *doc.Scene >= 0 && *doc.Scene < len(doc.Scenes) {
+			scene = *doc.Scene
+		}
+		roots = doc.Scenes[scene].Nodes
+	} else {
+		for i := range doc.Nodes {
+			roots = append(roots, i)
+		}
+	}
+
+	var tris []renderTriangle
+	visited := map[int]bool{}
+	var walk func(n int, parent mat4) error
+	walk = func(n int, parent mat4) error {
+		if n < 0 || n >= len(doc.Nodes) || visited[n] {
+			return nil
+		}
+		visited[n] = true
+		node := doc.Nodes[n]
+		m := trsMatrix(node.Translation, node.Rotation, node.Scale)
+		if len(node.Matrix) == 16 {
+			copy(m[:], node.Matrix)
+		}
+		m = parent.mul(m)
+
+		if node.Mesh != nil && *node.Mesh >= 0 && *node.Mesh < len(doc.Meshes) {
+			for _, prim := range doc.Meshes[*node.Mesh].Primitives {
+				t, err := gltfPrimitiveTriangles(doc, bin, prim.Attributes, prim.Indices, prim.Mode, prim.Material, m)
+				if err != nil {
+					return err
+				}
+				tris = append(tris, t...)
+				if len(tris) > renderMaxTriangles {
+					return fmt.Errorf("more than %d triangles", renderMaxTriangles)
+				}
+			}
+		}
+		for _, c := range node.Children {
+			err := walk(c, m)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, n := range roots {
+		err := walk(n, mat4Identity)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tris, nil
+}
+
+// gltfPrimitiveTriangles returns the triangles of a mesh primitive transformed by m
+func gltfPrimitiveTriangles(doc *gltf, bin []byte, attributes map[string]int, indicesAccessor *int, modePtr *int,
+	material *int, m mat4) ([]renderTriangle, error) {
+
+	pos, ok := attributes["POSITION"]
+	if !ok {
+		return nil, nil
+	}
+	positions, err := gltfPositions(doc, bin, pos)
+	if err != nil {
+		return nil, err
+	}
+	for i := range positions {
+		positions[i] = m.apply(positions[i])
+	}
+
+	var indices []int
+	if indicesAccessor != nil {
+		indices, err = gltfIndices(doc, bin, *indicesAccessor)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		indices = make([]int, len(positions))
+		for i := range indices {
+			indices[i] = i
+		}
+	}
+
+	col := [4]float64{0.8, 0.8, 0.8, 1}
+	if material != nil && *material >= 0 && *material < len(doc.Materials) {
+		f := doc.Materials[*material].PbrMetallicRoughness.BaseColorFactor
+		if len(f) == 4 {
+			copy(col[:], f)
+		}
+	}
+
+	mode := 4
+	if modePtr != nil {
+		mode = *modePtr
+	}
+	var tris []renderTriangle
+	add := func(a, b, c int) {
+		if a < len(positions) && b < len(positions) && c < len(positions) {
+			tris = append(tris, renderTriangle{v: [3]vec3{positions[a], positions[b], positions[c]}, color: col})
+		}
+	}
+	switch mode {
+	case 4: // triangles
+		for i := 0; i+2 < len(indices); i += 3 {
+			add(indices[i], indices[i+1], indices[i+2])
+		}
+	case 5: // strip
+		for i := 0; i+2 < len(indices); i++ {
+			if i%2 == 0 {
+				add(indices[i], indices[i+1], indices[i+2])
+			} else {
+				add(indices[i+1], indices[i], indices[i+2])
+			}
+		}
+	case 6: // fan
+		for i := 1; i+1 < len(indices); i++ {
+			add(indices[0], indices[i], indices[i+1])
+		}
+	}
+	return tris, nil
+}
+
+// RenderGlbPreview renders the GLB model at p to a size x size image with a transparent background, from a default
+// camera above and to the side of the front, framing the whole model.  Meshes get flat shaded base colors.
+// Rendering stops when ctx is done.
+func RenderGlbPreview(ctx context.Context, p string, size int) (*image.NRGBA, error) {
+
+	doc, bin, err := readGlb(p)
+	if err != nil {
+		return nil, err
+	}
+	tris, err := gltfTriangles(doc, bin)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read glb meshes %s %v", p, err)
+	}
+	if len(tris) == 0 {
+		return nil, fmt.Errorf("glb has no triangles %s", p)
+	}
+	for _, t := range tris {
+		for _, v := range t.v {
+			for c := 0; c < 3; c++ {
+				if math.IsNaN(v[c]) || math.Abs(v[c]) > renderMaxCoord {
+					return nil, fmt.Errorf("glb has positions that are not finite or too large %s", p)
+				}
+			}
+		}
+	}
+
+	img, err := renderTriangles(ctx, tris, size*renderSupersample)
+	if err != nil {
+		return nil, fmt.Errorf("cannot render glb %s %v", p, err)
+	}
+	return imaging.Resize(img, size, size, imaging.Box), nil
+}
+
+// renderTriangles rasterizes tris with a z-buffer into a size x size image.  Models covering more than
+// renderMaxFrames frames are rejected, as every triangle can cover the whole frame.
+func renderTriangles(ctx context.Context, tris []renderTriangle, size int) (*image.NRGBA, error) {
+
+	// frame the bounding sphere
+	lo := vec3{math.Inf(1), math.Inf(1), math.Inf(1)}
+	hi := vec3{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, t := range tris {
+		for _, v := range t.v {
+			for c := 0; c < 3; c++ {
+				lo[c] = math.Min(lo[c], v[c])
+				hi[c] = math.Max(hi[c], v[c])
+			}
+		}
+	}
+	center := lo.add(hi).scale(0.5)
+	radius := hi.sub(lo).length() / 2
+	if radius == 0 {
+		radius = 1
+	}
+
+	halfFov := renderFov / 2 * math.Pi / 180
+	yaw := renderYaw * math.Pi / 180
+	pitch := renderPitch * math.Pi / 180
+	dir := vec3{math.Sin(yaw) * math.Cos(pitch), math.Sin(pitch), math.Cos(yaw) * math.Cos(pitch)}
+	eye := center.add(dir.scale(radius / math.Sin(halfFov) * 1.05))
+
+	forward := center.sub(eye).normalize()
+	right := forward.cross(vec3{0, 1, 0}).normalize()
+	up := right.cross(forward)
+	light := dir.add(up.scale(0.5)).add(right.scale(-0.3)).normalize()
+	focal := float64(size) / 2 / math.Tan(halfFov)
+	near := radius * 0.01
+
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	depth := make([]float64, size*size) // 1/z, 0 is empty
+	pixels := 0
+	maxPixels := renderMaxFrames * size * size
+
+	for i, t := range tris {
+		if i%renderCheckEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		var sx, sy, iz [3]float64
+		behind := false
+		for k, v := range t.v {
+			d := v.sub(eye)
+			z := d.dot(forward)
+			if z < near {
+				behind = true
+				break
+			}
+			sx[k] = float64(size)/2 + d.dot(right)*focal/z
+			sy[k] = float64(size)/2 - d.dot(up)*focal/z
+			iz[k] = 1 / z
+		}
+		if behind {
+			continue
+		}
+
+		area := (sx[1]-sx[0])*(sy[2]-sy[0]) - (sx[2]-sx[0])*(sy[1]-sy[0])
+		if area == 0 {
+			continue
+		}
+
+		// double sided lambert plus ambient
+		n := t.v[1].sub(t.v[0]).cross(t.v[2].sub(t.v[0]))
+		shade := 0.35
+		if l := n.length(); l > 0 {
+			shade += 0.65 * math.Abs(n.scale(1/l).dot(light))
+		}
+		col := color.NRGBA{
+			R: uint8(math.Min(255, t.color[0]*shade*255)),
+			G: uint8(math.Min(255, t.color[1]*shade*255)),
+			B: uint8(math.Min(255, t.color[2]*shade*255)),
+			A: uint8(math.Min(255, t.color[3]*255)),
+		}
+
+		x0 := int(math.Max(0, math.Floor(math.Min(sx[0], math.Min(sx[1], sx[2])))))
+		x1 := int(math.Min(float64(size-1), math.Ceil(math.Max(sx[0], math.Max(sx[1], sx[2])))))
+		y0 := int(math.Max(0, math.Floor(math.Min(sy[0], math.Min(sy[1], sy[2])))))
+		y1 := int(math.Min(float64(size-1), math.Ceil(math.Max(sy[0], math.Max(sy[1], sy[2])))))
+		if x1 >= x0 && y1 >= y0 {
+			pixels += (x1 - x0 + 1) * (y1 - y0 + 1)
+			if pixels > maxPixels {
+				return nil, fmt.Errorf("covers more than %d frames", renderMaxFrames)
+			}
+		}
+
+		for y := y0; y <= y1; y++ {
+			py := float64(y) + 0.5
+			for x := x0; x <= x1; x++ {
+				px := float64(x) + 0.5
+				w0 := ((sx[1]-px)*(sy[2]-py) - (sx[2]-px)*(sy[1]-py)) / area
+				w1 := ((sx[2]-px)*(sy[0]-py) - (sx[0]-px)*(sy[2]-py)) / area
+				w2 := 1 - w0 - w1
+				if w0 < 0 || w1 < 0 || w2 < 0 {
+					continue
+				}
+				z := w0*iz[0] + w1*iz[1] + w2*iz[2]
+				if z <= depth[y*size+x] {
+					continue
+				}
+				depth[y*size+x] = z
+				img.SetNRGBA(x, y, col)
+			}
+		}
+	}
+	return img, nil
+}
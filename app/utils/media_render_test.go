@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderGlbPreview(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "render")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	p := path.Join(dir, "cube.glb")
+	writeTestGlb(t, p)
+
+	img, err := RenderGlbPreview(context.Background(), p, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, img.Bounds().Dx())
+	assert.Equal(t, 100, img.Bounds().Dy())
+
+	// red cube in the middle, transparent around it
+	c := img.NRGBAAt(50, 50)
+	assert.Equal(t, uint8(255), c.A)
+	assert.True(t, c.R > 0 && c.G == 0 && c.B == 0)
+	assert.Equal(t, uint8(0), img.NRGBAAt(0, 0).A)
+	assert.Equal(t, uint8(0), img.NRGBAAt(99, 99).A)
+
+	thumbPaths, altNames, err := CreateAltSizes(context.Background(), p, "model.glb")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{path.Join(dir, "cube.glb_p100.png"), path.Join(dir, "cube.glb_p1080.png")}, thumbPaths)
+	assert.ElementsMatch(t, []string{"model.glb_p100.png", "model.glb_p1080.png"}, altNames)
+	thumb, err := imaging.Open(path.Join(dir, "cube.glb_p1080.png"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1080, thumb.Bounds().Dx())
+	for _, tp := range thumbPaths {
+		os.Remove(tp)
+	}
+
+	// previews do not clash with thumbnails of an image of the same name
+	assert.Nil(t, imaging.Save(img, path.Join(dir, "cube.png")))
+	manifest, err := CreateThumbnailsInFolder(context.Background(), dir, "cid1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(manifest))
+	assert.Equal(t, map[string]string{Thumb_p100: "cube.glb_p100.png", Thumb_p1080: "cube.glb_p1080.png"}, manifest["cube.glb"].Thumbnails)
+	assert.Equal(t, map[string]string{Thumb_p100: "cube_p100.png", Thumb_p1080: "cube_p1080.png"}, manifest["cube.png"].Thumbnails)
+
+	original, format := SplitThumbnailName("media/cube.glb_p100.png")
+	assert.Equal(t, "media/cube.glb", original)
+	assert.Equal(t, Thumb_p100, format)
+}
+
+func TestRenderUnboundedGlb(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "render")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// not a number position
+	doc, bin := testGltf("", "")
+	binary.LittleEndian.PutUint32(bin, math.Float32bits(float32(math.NaN())))
+	p := path.Join(dir, "nan.glb")
+	writeGlb(t, p, doc, bin)
+	_, err = RenderGlbPreview(context.Background(), p, 100)
+	assert.NotNil(t, err)
+
+	// a mesh reused by more nodes than can be rendered
+	doc, bin = testGltf("", "")
+	nodes := make([]interface{}, renderMaxTriangles/12+1)
+	roots := make([]int, len(nodes))
+	for i := range nodes {
+		nodes[i] = map[string]interface{}{"mesh": 0}
+		roots[i] = i
+	}
+	doc["nodes"] = nodes
+	doc["scenes"] = []interface{}{map[string]interface{}{"nodes": roots}}
+	p = path.Join(dir, "many.glb")
+	writeGlb(t, p, doc, bin)
+	_, err = RenderGlbPreview(context.Background(), p, 100)
+	assert.NotNil(t, err)
+
+	// few enough triangles, but stacked they cover too many frames
+	doc, bin = testGltf("", "")
+	nodes = make([]interface{}, 1000)
+	roots = make([]int, len(nodes))
+	for i := range nodes {
+		nodes[i] = map[string]interface{}{"mesh": 0}
+		roots[i] = i
+	}
+	doc["nodes"] = nodes
+	doc["scenes"] = []interface{}{map[string]interface{}{"nodes": roots}}
+	p = path.Join(dir, "stacked.glb")
+	writeGlb(t, p, doc, bin)
+	_, err = RenderGlbPreview(context.Background(), p, 100)
+	assert.Contains(t, err.Error(), "frames")
+
+	// canceled upload
+	doc, bin = testGltf("", "")
+	p = path.Join(dir, "canceled.glb")
+	writeGlb(t, p, doc, bin)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = RenderGlbPreview(ctx, p, 100)
+	assert.NotNil(t, err)
+}
+
+func TestRenderBrokenGlb(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "render")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	p := path.Join(dir, "broken.glb")
+	ioutil.WriteFile(p, []byte("glTF\x02\x00\x00\x00\x14\x00\x00\x00\x00\x00\x00\x00JSON"), 0644)
+	_, err = RenderGlbPreview(context.Background(), p, 100)
+	assert.NotNil(t, err)
+
+	thumbPaths, _, err := CreateAltSizes(context.Background(), p, "")
+	assert.NotNil(t, err)
+	assert.Empty(t, thumbPaths)
+}
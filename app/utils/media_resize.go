@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/spf13/viper"
+)
+
+// ResizeVariantsFolder is the cache folder of on demand resized images, kept apart from object files so they are not
+// listed with them
+const ResizeVariantsFolder = "variants"
+
+// ResizeVariant is an on demand resize of an object image
+type ResizeVariant struct {
+	Width  int    // 0 keeps aspect from height
+	Height int    // 0 keeps aspect from width
+	Fit    string // fit within width x height, or fill it center cropped
+	Format string // jpeg, png, gif or webp, empty keeps the source format
+}
+
+// ParseResizeVariant returns the variant of w, h, fit and format request params.  Width and height must be in
+// media.resize.sizes so clients cannot fill the cache with arbitrary sizes.
+func ParseResizeVariant(w string, h string, fit string, format string) (ResizeVariant, error) {
+
+	v := ResizeVariant{Fit: strings.ToLower(fit), Format: strings.ToLower(format)}
+	var err error
+	if w != "" {
+		v.Width, err = strconv.Atoi(w)
+		if err != nil {
+			return v, fmt.Errorf("bad width %s", w)
+		}
+	}
+	if h != "" {
+		v.Height, err = strconv.Atoi(h)
+		if err != nil {
+			return v, fmt.Errorf("bad height %s", h)
+		}
+	}
+	if v.Fit == "" {
+		v.Fit = ThumbnailCropFit
+	}
+
+	if v.Width == 0 && v.Height == 0 {
+		return v, fmt.Errorf("no width or height")
+	}
+	if !allowedResizeSize(v.Width) || !allowedResizeSize(v.Height) {
+		return v, fmt.Errorf("size %dx%d not allowed", v.Width, v.Height)
+	}
+	switch v.Fit {
+	case ThumbnailCropFit:
+	case ThumbnailCropFill:
+		if v.Width == 0 || v.Height == 0 {
+			return v, fmt.Errorf("fill needs width and height")
+		}
+	default:
+		return v, fmt.Errorf("unknown fit %s", v.Fit)
+	}
+	if _, ok := mapThumbnailFormatSuffix[v.Format]; v.Format != "" && !ok {
+		return v, fmt.Errorf("unknown format %s", v.Format)
+	}
+	return v, nil
+}
+
+// allowedResizeSize returns true if size is 0 or one of media.resize.sizes
+func allowedResizeSize(size int) bool {
+	if size == 0 {
+		return true
+	}
+	for _, s := range viper.GetIntSlice("media.resize.sizes") {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// Key returns the cache key of the variant of file p of object cid, for example
+// variants/cid/media/a_w200h0fit.webp
+func (v ResizeVariant) Key(cid string, p string) string {
+
+	name := AddSuffixToBasename(p, fmt.Sprintf("w%dh%d%s", v.Width, v.Height, v.Fit))
+	if suffix, ok := mapThumbnailFormatSuffix[v.Format]; ok {
+		name = ChangeSuffix(name, suffix)
+	}
+	return path.Join(ResizeVariantsFolder, cid, name)
+}
+
+// Create resizes the image at srcPath and saves it at dstPath, in the format of the dstPath suffix.
+// Images are not enlarged when fitting.  It stops when ctx is done, like when the client went away.
+func (v ResizeVariant) Create(ctx context.Context, srcPath string, dstPath string) error {
+
+	mediaFormat, _, err := SniffMediaFormat(srcPath)
+	if err != nil {
+		return fmt.Errorf("cannot get media format %s %v", srcPath, err)
+	}
+	if mediaFormat != MediaFormatImage {
+		return fmt.Errorf("not an image %s", srcPath)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	src, err := imaging.Open(srcPath, imaging.AutoOrientation(true))
+	if err != nil {
+		return fmt.Errorf("cannot open file for resize %s %v", srcPath, err)
+	}
+
+	var dst image.Image
+	switch {
+	case v.Fit == ThumbnailCropFill:
+		dst = imaging.Fill(src, v.Width, v.Height, imaging.Center, imaging.Lanczos)
+	case v.Width > 0 && v.Height > 0:
+		dst = imaging.Fit(src, v.Width, v.Height, imaging.Lanczos)
+	case v.Width > 0:
+		if v.Width >= src.Bounds().Dx() {
+			dst = src
+		} else {
+			dst = imaging.Resize(src, v.Width, 0, imaging.Lanczos)
+		}
+	default:
+		if v.Height >= src.Bounds().Dy() {
+			dst = src
+		} else {
+			dst = imaging.Resize(src, 0, v.Height, imaging.Lanczos)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err = SaveImage(ctx, dst, dstPath, viper.GetInt("media.resize.quality"))
+	if err != nil {
+		return fmt.Errorf("cannot save resized image %s %v", dstPath, err)
+	}
+	return nil
+}
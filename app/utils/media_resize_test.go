@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseResizeVariant(t *testing.T) {
+
+	viper.Set("media.resize.sizes", []int{64, 200, 400})
+
+	v, err := ParseResizeVariant("200", "", "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, ResizeVariant{Width: 200, Fit: ThumbnailCropFit}, v)
+	assert.Equal(t, "variants/cid1/media/a_w200h0fit.jpg", v.Key("cid1", "media/a.jpg"))
+
+	v, err = ParseResizeVariant("64", "64", "Fill", "WEBP")
+	assert.Nil(t, err)
+	assert.Equal(t, ResizeVariant{Width: 64, Height: 64, Fit: ThumbnailCropFill, Format: "webp"}, v)
+	assert.Equal(t, "variants/cid1/media/a_w64h64fill.webp", v.Key("cid1", "media/a.jpg"))
+
+	// sizes outside the allowed set, missing or malformed params
+	for _, params := range [][4]string{
+		{"201", "", "", ""},
+		{"200", "4000", "", ""},
+		{"", "", "", ""},
+		{"x", "", "", ""},
+		{"200", "", "fill", ""},
+		{"200", "", "stretch", ""},
+		{"200", "", "", "bmp"},
+		{"-200", "", "", ""},
+	} {
+		_, err = ParseResizeVariant(params[0], params[1], params[2], params[3])
+		assert.NotNil(t, err, "%v", params)
+	}
+}
+
+func TestResizeVariantCreate(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "resize")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	src, err := imaging.Open("../test/image.jpg", imaging.AutoOrientation(true))
+	assert.Nil(t, err)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	check := func(v ResizeVariant, name string, width int, height int) {
+		dst := path.Join(dir, name)
+		if !assert.Nil(t, v.Create(context.Background(), "../test/image.jpg", dst)) {
+			return
+		}
+		img, err := imaging.Open(dst)
+		if assert.Nil(t, err) {
+			assert.Equal(t, [2]int{width, height}, [2]int{img.Bounds().Dx(), img.Bounds().Dy()}, name)
+		}
+	}
+	check(ResizeVariant{Width: 64, Height: 64, Fit: ThumbnailCropFill}, "fill.webp", 64, 64)
+	check(ResizeVariant{Width: w / 2, Fit: ThumbnailCropFit}, "half.jpg", w/2, (h+1)/2)
+	check(ResizeVariant{Width: w * 2, Fit: ThumbnailCropFit}, "larger.png", w, h)
+
+	// not an image
+	assert.NotNil(t, ResizeVariant{Width: 64, Fit: ThumbnailCropFit}.Create(context.Background(), "../test/hello.mp3", path.Join(dir, "a.jpg")))
+
+	// canceled request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, ResizeVariant{Width: 64, Fit: ThumbnailCropFit}.Create(ctx, "../test/image.jpg", path.Join(dir, "canceled.jpg")))
+	_, err = os.Stat(path.Join(dir, "canceled.jpg"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,488 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/disintegration/imaging"
+	"github.com/golang/glog"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/spf13/viper"
+)
+
+// modes of media.scrub.gps
+const (
+	ScrubGpsStrip   = "strip"   // remove GPS
+	ScrubGpsCoarsen = "coarsen" // round GPS to media.scrub.gpsDecimals
+	ScrubGpsKeep    = "keep"    // keep GPS as is
+)
+
+// ScrubOptions are what scrubbing keeps of a photo, from media.scrub
+type ScrubOptions struct {
+	Gps             string // strip, coarsen or keep
+	GpsDecimals     int    // decimals of coarsened GPS degrees, 2 is about 1km
+	BakeOrientation bool   // rotate pixels upright, costs a re-encode
+	Quality         int    // jpeg quality of re-encoded photos
+}
+
+// GeoPosition is a GPS position in degrees
+type GeoPosition struct {
+	Lat float64
+	Lon float64
+}
+
+// ScrubResult is what scrubbing a folder did and found
+type ScrubResult struct {
+	Scrubbed []string     // paths of rewritten files
+	Position *GeoPosition // first GPS position found, as kept, coarsened when coarsening
+}
+
+// scrubOptions returns the configured ScrubOptions
+func scrubOptions() ScrubOptions {
+	opts := ScrubOptions{
+		Gps:             viper.GetString("media.scrub.gps"),
+		GpsDecimals:     viper.GetInt("media.scrub.gpsDecimals"),
+		BakeOrientation: viper.GetBool("media.scrub.bakeOrientation"),
+		Quality:         viper.GetInt("media.scrub.quality"),
+	}
+	if opts.Gps == "" {
+		opts.Gps = ScrubGpsStrip
+	}
+	return opts
+}
+
+// CheckScrubCommands returns an error when media.scrub.enabled is set but the heicScrub command cannot be found, as
+// every upload with HEIC would be rejected
+func CheckScrubCommands() error {
+
+	if !viper.GetBool("media.scrub.enabled") {
+		return nil
+	}
+	command := viper.GetString("commands.exec.heicScrub.command")
+	if command == "" {
+		return fmt.Errorf("media.scrub.enabled needs commands.exec.heicScrub")
+	}
+	if _, err := exec.LookPath(command); err != nil {
+		return fmt.Errorf("media.scrub.enabled needs %s for HEIC, install it or disable scrubbing %v", command, err)
+	}
+	return nil
+}
+
+// ScrubMediaInFolder removes private metadata from the photos in mediaPath before they are published, since IPFS is
+// permanent.  JPEG EXIF is rebuilt with camera make and model, dates, orientation and GPS per media.scrub.gps only,
+// so device serials, maker notes, XMP, IPTC, comments and embedded images are gone.  HEIC is scrubbed with the
+// heicScrub command, which always strips GPS.  Other files are skipped.  Does nothing unless media.scrub.enabled is
+// set.
+func ScrubMediaInFolder(ctx context.Context, mediaPath string) (ScrubResult, error) {
+
+	result := ScrubResult{}
+	if !viper.GetBool("media.scrub.enabled") {
+		return result, nil
+	}
+	opts := scrubOptions()
+
+	err := filepath.Walk(mediaPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		// index.json, text and other files without a scrubber are left alone
+		_, mediaFormatDetail, err := SniffMediaFormat(p)
+		if err != nil {
+			glog.V(2).Infof("not scrubbing %s %v", p, err)
+			return nil
+		}
+
+		switch mediaFormatDetail {
+		case MediaFormatDetailImageJpeg:
+			position, rewritten, err := ScrubJpeg(p, opts)
+			if err != nil {
+				return fmt.Errorf("cannot scrub jpeg %s %v", p, err)
+			}
+			if rewritten {
+				result.Scrubbed = append(result.Scrubbed, p)
+			}
+			if result.Position == nil {
+				result.Position = position
+			}
+		case MediaFormatDetailImageHeic:
+			_, err = RunCommandContext(ctx, "heicScrub", map[string]string{"heicfile": p})
+			if err != nil {
+				return fmt.Errorf("cannot scrub heic %s %v", p, err)
+			}
+			result.Scrubbed = append(result.Scrubbed, p)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// ScrubJpeg rewrites the JPEG at p without private metadata, see ScrubMediaInFolder.  Pixels are copied unchanged
+// unless the orientation is baked.  Returns the GPS position as kept, if any, and whether the file was rewritten.
+func ScrubJpeg(p string, opts ScrubOptions) (*GeoPosition, bool, error) {
+
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, false, err
+	}
+	segments, trailing, err := jpegSegments(b)
+	if err != nil {
+		return nil, false, err
+	}
+
+	x, exifErr := exif.Decode(bytes.NewReader(b))
+	orientation := 1
+	if exifErr == nil {
+		if tag, err := x.Get(exif.Orientation); err == nil {
+			orientation, _ = tag.Int(0)
+		}
+	}
+
+	rewrite := trailing
+	if opts.BakeOrientation && orientation > 1 && orientation <= 8 {
+		img, err := imaging.Decode(bytes.NewReader(b), imaging.AutoOrientation(true))
+		if err != nil {
+			return nil, false, fmt.Errorf("cannot decode %v", err)
+		}
+		var buf bytes.Buffer
+		quality := opts.Quality
+		if quality == 0 {
+			quality = defaultThumbnailQuality
+		}
+		err = imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(quality))
+		if err != nil {
+			return nil, false, fmt.Errorf("cannot encode %v", err)
+		}
+		encoded, _, err := jpegSegments(buf.Bytes())
+		if err != nil {
+			return nil, false, err
+		}
+		// keep the color profile
+		var icc [][]byte
+		for _, seg := range segments {
+			if isJpegIccSegment(seg) {
+				icc = append(icc, seg)
+			}
+		}
+		segments = append(icc, encoded...)
+		orientation = 1
+		rewrite = true
+	}
+
+	var kept [][]byte
+	for _, seg := range segments {
+		if keepJpegSegment(seg) {
+			kept = append(kept, seg)
+		} else {
+			rewrite = true
+		}
+	}
+
+	var position *GeoPosition
+	var app1 []byte
+	if exifErr == nil {
+		var gps []tiffEntry
+		position, gps = scrubGps(x, opts)
+		ifd0, exifIfd := scrubbedExifTags(x, orientation)
+		app1 = exifSegment(ifd0, exifIfd, gps)
+	}
+	if !rewrite {
+		return position, false, nil
+	}
+
+	// JFIF has to come first
+	var out bytes.Buffer
+	out.Write([]byte{0xff, 0xd8})
+	for _, seg := range kept {
+		if app1 != nil && seg[1] != 0xe0 {
+			out.Write(app1)
+			app1 = nil
+		}
+		out.Write(seg)
+	}
+
+	temp, err := ioutil.TempFile(path.Dir(p), ".scrub")
+	if err != nil {
+		return nil, false, err
+	}
+	_, err = temp.Write(out.Bytes())
+	if err2 := temp.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return nil, false, err
+	}
+	return position, true, nil
+}
+
+// jpegSegments splits a JPEG after SOI into its marker segments, with entropy coded data kept with the SOS segment
+// before it, up to and including EOI.  Returns whether data follows EOI, like images appended by phones.
+func jpegSegments(b []byte) (segments [][]byte, trailing bool, err error) {
+
+	if len(b) < 4 || b[0] != 0xff || b[1] != 0xd8 {
+		return nil, false, fmt.Errorf("not a jpeg")
+	}
+	i := 2
+	for {
+		if i+1 >= len(b) || b[i] != 0xff {
+			return nil, false, fmt.Errorf("bad marker at %d", i)
+		}
+		for i+2 < len(b) && b[i+1] == 0xff {
+			i++ // fill bytes
+		}
+		m := b[i+1]
+		if m == 0xd9 {
+			segments = append(segments, b[i:i+2])
+			return segments, i+2 < len(b), nil
+		}
+		if (m >= 0xd0 && m <= 0xd7) || m == 0x01 {
+			segments = append(segments, b[i:i+2])
+			i += 2
+			continue
+		}
+		if i+4 > len(b) {
+			return nil, false, fmt.Errorf("truncated at %d", i)
+		}
+		n := int(binary.BigEndian.Uint16(b[i+2:]))
+		if n < 2 || i+2+n > len(b) {
+			return nil, false, fmt.Errorf("bad segment length at %d", i)
+		}
+		end := i + 2 + n
+		if m == 0xda {
+			// entropy coded data runs to the next marker that is not stuffing or a restart
+			for end+1 < len(b) && !(b[end] == 0xff && b[end+1] != 0 && !(b[end+1] >= 0xd0 && b[end+1] <= 0xd7)) {
+				end++
+			}
+			if end+1 >= len(b) {
+				return nil, false, fmt.Errorf("truncated image data")
+			}
+		}
+		segments = append(segments, b[i:end])
+		i = end
+	}
+}
+
+// isJpegIccSegment returns true for APP2 color profile segments
+func isJpegIccSegment(seg []byte) bool {
+	return seg[1] == 0xe2 && len(seg) > 16 && bytes.HasPrefix(seg[4:], []byte("ICC_PROFILE\x00"))
+}
+
+// keepJpegSegment returns false for segments that may hold private metadata: EXIF, XMP, IPTC, multi picture images,
+// maker APPn and comments.  JFIF, color profiles and Adobe color transforms are kept.
+func keepJpegSegment(seg []byte) bool {
+	m := seg[1]
+	switch {
+	case m == 0xe0 || m == 0xee:
+		return true
+	case m == 0xe2:
+		return isJpegIccSegment(seg)
+	case m >= 0xe1 && m <= 0xef, m == 0xfe:
+		return false
+	}
+	return true
+}
+
+// scrubbedExifTags returns the IFD0 and EXIF IFD entries kept of x
+func scrubbedExifTags(x *exif.Exif, orientation int) (ifd0 []tiffEntry, exifIfd []tiffEntry) {
+
+	ascii := func(name exif.FieldName) (string, bool) {
+		tag, err := x.Get(name)
+		if err != nil {
+			return "", false
+		}
+		s, err := tag.StringVal()
+		return s, err == nil && s != ""
+	}
+	if s, ok := ascii(exif.Make); ok {
+		ifd0 = append(ifd0, tiffAscii(0x010f, s))
+	}
+	if s, ok := ascii(exif.Model); ok {
+		ifd0 = append(ifd0, tiffAscii(0x0110, s))
+	}
+	ifd0 = append(ifd0, tiffShort(0x0112, uint16(orientation)))
+	if s, ok := ascii(exif.DateTime); ok {
+		ifd0 = append(ifd0, tiffAscii(0x0132, s))
+	}
+	if s, ok := ascii(exif.DateTimeOriginal); ok {
+		exifIfd = append(exifIfd, tiffAscii(0x9003, s))
+	}
+	return
+}
+
+// scrubGps returns the GPS position of x as kept by opts and its GPS IFD entries
+func scrubGps(x *exif.Exif, opts ScrubOptions) (*GeoPosition, []tiffEntry) {
+
+	lat, lon, err := x.LatLong()
+	if err != nil || math.IsNaN(lat) || math.IsNaN(lon) || (lat == 0 && lon == 0) || opts.Gps == ScrubGpsStrip {
+		return nil, nil
+	}
+	if opts.Gps == ScrubGpsCoarsen {
+		scale := math.Pow(10, float64(opts.GpsDecimals))
+		lat = math.Round(lat*scale) / scale
+		lon = math.Round(lon*scale) / scale
+	}
+
+	latRef, lonRef := "N", "E"
+	if lat < 0 {
+		latRef = "S"
+	}
+	if lon < 0 {
+		lonRef = "W"
+	}
+	gps := []tiffEntry{
+		{Tag: 0x0000, Type: tiffTypeByte, Count: 4, Data: []byte{2, 3, 0, 0}},
+		tiffAscii(0x0001, latRef),
+		tiffRationals(0x0002, degreesToDms(lat)),
+		tiffAscii(0x0003, lonRef),
+		tiffRationals(0x0004, degreesToDms(lon)),
+	}
+	return &GeoPosition{Lat: lat, Lon: lon}, gps
+}
+
+// degreesToDms returns degrees, minutes and hundredths of seconds rationals of the absolute value of d
+func degreesToDms(d float64) [][2]uint32 {
+	d = math.Abs(d)
+	deg := math.Floor(d)
+	minutes := (d - deg) * 60
+	min := math.Floor(minutes)
+	sec := math.Round((minutes - min) * 60 * 100)
+	return [][2]uint32{{uint32(deg), 1}, {uint32(min), 1}, {uint32(sec), 100}}
+}
+
+// TIFF field types used in EXIF
+const (
+	tiffTypeByte     = 1
+	tiffTypeAscii    = 2
+	tiffTypeShort    = 3
+	tiffTypeLong     = 4
+	tiffTypeRational = 5
+)
+
+// tiffEntry is an IFD entry, Data is the little endian value
+type tiffEntry struct {
+	Tag   uint16
+	Type  uint16
+	Count uint32
+	Data  []byte
+}
+
+func tiffAscii(tag uint16, s string) tiffEntry {
+	return tiffEntry{Tag: tag, Type: tiffTypeAscii, Count: uint32(len(s) + 1), Data: append([]byte(s), 0)}
+}
+
+func tiffShort(tag uint16, v uint16) tiffEntry {
+	data := make([]byte, 2)
+	binary.LittleEndian.PutUint16(data, v)
+	return tiffEntry{Tag: tag, Type: tiffTypeShort, Count: 1, Data: data}
+}
+
+func tiffLong(tag uint16, v uint32) tiffEntry {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, v)
+	return tiffEntry{Tag: tag, Type: tiffTypeLong, Count: 1, Data: data}
+}
+
+func tiffRationals(tag uint16, values [][2]uint32) tiffEntry {
+	data := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(data[8*i:], v[0])
+		binary.LittleEndian.PutUint32(data[8*i+4:], v[1])
+	}
+	return tiffEntry{Tag: tag, Type: tiffTypeRational, Count: uint32(len(values)), Data: data}
+}
+
+// tiffIfdSize returns the bytes an IFD and its values take
+func tiffIfdSize(entries []tiffEntry) int {
+	size := 2 + 12*len(entries) + 4
+	for _, e := range entries {
+		if len(e.Data) > 4 {
+			size += (len(e.Data) + 1) &^ 1
+		}
+	}
+	return size
+}
+
+// writeTiffIfd writes entries sorted by tag as an IFD at offset off, followed by values that don't fit an entry
+func writeTiffIfd(buf *bytes.Buffer, entries []tiffEntry, off int) {
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Tag < entries[j].Tag })
+	valueOff := off + 2 + 12*len(entries) + 4
+	binary.Write(buf, binary.LittleEndian, uint16(len(entries)))
+	var values bytes.Buffer
+	for _, e := range entries {
+		binary.Write(buf, binary.LittleEndian, e.Tag)
+		binary.Write(buf, binary.LittleEndian, e.Type)
+		binary.Write(buf, binary.LittleEndian, e.Count)
+		if len(e.Data) <= 4 {
+			v := make([]byte, 4)
+			copy(v, e.Data)
+			buf.Write(v)
+			continue
+		}
+		binary.Write(buf, binary.LittleEndian, uint32(valueOff+values.Len()))
+		values.Write(e.Data)
+		if len(e.Data)%2 == 1 {
+			values.WriteByte(0)
+		}
+	}
+	binary.Write(buf, binary.LittleEndian, uint32(0))
+	buf.Write(values.Bytes())
+}
+
+// exifSegment returns a JPEG APP1 EXIF segment of IFD0, EXIF IFD and GPS IFD entries, pointers to the EXIF and
+// GPS IFDs are added to IFD0
+func exifSegment(ifd0 []tiffEntry, exifIfd []tiffEntry, gps []tiffEntry) []byte {
+
+	ifd0 = append([]tiffEntry{}, ifd0...)
+	if len(exifIfd) > 0 {
+		ifd0 = append(ifd0, tiffLong(0x8769, 0))
+	}
+	if len(gps) > 0 {
+		ifd0 = append(ifd0, tiffLong(0x8825, 0))
+	}
+	exifOff := 8 + tiffIfdSize(ifd0)
+	gpsOff := exifOff
+	if len(exifIfd) > 0 {
+		gpsOff += tiffIfdSize(exifIfd)
+	}
+	for i := range ifd0 {
+		switch ifd0[i].Tag {
+		case 0x8769:
+			ifd0[i] = tiffLong(0x8769, uint32(exifOff))
+		case 0x8825:
+			ifd0[i] = tiffLong(0x8825, uint32(gpsOff))
+		}
+	}
+
+	var tiff bytes.Buffer
+	tiff.Write([]byte{'I', 'I', 42, 0, 8, 0, 0, 0})
+	writeTiffIfd(&tiff, ifd0, 8)
+	if len(exifIfd) > 0 {
+		writeTiffIfd(&tiff, exifIfd, exifOff)
+	}
+	if len(gps) > 0 {
+		writeTiffIfd(&tiff, gps, gpsOff)
+	}
+
+	var seg bytes.Buffer
+	seg.Write([]byte{0xff, 0xe1})
+	binary.Write(&seg, binary.BigEndian, uint16(2+6+tiff.Len()))
+	seg.WriteString("Exif\x00\x00")
+	seg.Write(tiff.Bytes())
+	return seg.Bytes()
+}
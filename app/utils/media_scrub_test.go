@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestGpsJpeg writes a jpeg with GPS, a body serial, XMP, a comment and data after EOI to p and returns the
+// image data segment
+func writeTestGpsJpeg(t *testing.T, p string) []byte {
+
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, jpeg.Encode(&buf, img, nil))
+	segments, _, err := jpegSegments(buf.Bytes())
+	assert.Nil(t, err)
+
+	ifd0 := []tiffEntry{tiffAscii(0x010f, "Acme"), tiffAscii(0x0110, "Phone 1"), tiffShort(0x0112, 1)}
+	exifIfd := []tiffEntry{tiffAscii(0x9003, "2021:12:12 09:07:31"), tiffAscii(0xa431, "SERIAL123")}
+	gps := []tiffEntry{
+		tiffAscii(0x0001, "N"),
+		tiffRationals(0x0002, degreesToDms(41.3712345)),
+		tiffAscii(0x0003, "W"),
+		tiffRationals(0x0004, degreesToDms(71.6398765)),
+	}
+	xmp := append([]byte{0xff, 0xe1, 0, 40}, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>")...)
+	comment := append([]byte{0xff, 0xfe, 0, 8}, []byte("secret")...)
+
+	var out bytes.Buffer
+	out.Write([]byte{0xff, 0xd8})
+	out.Write(exifSegment(ifd0, exifIfd, gps))
+	out.Write(xmp[:40+2])
+	out.Write(comment)
+	var imageData []byte
+	for _, seg := range segments {
+		out.Write(seg)
+		if seg[1] == 0xda {
+			imageData = seg
+		}
+	}
+	out.Write([]byte("appended image"))
+	assert.Nil(t, ioutil.WriteFile(p, out.Bytes(), 0644))
+	return imageData
+}
+
+func TestScrubJpeg(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "scrub")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// coarsened GPS, everything else private is gone and pixels are untouched
+	p := path.Join(dir, "gps.jpg")
+	imageData := writeTestGpsJpeg(t, p)
+	position, rewritten, err := ScrubJpeg(p, ScrubOptions{Gps: ScrubGpsCoarsen, GpsDecimals: 2, BakeOrientation: true})
+	assert.Nil(t, err)
+	assert.True(t, rewritten)
+	assert.Equal(t, &GeoPosition{Lat: 41.37, Lon: -71.64}, position)
+
+	b, err := ioutil.ReadFile(p)
+	assert.Nil(t, err)
+	segments, trailing, err := jpegSegments(b)
+	assert.Nil(t, err)
+	assert.False(t, trailing)
+	assert.Contains(t, segments, imageData)
+	assert.NotContains(t, string(b), "secret")
+	assert.NotContains(t, string(b), "xmpmeta")
+	assert.NotContains(t, string(b), "SERIAL123")
+
+	x, err := exif.Decode(bytes.NewReader(b))
+	if assert.Nil(t, err) {
+		lat, lon, err := x.LatLong()
+		assert.Nil(t, err)
+		assert.InDelta(t, 41.37, lat, 0.00001)
+		assert.InDelta(t, -71.64, lon, 0.00001)
+		model, err := x.Get(exif.Model)
+		assert.Nil(t, err)
+		s, _ := model.StringVal()
+		assert.Equal(t, "Phone 1", s)
+		_, err = x.Get(exif.DateTimeOriginal)
+		assert.Nil(t, err)
+		_, err = x.Get(exif.FieldName("BodySerialNumber"))
+		assert.NotNil(t, err)
+	}
+
+	// scrubbing a scrubbed file gives the same file
+	_, rewritten, err = ScrubJpeg(p, ScrubOptions{Gps: ScrubGpsKeep})
+	assert.Nil(t, err)
+	assert.True(t, rewritten)
+	b2, _ := ioutil.ReadFile(p)
+	assert.Equal(t, b, b2)
+
+	// stripped GPS
+	writeTestGpsJpeg(t, p)
+	position, _, err = ScrubJpeg(p, ScrubOptions{Gps: ScrubGpsStrip})
+	assert.Nil(t, err)
+	assert.Nil(t, position)
+	b, _ = ioutil.ReadFile(p)
+	x, err = exif.Decode(bytes.NewReader(b))
+	if assert.Nil(t, err) {
+		_, _, err = x.LatLong()
+		assert.NotNil(t, err)
+	}
+
+	// plain jpegs are left alone
+	p = path.Join(dir, "plain.jpg")
+	var buf bytes.Buffer
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	img.SetGray(1, 1, color.Gray{200})
+	jpeg.Encode(&buf, img, nil)
+	ioutil.WriteFile(p, buf.Bytes(), 0644)
+	_, rewritten, err = ScrubJpeg(p, ScrubOptions{Gps: ScrubGpsStrip})
+	assert.Nil(t, err)
+	assert.False(t, rewritten)
+
+	// not a jpeg
+	_, _, err = ScrubJpeg("../test/hello.mp3", ScrubOptions{})
+	assert.NotNil(t, err)
+}
+
+func TestScrubJpegOrientation(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "scrub")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// portrait photo stored landscape with orientation 6 and a unique image id
+	p := path.Join(dir, "portrait.jpg")
+	CopyFile("../test/android_portrait.jpg", p)
+	_, rewritten, err := ScrubJpeg(p, ScrubOptions{Gps: ScrubGpsStrip, BakeOrientation: true, Quality: 80})
+	assert.Nil(t, err)
+	assert.True(t, rewritten)
+
+	f, err := os.Open(p)
+	assert.Nil(t, err)
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	assert.Nil(t, err)
+	assert.True(t, config.Height > config.Width)
+
+	f.Seek(0, 0)
+	x, err := exif.Decode(f)
+	if assert.Nil(t, err) {
+		o, err := x.Get(exif.Orientation)
+		assert.Nil(t, err)
+		v, _ := o.Int(0)
+		assert.Equal(t, 1, v)
+		make, err := x.Get(exif.Make)
+		assert.Nil(t, err)
+		s, _ := make.StringVal()
+		assert.Equal(t, "samsung", s)
+		_, err = x.Get(exif.ImageUniqueID)
+		assert.NotNil(t, err)
+		_, err = x.Get(exif.Software)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestScrubMediaInFolder(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "scrub")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	os.MkdirAll(path.Join(dir, "media"), 0755)
+	writeTestGpsJpeg(t, path.Join(dir, "media/a.jpg"))
+	CopyFile("../test/hello.mp3", path.Join(dir, "media/hello.mp3"))
+	ioutil.WriteFile(path.Join(dir, "index.json"), []byte(`{"kind": "arc"}`), 0644)
+	ioutil.WriteFile(path.Join(dir, "media/notes.txt"), []byte("hello"), 0644)
+
+	t.Cleanup(func() {
+		viper.Set("media.scrub.enabled", true)
+		viper.Set("media.scrub.gps", ScrubGpsCoarsen)
+		viper.Set("media.scrub.gpsDecimals", 2)
+	})
+	viper.Set("media.scrub.enabled", false)
+	result, err := ScrubMediaInFolder(context.Background(), dir)
+	assert.Nil(t, err)
+	assert.Empty(t, result.Scrubbed)
+
+	viper.Set("media.scrub.enabled", true)
+	viper.Set("media.scrub.gps", ScrubGpsCoarsen)
+	viper.Set("media.scrub.gpsDecimals", 1)
+	result, err = ScrubMediaInFolder(context.Background(), dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{path.Join(dir, "media/a.jpg")}, result.Scrubbed)
+	assert.Equal(t, &GeoPosition{Lat: 41.4, Lon: -71.6}, result.Position)
+}
+
+func TestCheckScrubCommands(t *testing.T) {
+
+	t.Cleanup(func() { viper.Set("media.scrub.enabled", true) })
+	setTestCommand(t, "heicScrub", "no-such-scrubber", nil)
+	viper.Set("media.scrub.enabled", false)
+	assert.Nil(t, CheckScrubCommands())
+	viper.Set("media.scrub.enabled", true)
+	assert.NotNil(t, CheckScrubCommands())
+	setTestCommand(t, "heicScrub", "sh", nil)
+	assert.Nil(t, CheckScrubCommands())
+}
@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const sniffLen = 4096 // bytes read from the start of a file when sniffing
+
+// ftyp brands of ISO base media files, see https://www.ftyps.com
+var mapFtypBrand = map[string]int{
+	"heic": MediaFormatDetailImageHeic,
+	"heix": MediaFormatDetailImageHeic,
+	"hevc": MediaFormatDetailImageHeic,
+	"hevx": MediaFormatDetailImageHeic,
+	"heim": MediaFormatDetailImageHeic,
+	"heis": MediaFormatDetailImageHeic,
+	"mif1": MediaFormatDetailImageHeic,
+	"msf1": MediaFormatDetailImageHeic,
+	"M4A ": MediaFormatDetailAudioMp4,
+	"M4B ": MediaFormatDetailAudioMp4,
+	"M4P ": MediaFormatDetailAudioMp4,
+	"qt  ": MediaFormatDetailVideoMov,
+	"isom": MediaFormatDetailVideoMp4,
+	"iso2": MediaFormatDetailVideoMp4,
+	"iso4": MediaFormatDetailVideoMp4,
+	"iso5": MediaFormatDetailVideoMp4,
+	"iso6": MediaFormatDetailVideoMp4,
+	"mp41": MediaFormatDetailVideoMp4,
+	"mp42": MediaFormatDetailVideoMp4,
+	"avc1": MediaFormatDetailVideoMp4,
+	"M4V ": MediaFormatDetailVideoMp4,
+	"dash": MediaFormatDetailVideoMp4,
+}
+
+// SniffMediaFormat returns mediaFormat and mediaFormatDetail of the file at path from its content, magic bytes
+// and container headers.  The suffix is not looked at.
+func SniffMediaFormat(path string) (mediaFormat int, mediaFormatDetail int, err error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot open file to sniff %s %v", path, err)
+	}
+	defer f.Close()
+
+	b := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, b)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, 0, fmt.Errorf("cannot read file to sniff %s %v", path, err)
+	}
+
+	mediaFormatDetail = sniffMediaFormatDetail(b[:n])
+	if mediaFormatDetail == 0 {
+		return 0, 0, fmt.Errorf("cannot determine media format of %s", path)
+	}
+	return mediaFormatDetail / MediaFormatBase * MediaFormatBase, mediaFormatDetail, nil
+}
+
+// sniffMediaFormatDetail returns mediaFormatDetail of the start of a file, 0 when unknown
+func sniffMediaFormatDetail(b []byte) int {
+
+	switch {
+	case bytes.HasPrefix(b, []byte("\xff\xd8\xff")):
+		return MediaFormatDetailImageJpeg
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return MediaFormatDetailImagePng
+	case bytes.HasPrefix(b, []byte("GIF87a")), bytes.HasPrefix(b, []byte("GIF89a")):
+		return MediaFormatDetailImageGif
+	case len(b) >= 12 && bytes.HasPrefix(b, []byte("RIFF")) && string(b[8:12]) == "WEBP":
+		return MediaFormatDetailImageWebp
+	case len(b) >= 12 && bytes.HasPrefix(b, []byte("RIFF")) && string(b[8:12]) == "WAVE":
+		return MediaFormatDetailAudioWav
+	case bytes.HasPrefix(b, []byte("OggS")):
+		return MediaFormatDetailAudioOgg
+	case bytes.HasPrefix(b, []byte("ID3")):
+		return MediaFormatDetailAudioMp3
+	case len(b) >= 2 && b[0] == 0xff && b[1]&0xe0 == 0xe0:
+		// mpeg audio frame sync, layer bits 00 are ADTS AAC
+		if b[1]&0x06 == 0 {
+			return MediaFormatDetailAudioAac
+		}
+		return MediaFormatDetailAudioMp3
+	case len(b) >= 12 && string(b[4:8]) == "ftyp":
+		return sniffFtyp(b)
+	case bytes.HasPrefix(b, []byte("glTF")):
+		return MediaFormatDetailModelGlb
+	case bytes.HasPrefix(b, []byte("PK\x03\x04")):
+		return sniffUsdz(b)
+	case sniffGltfJson(b):
+		return MediaFormatDetailModelGltf
+	}
+	return 0
+}
+
+// sniffFtyp returns mediaFormatDetail of an ISO base media file from the major brand, then the compatible brands
+func sniffFtyp(b []byte) int {
+
+	size := int(binary.BigEndian.Uint32(b[0:4]))
+	if size < 16 || size > len(b) {
+		size = len(b)
+	}
+	if detail, ok := mapFtypBrand[string(b[8:12])]; ok {
+		return detail
+	}
+	for i := 16; i+4 <= size; i += 4 {
+		if detail, ok := mapFtypBrand[string(b[i:i+4])]; ok {
+			return detail
+		}
+	}
+	return 0
+}
+
+// sniffUsdz returns MediaFormatDetailModelUsdz when the first entry of a zip archive is a USD layer, as the spec requires
+func sniffUsdz(b []byte) int {
+
+	if len(b) < 30 {
+		return 0
+	}
+	nameLen := int(binary.LittleEndian.Uint16(b[26:28]))
+	if 30+nameLen > len(b) {
+		return 0
+	}
+	name := strings.ToLower(string(b[30 : 30+nameLen]))
+	if strings.HasSuffix(name, ".usd") || strings.HasSuffix(name, ".usda") || strings.HasSuffix(name, ".usdc") {
+		return MediaFormatDetailModelUsdz
+	}
+	return 0
+}
+
+// sniffGltfJson returns true when b looks like the start of a glTF JSON document
+func sniffGltfJson(b []byte) bool {
+	t := bytes.TrimLeft(b, " \t\r\n\xef\xbb\xbf")
+	return bytes.HasPrefix(t, []byte("{")) && bytes.Contains(t, []byte(`"asset"`)) && bytes.Contains(t, []byte(`"version"`))
+}
+
+// reconcileMediaFormatDetails returns the detail to use for sniffed content named with the suffix detail, ok is
+// false when they disagree.  ftyp brands don't say whether an mp4 holds only audio, so the suffix decides that.
+func reconcileMediaFormatDetails(sniffed int, suffixed int) (int, bool) {
+	if sniffed == suffixed {
+		return sniffed, true
+	}
+	isoMedia := func(d int) bool {
+		return d == MediaFormatDetailAudioMp4 || d == MediaFormatDetailVideoMp4 || d == MediaFormatDetailVideoMov
+	}
+	if isoMedia(sniffed) && isoMedia(suffixed) {
+		if suffixed == MediaFormatDetailAudioMp4 {
+			return suffixed, true
+		}
+		return sniffed, true
+	}
+	return sniffed, false
+}
+
+// DetectMediaFormat returns mediaFormat and mediaFormatDetail of the file at path, sniffed from its content and
+// reconciled with suffix.  The content wins, mismatch is true when the suffix names a different format.  Content that
+// cannot be sniffed, like legacy mov files starting with a wide atom or mp3 files with junk before the first frame,
+// is taken by a known suffix.
+func DetectMediaFormat(path string, suffix string) (mediaFormat int, mediaFormatDetail int, mismatch bool, err error) {
+
+	_, mediaFormatDetail, err = SniffMediaFormat(path)
+	if err != nil {
+		// obj is plain text without magic, so it is only recognized by name
+		if strings.ToLower(suffix) == "obj" {
+			if !sniffObj(path) {
+				return 0, 0, false, err
+			}
+			return MediaFormatModel, MediaFormatDetailModelObj, false, nil
+		}
+		mediaFormat, mediaFormatDetail, suffixErr := matchSuffixToMediaFormat("placeholder." + suffix)
+		if suffixErr != nil {
+			return 0, 0, false, err
+		}
+		glog.V(2).Infof("media format of %s taken from suffix %s %v", path, suffix, err)
+		return mediaFormat, mediaFormatDetail, false, nil
+	}
+
+	_, suffixDetail, err := matchSuffixToMediaFormat("placeholder." + suffix)
+	if err != nil {
+		mismatch = suffix != ""
+	} else {
+		var ok bool
+		mediaFormatDetail, ok = reconcileMediaFormatDetails(mediaFormatDetail, suffixDetail)
+		mismatch = !ok
+	}
+	return mediaFormatDetail / MediaFormatBase * MediaFormatBase, mediaFormatDetail, mismatch, nil
+}
+
+// sniffObj returns true when the file at path starts like a Wavefront OBJ file
+func sniffObj(path string) bool {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	b := make([]byte, sniffLen)
+	n, _ := io.ReadFull(f, b)
+	return isObjText(b[:n])
+}
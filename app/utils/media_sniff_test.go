@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSniffMediaFormat(t *testing.T) {
+
+	for p, detail := range map[string]int{
+		"../test/image.jpg":        MediaFormatDetailImageJpeg,
+		"../test/hello.mp3":        MediaFormatDetailAudioMp3,
+		"../test/test.m4a":         MediaFormatDetailAudioMp4,
+		"../test/charlestown.m4a":  MediaFormatDetailVideoMp4,
+		"../test/test.heic":        MediaFormatDetailImageHeic,
+		"../test/NewportAV.jpg":    MediaFormatDetailImageJpeg,
+		"../test/NinigretPark.m4a": MediaFormatDetailAudioMp4,
+	} {
+		format, formatDetail, err := SniffMediaFormat(p)
+		assert.Nil(t, err, p)
+		assert.Equal(t, detail, formatDetail, p)
+		assert.Equal(t, detail/MediaFormatBase*MediaFormatBase, format, p)
+	}
+
+	usdz := make([]byte, 30, 64)
+	copy(usdz, "PK\x03\x04")
+	binary.LittleEndian.PutUint16(usdz[26:28], 10)
+	usdz = append(usdz, "scene.usdc"...)
+
+	for detail, b := range map[int][]byte{
+		MediaFormatDetailImagePng:  []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"),
+		MediaFormatDetailImageGif:  []byte("GIF89a\x01\x00\x01\x00"),
+		MediaFormatDetailImageWebp: []byte("RIFF\x24\x00\x00\x00WEBPVP8 "),
+		MediaFormatDetailAudioWav:  []byte("RIFF\x24\x00\x00\x00WAVEfmt "),
+		MediaFormatDetailAudioOgg:  []byte("OggS\x00\x02\x00\x00"),
+		MediaFormatDetailAudioAac:  []byte("\xff\xf1\x50\x80\x02\x1f\xfc"),
+		MediaFormatDetailVideoMov:  []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00qt  "),
+		MediaFormatDetailModelGlb:  []byte("glTF\x02\x00\x00\x00"),
+		MediaFormatDetailModelGltf: []byte(" {\n \"asset\": {\"version\": \"2.0\"}}"),
+		MediaFormatDetailModelUsdz: usdz,
+		0:                          []byte("{\"a\": 1}"),
+	} {
+		assert.Equal(t, detail, sniffMediaFormatDetail(b), string(b))
+	}
+}
+
+func TestDetectMediaFormat(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sniff")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// jpeg named png is a jpeg, and reported
+	p := path.Join(dir, "image.png")
+	CopyFile("../test/image.jpg", p)
+	format, formatDetail, metadata, err := GetMediaFormatAndMetadata(context.Background(), p, "png")
+	assert.Nil(t, err)
+	assert.Equal(t, MediaFormatImage, format)
+	assert.Equal(t, MediaFormatDetailImageJpeg, formatDetail)
+	assert.Equal(t, "png", metadata["suffixMismatch"])
+	assert.Equal(t, "200", metadata["width"])
+
+	// upper case suffix
+	_, formatDetail, mismatch, err := DetectMediaFormat("../test/image.jpg", "JPG")
+	assert.Nil(t, err)
+	assert.Equal(t, MediaFormatDetailImageJpeg, formatDetail)
+	assert.False(t, mismatch)
+
+	// mp4 branded audio named m4a is audio
+	_, formatDetail, mismatch, err = DetectMediaFormat("../test/charlestown.m4a", "m4a")
+	assert.Nil(t, err)
+	assert.Equal(t, MediaFormatDetailAudioMp4, formatDetail)
+	assert.False(t, mismatch)
+
+	// content that cannot be sniffed is taken by a known suffix, like a legacy mov or an mp3 with junk frames
+	p = path.Join(dir, "legacy.mov")
+	ioutil.WriteFile(p, []byte("\x00\x00\x00\x08wide\x00\x00\x10\x00mdat"), 0644)
+	format, formatDetail, mismatch, err = DetectMediaFormat(p, "mov")
+	assert.Nil(t, err)
+	assert.Equal(t, MediaFormatVideo, format)
+	assert.Equal(t, MediaFormatDetailVideoMov, formatDetail)
+	assert.False(t, mismatch)
+	p = path.Join(dir, "junk.mp3")
+	ioutil.WriteFile(p, []byte("\x00\x00junk before the first frame"), 0644)
+	_, formatDetail, _, err = DetectMediaFormat(p, "MP3")
+	assert.Nil(t, err)
+	assert.Equal(t, MediaFormatDetailAudioMp3, formatDetail)
+
+	// but not by an unknown suffix
+	p = path.Join(dir, "notes.txt")
+	ioutil.WriteFile(p, []byte("just some notes"), 0644)
+	_, _, _, err = DetectMediaFormat(p, "txt")
+	assert.NotNil(t, err)
+}
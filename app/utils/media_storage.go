@@ -1,31 +1,53 @@
 package utils
 
 import (
-	"os"
+	"net/http"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/spf13/viper"
 )
 
-// InitMediaStorage initializes media storage
-func InitMediaStorage() {
-	path := viper.GetString("media.schemes.localSimple.localPath")
-	if path != "" {
-		err := os.MkdirAll(path, 0777)
-		if err != nil {
-			glog.Fatalf("cannot create simple scheme path '%s' %v", path, err)
-		}
-	}
+// MediaCacheDriver is the content cache that serves object files to clients, S3 or local disk
+type MediaCacheDriver interface {
+	UploadDirectory(localDirPath string, cid string) error
+	ListFiles(cid string) ([]string, error)
+	Upload(localPath string, key string) error
+	Download(localPath string, key string) error
+	GetExpiringURL(key string) (string, http.Header, error)
+	UrlExpiry() time.Duration
+}
 
-	S3 = S3_1Driver{}
-	err := S3.Init()
-	if err != nil {
-		glog.Fatalf("cannot init S3 driver %v", err)
-	}
+// Cache is the media cache selected by media.cacheScheme
+var Cache MediaCacheDriver
+
+// InitMediaStorage initializes thumbnail profiles, IPFS and the media cache selected by media.cacheScheme, other
+// schemes are not needed
+func InitMediaStorage() {
+	InitThumbnailProfiles()
 
 	Ipfs = IPFS_Driver{}
-	err = Ipfs.Init()
+	err := Ipfs.Init()
 	if err != nil {
 		glog.Fatalf("cannot init IPFS driver %v", err)
 	}
-}
\ No newline at end of file
+
+	switch viper.GetString("media.cacheScheme") {
+	case "", "s3_1":
+		S3 = S3_1Driver{}
+		err = S3.Init()
+		if err != nil {
+			glog.Fatalf("cannot init S3 driver %v", err)
+		}
+		Cache = &S3
+	case "localSimple":
+		LocalSimple = LocalSimpleDriver{}
+		err = LocalSimple.Init()
+		if err != nil {
+			glog.Fatalf("cannot init simple scheme path '%s' %v", viper.GetString("media.schemes.localSimple.localPath"), err)
+		}
+		Cache = &LocalSimple
+	default:
+		glog.Fatalf("unknown media.cacheScheme %s", viper.GetString("media.cacheScheme"))
+	}
+}
@@ -0,0 +1,274 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+)
+
+const (
+	ThumbnailCropFit    = "fit"    // longest side is maxSize, keeps aspect
+	ThumbnailCropFill   = "fill"   // width x height, center cropped
+	ThumbnailCropSquare = "square" // maxSize x maxSize, center cropped
+
+	defaultThumbnailQuality = 85
+)
+
+// ThumbnailProfile is one configured thumbnail, from media.thumbnails
+type ThumbnailProfile struct {
+	Name    string `mapstructure:"name"`    // basename suffix, a.jpg => a_p100.jpg
+	MaxSize int    `mapstructure:"maxSize"` // longest side for fit and square
+	Width   int    `mapstructure:"width"`   // fill width, maxSize if unset
+	Height  int    `mapstructure:"height"`  // fill height, maxSize if unset
+	Crop    string `mapstructure:"crop"`    // fit, fill or square
+	Format  string `mapstructure:"format"`  // jpeg, png, gif or webp, empty keeps the source format
+	Quality int    `mapstructure:"quality"` // jpeg and webp quality 1-100
+}
+
+// defaultThumbnailProfiles are used when media.thumbnails is not configured
+var defaultThumbnailProfiles = []ThumbnailProfile{
+	{Name: Thumb_p100, MaxSize: 100, Crop: ThumbnailCropFit},
+	{Name: Thumb_p1080, MaxSize: 1080, Crop: ThumbnailCropFit},
+}
+
+// mapThumbnailFormatSuffix is the file suffix of each thumbnail output format
+var mapThumbnailFormatSuffix = map[string]string{
+	"jpeg": "jpg",
+	"jpg":  "jpg",
+	"png":  "png",
+	"gif":  "gif",
+	"webp": "webp",
+}
+
+// thumbnailProfiles are the profiles parsed by InitThumbnailProfiles
+var thumbnailProfiles []ThumbnailProfile
+
+// ThumbnailProfiles returns the thumbnail profiles parsed by InitThumbnailProfiles, the defaults before
+func ThumbnailProfiles() []ThumbnailProfile {
+	if thumbnailProfiles == nil {
+		return defaultThumbnailProfiles
+	}
+	return thumbnailProfiles
+}
+
+// InitThumbnailProfiles parses the thumbnail profiles of media.thumbnails, invalid profiles are logged and left out.
+// AVIF is not supported, so AVIF profiles fall back to JPEG.
+func InitThumbnailProfiles() {
+	thumbnailProfiles = parseThumbnailProfiles()
+}
+
+func parseThumbnailProfiles() []ThumbnailProfile {
+
+	if !viper.IsSet("media.thumbnails") {
+		return defaultThumbnailProfiles
+	}
+	var configured []ThumbnailProfile
+	err := viper.UnmarshalKey("media.thumbnails", &configured)
+	if err != nil {
+		glog.Errorf("cannot unmarshall media.thumbnails, using defaults %v", err)
+		return defaultThumbnailProfiles
+	}
+
+	profiles := []ThumbnailProfile{}
+	for _, p := range configured {
+		p.Format = strings.ToLower(p.Format)
+		if p.Crop == "" {
+			p.Crop = ThumbnailCropFit
+		}
+		if p.Format == "avif" {
+			glog.Warningf("thumbnail profile %s format avif is not supported, using jpeg", p.Name)
+			p.Format = "jpeg"
+		}
+		if err := p.validate(); err != nil {
+			glog.Errorf("ignoring thumbnail profile %s %v", p.Name, err)
+			continue
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles
+}
+
+// FindThumbnailProfile returns the profile called name
+func FindThumbnailProfile(name string) (ThumbnailProfile, bool) {
+	for _, p := range ThumbnailProfiles() {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return ThumbnailProfile{}, false
+}
+
+func (p ThumbnailProfile) validate() error {
+
+	if p.Name == "" || p.Name == Size_Actual || strings.ContainsAny(p.Name, "/.") {
+		return fmt.Errorf("bad name")
+	}
+	if p.MaxSize <= 0 && (p.Crop != ThumbnailCropFill || p.Width <= 0 || p.Height <= 0) {
+		return fmt.Errorf("no size")
+	}
+	switch p.Crop {
+	case ThumbnailCropFit, ThumbnailCropFill, ThumbnailCropSquare:
+	default:
+		return fmt.Errorf("unknown crop %s", p.Crop)
+	}
+	if _, ok := mapThumbnailFormatSuffix[p.Format]; p.Format != "" && !ok {
+		return fmt.Errorf("unknown format %s", p.Format)
+	}
+	if p.Quality < 0 || p.Quality > 100 {
+		return fmt.Errorf("quality %d out of range", p.Quality)
+	}
+	return nil
+}
+
+// Size returns the largest side of thumbnails of the profile
+func (p ThumbnailProfile) Size() int {
+	size := p.MaxSize
+	if p.Crop == ThumbnailCropFill {
+		if p.Width > size {
+			size = p.Width
+		}
+		if p.Height > size {
+			size = p.Height
+		}
+	}
+	return size
+}
+
+// ThumbnailPath returns the thumbnail name of name, a.jpg => a_p100.jpg.  The suffix is the profile format's,
+// or defaultSuffix when the profile keeps the source format, or the source suffix when that is empty too.
+func (p ThumbnailProfile) ThumbnailPath(name string, defaultSuffix string) string {
+
+	thumb := AddSuffixToBasename(name, p.Name)
+	if suffix, ok := mapThumbnailFormatSuffix[p.Format]; ok {
+		return ChangeSuffix(thumb, suffix)
+	}
+	if defaultSuffix != "" {
+		return ChangeSuffix(thumb, defaultSuffix)
+	}
+	return thumb
+}
+
+// Resize returns src resized and cropped for the profile
+func (p ThumbnailProfile) Resize(src image.Image) image.Image {
+
+	switch p.Crop {
+	case ThumbnailCropSquare:
+		return imaging.Fill(src, p.MaxSize, p.MaxSize, imaging.Center, imaging.Lanczos)
+	case ThumbnailCropFill:
+		w, h := p.Width, p.Height
+		if w <= 0 {
+			w = p.MaxSize
+		}
+		if h <= 0 {
+			h = p.MaxSize
+		}
+		return imaging.Fill(src, w, h, imaging.Center, imaging.Lanczos)
+	}
+
+	maxWidth := 0
+	maxHeight := 0
+	if src.Bounds().Dx() > src.Bounds().Dy() {
+		maxWidth = p.MaxSize
+	} else {
+		maxHeight = p.MaxSize
+	}
+	return imaging.Resize(src, maxWidth, maxHeight, imaging.Lanczos)
+}
+
+// Save encodes img to path in the format of the path suffix, see SaveImage
+func (p ThumbnailProfile) Save(ctx context.Context, img image.Image, path string) error {
+	return SaveImage(ctx, img, path, p.Quality)
+}
+
+// SaveImage encodes img to path in the format of the path suffix, jpeg at quality or a default when 0.  WebP is
+// lossless, unless the webp command is configured, which gets the quality too.  Images are encoded from pixels, so
+// they carry no EXIF, GPS position and device included.
+func SaveImage(ctx context.Context, img image.Image, path string, quality int) error {
+
+	if quality == 0 {
+		quality = defaultThumbnailQuality
+	}
+	if GetMediaSuffix(path) == "webp" {
+		if viper.GetString("commands.exec.webp.command") != "" {
+			return saveWebp(ctx, img, path, quality)
+		}
+		return saveWebpLossless(img, path)
+	}
+	return imaging.Save(img, path, imaging.JPEGQuality(quality))
+}
+
+// saveWebpLossless encodes img to path with the pure Go lossless encoder
+func saveWebpLossless(img image.Image, webpPath string) error {
+	f, err := os.Create(webpPath)
+	if err != nil {
+		return err
+	}
+	err = EncodeWebpLossless(f, img)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(webpPath)
+	}
+	return err
+}
+
+// saveWebp encodes img to a PNG, which keeps alpha, and converts that to webp at path with the webp command of
+// commands.exec, like cwebp
+func saveWebp(ctx context.Context, img image.Image, webpPath string, quality int) error {
+
+	tempDir, err := ioutil.TempDir(viper.GetString("media.uploadTemp.path"), "webp")
+	if err != nil {
+		return fmt.Errorf("cannot create webp temp dir %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	pngPath := path.Join(tempDir, "image.png")
+	err = imaging.Save(img, pngPath)
+	if err != nil {
+		return fmt.Errorf("cannot save png for webp %v", err)
+	}
+	_, err = RunCommandContext(ctx, "webp", map[string]string{
+		"pngfile":  pngPath,
+		"webpfile": webpPath,
+		"quality":  fmt.Sprint(quality),
+	})
+	if err != nil {
+		os.Remove(webpPath)
+		return fmt.Errorf("cannot encode webp %s %v", webpPath, err)
+	}
+	return nil
+}
+
+// saveThumbnails saves the thumbnails of src for all profiles next to mediaPath and returns their paths and the
+// thumbnail names of altName.  defaultSuffix is the suffix of thumbnails of profiles that keep the source format,
+// empty for the suffix of mediaPath.  Partially saved thumbnails are removed on error.
+func saveThumbnails(ctx context.Context, src image.Image, mediaPath string, altName string, defaultSuffix string) (imagePathsThumbs []string, altNameThumbs []string, err error) {
+
+	for _, p := range ThumbnailProfiles() {
+
+		thumb := p.Resize(src)
+		imagePathThumb := p.ThumbnailPath(mediaPath, defaultSuffix)
+		err = p.Save(ctx, thumb, imagePathThumb)
+		if err != nil {
+			// unwind saving thumbs and don't save partials
+			for _, np := range imagePathsThumbs {
+				os.Remove(np)
+			}
+			os.Remove(imagePathThumb)
+			return []string{}, []string{}, fmt.Errorf("cannot save thumbnail %s %v", imagePathThumb, err)
+		}
+
+		imagePathsThumbs = append(imagePathsThumbs, imagePathThumb)
+		altNameThumbs = append(altNameThumbs, p.ThumbnailPath(altName, defaultSuffix))
+	}
+	return
+}
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func setTestThumbnailProfiles(t *testing.T, profiles []map[string]interface{}) {
+	viper.Set("media.thumbnails", profiles)
+	InitThumbnailProfiles()
+	t.Cleanup(func() {
+		viper.Set("media.thumbnails", []map[string]interface{}{
+			{"name": Thumb_p100, "maxSize": 100, "crop": ThumbnailCropFit},
+			{"name": Thumb_p1080, "maxSize": 1080, "crop": ThumbnailCropFit},
+		})
+		InitThumbnailProfiles()
+	})
+}
+
+func TestThumbnailProfiles(t *testing.T) {
+
+	setTestThumbnailProfiles(t, []map[string]interface{}{
+		{"name": "sq", "maxSize": 64, "crop": "square", "format": "WEBP"},
+		{"name": "hero", "width": 160, "height": 90, "crop": "fill", "format": "avif", "quality": 70},
+		{"name": "p100", "maxSize": 100},
+		{"name": "bad", "maxSize": 100, "crop": "stretch"},
+		{"name": "nosize", "crop": "fit"},
+		{"name": "worse", "maxSize": 100, "format": "bmp"},
+	})
+
+	profiles := ThumbnailProfiles()
+	assert.Equal(t, []ThumbnailProfile{
+		{Name: "sq", MaxSize: 64, Crop: ThumbnailCropSquare, Format: "webp"},
+		{Name: "hero", Width: 160, Height: 90, Crop: ThumbnailCropFill, Format: "jpeg", Quality: 70},
+		{Name: "p100", MaxSize: 100, Crop: ThumbnailCropFit},
+	}, profiles)
+	assert.Equal(t, 160, profiles[1].Size())
+
+	assert.Equal(t, "media/a_sq.webp", GetThumbnailName("media/a.jpg", "sq"))
+	assert.Equal(t, "media/a_hero.jpg", GetThumbnailName("media/a.png", "hero"))
+	assert.Equal(t, "media/a_p100.png", GetThumbnailName("media/a.png", Thumb_p100))
+	assert.Equal(t, "media/a.png", GetThumbnailName("media/a.png", Size_Actual))
+	assert.Equal(t, "media/a_other.png", GetThumbnailName("media/a.png", "other"))
+
+	original, format := SplitThumbnailName("media/a_sq.webp")
+	assert.Equal(t, "media/a.webp", original)
+	assert.Equal(t, "sq", format)
+	original, format = SplitThumbnailName("media/a_p1080.jpg")
+	assert.Equal(t, "media/a_p1080.jpg", original)
+	assert.Equal(t, "", format)
+}
+
+func TestCreateAltSizesProfiles(t *testing.T) {
+
+	setTestThumbnailProfiles(t, []map[string]interface{}{
+		{"name": "sq", "maxSize": 64, "crop": "square", "format": "webp"},
+		{"name": "hero", "width": 160, "height": 90, "crop": "fill"},
+		{"name": "p100", "maxSize": 100, "quality": 60},
+	})
+
+	dir, err := ioutil.TempDir("", "thumbs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// image.jpg has EXIF, thumbnails must not
+	b, err := ioutil.ReadFile("../test/image.jpg")
+	assert.Nil(t, err)
+	p := path.Join(dir, "image.jpg")
+	assert.Nil(t, ioutil.WriteFile(p, b, 0644))
+
+	thumbPaths, altNames, err := CreateAltSizes(context.Background(), p, "media/image.jpg")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, []string{path.Join(dir, "image_sq.webp"), path.Join(dir, "image_hero.jpg"), path.Join(dir, "image_p100.jpg")}, thumbPaths)
+	assert.Equal(t, []string{"media/image_sq.webp", "media/image_hero.jpg", "media/image_p100.jpg"}, altNames)
+
+	sizes := [][2]int{{64, 64}, {160, 90}}
+	for i, tp := range thumbPaths {
+		img, err := imaging.Open(tp)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		if i < len(sizes) {
+			assert.Equal(t, sizes[i], [2]int{img.Bounds().Dx(), img.Bounds().Dy()})
+		} else {
+			assert.True(t, img.Bounds().Dx() == 100 || img.Bounds().Dy() == 100)
+		}
+
+		f, err := os.Open(tp)
+		assert.Nil(t, err)
+		_, err = exif.Decode(f)
+		f.Close()
+		assert.NotNil(t, err, "thumbnail %s has exif", tp)
+	}
+
+	// webp thumbnails are sniffed as webp
+	_, detail, err := SniffMediaFormat(thumbPaths[0])
+	assert.Nil(t, err)
+	assert.Equal(t, MediaFormatDetailImageWebp, detail)
+}
+
+func TestSaveWebp(t *testing.T) {
+
+	// the webp command gets a png with the quality, a copy stands in for cwebp
+	setTestCommand(t, "webp", "sh", []string{"-c", `test "$0" = 60 && cp "$1" "$2"`, "quality", "pngfile", "webpfile"})
+
+	dir, err := ioutil.TempDir("", "webp")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	src, err := imaging.Open("../test/image.jpg")
+	assert.Nil(t, err)
+	p := path.Join(dir, "a.webp")
+	assert.Nil(t, SaveImage(context.Background(), src, p, 60))
+	_, detail, err := SniffMediaFormat(p)
+	assert.Nil(t, err)
+	assert.Equal(t, MediaFormatDetailImagePng, detail)
+
+	// failed or canceled encodes leave nothing behind
+	assert.NotNil(t, SaveImage(context.Background(), src, path.Join(dir, "b.webp"), 70))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NotNil(t, SaveImage(ctx, src, path.Join(dir, "c.webp"), 60))
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+	"github.com/spf13/viper"
+)
+
+const PreviewSuffix = "preview" // basename suffix of audio and video preview clips, a.mov => a_preview.mp4
+
+// ffprobeOutput is the part of ffprobe -show_format -show_streams json output read here
+type ffprobeOutput struct {
+	Format struct {
+		Duration string            `json:"duration"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType    string            `json:"codec_type"`
+		CodecName    string            `json:"codec_name"`
+		Width        int               `json:"width"`
+		Height       int               `json:"height"`
+		Duration     string            `json:"duration"`
+		Tags         map[string]string `json:"tags"`
+		SideDataList []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+}
+
+// getVideoMediaMetadata calls ffprobe to retrieve width, height, duration, codec, rotation and title of a video.
+// Width and height are as displayed, after rotation.
+func getVideoMediaMetadata(ctx context.Context, p string) (map[string]string, error) {
+
+	out, err := RunCommandContext(ctx, "ffprobe", nil, p)
+	if err != nil {
+		return nil, fmt.Errorf("cannot run ffprobe %s %v", p, err)
+	}
+	return parseVideoProbe(out)
+}
+
+// parseVideoProbe returns video metadata from ffprobe json output
+func parseVideoProbe(out []byte) (map[string]string, error) {
+
+	var probe ffprobeOutput
+	err := json.Unmarshal(out, &probe)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshall ffprobe output %v", err)
+	}
+
+	for _, st := range probe.Streams {
+		if st.CodecType != "video" {
+			continue
+		}
+
+		// rotation is a display matrix side data in newer ffmpeg, a rotate tag in older
+		rotation := 0
+		if r, ok := st.Tags["rotate"]; ok {
+			rotation, _ = strconv.Atoi(r)
+		}
+		for _, sd := range st.SideDataList {
+			if sd.Rotation != 0 {
+				rotation = int(-sd.Rotation)
+			}
+		}
+		rotation = ((rotation % 360) + 360) % 360
+
+		w, h := st.Width, st.Height
+		if rotation == 90 || rotation == 270 {
+			w, h = h, w
+		}
+		duration := probe.Format.Duration
+		if duration == "" {
+			duration = st.Duration
+		}
+
+		meta := map[string]string{
+			"width":    strconv.Itoa(w),
+			"height":   strconv.Itoa(h),
+			"duration": duration,
+			"codec":    st.CodecName,
+			"rotation": strconv.Itoa(rotation),
+		}
+		if title, ok := probe.Format.Tags["title"]; ok {
+			meta["title"] = title
+		}
+		return meta, nil
+	}
+	return nil, fmt.Errorf("no video stream")
+}
+
+// posterSeconds returns the time of the poster frame, media.video.posterSecs into the video or half way if shorter
+func posterSeconds(duration float64) float64 {
+	secs := viper.GetFloat64("media.video.posterSecs")
+	if duration > 0 && secs > duration/2 {
+		secs = duration / 2
+	}
+	return math.Max(0, secs)
+}
+
+// createVideoPosters extracts a poster frame of the video at mediaPath and saves it for each thumbnail profile,
+// jpg unless the profile sets a format, for example video.mov to video_p100.jpg
+func createVideoPosters(ctx context.Context, mediaPath string, altName string) (imagePathsThumbs []string, altNameThumbs []string, err error) {
+
+	duration := 0.0
+	meta, err := getVideoMediaMetadata(ctx, mediaPath)
+	if err == nil {
+		duration, _ = strconv.ParseFloat(meta["duration"], 64)
+	}
+
+	tempDir, err := ioutil.TempDir(viper.GetString("media.uploadTemp.path"), "poster")
+	if err != nil {
+		return []string{}, []string{}, fmt.Errorf("cannot create poster temp dir %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// ffmpeg applies rotation when decoding, so the frame is upright
+	posterPath := path.Join(tempDir, "poster.jpg")
+	_, err = RunCommandContext(ctx, "videoPoster", map[string]string{
+		"videofile":  mediaPath,
+		"posterfile": posterPath,
+		"seconds":    strconv.FormatFloat(posterSeconds(duration), 'f', 3, 64),
+	})
+	if err != nil {
+		return []string{}, []string{}, fmt.Errorf("cannot extract poster frame %s %v", mediaPath, err)
+	}
+
+	src, err := imaging.Open(posterPath)
+	if err != nil {
+		return []string{}, []string{}, fmt.Errorf("cannot open poster frame %s %v", mediaPath, err)
+	}
+
+	return saveThumbnails(ctx, src, mediaPath, altName, "jpg")
+}
+
+// CreateVideoPreview creates a short low bitrate mp4 clip of the video at mediaPath next to it, a.mov => a_preview.mp4,
+// and returns its path
+func CreateVideoPreview(ctx context.Context, mediaPath string) (string, error) {
+
+	previewPath := ChangeSuffix(AddSuffixToBasename(mediaPath, PreviewSuffix), "mp4")
+	_, err := RunCommandContext(ctx, "videoPreview", map[string]string{
+		"videofile":   mediaPath,
+		"previewfile": previewPath,
+	})
+	if err != nil {
+		os.Remove(previewPath)
+		return "", fmt.Errorf("cannot create video preview %s %v", mediaPath, err)
+	}
+	return previewPath, nil
+}
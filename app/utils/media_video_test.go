@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVideoProbe(t *testing.T) {
+
+	out := []byte(`{
+		"streams": [
+			{"codec_type": "audio", "codec_name": "aac"},
+			{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080, "duration": "12.5",
+				"side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]}
+		],
+		"format": {"duration": "12.512", "tags": {"title": "Harbor"}}
+	}`)
+	meta, err := parseVideoProbe(out)
+	assert.Nil(t, err)
+	assert.Equal(t, "1080", meta["width"])
+	assert.Equal(t, "1920", meta["height"])
+	assert.Equal(t, "90", meta["rotation"])
+	assert.Equal(t, "h264", meta["codec"])
+	assert.Equal(t, "12.512", meta["duration"])
+	assert.Equal(t, "Harbor", meta["title"])
+
+	out = []byte(`{"streams": [{"codec_type": "video", "codec_name": "hevc", "width": 640, "height": 480,
+		"tags": {"rotate": "180"}}], "format": {"duration": "3.0"}}`)
+	meta, err = parseVideoProbe(out)
+	assert.Nil(t, err)
+	assert.Equal(t, "640", meta["width"])
+	assert.Equal(t, "180", meta["rotation"])
+
+	_, err = parseVideoProbe([]byte(`{"streams": [{"codec_type": "audio"}]}`))
+	assert.NotNil(t, err)
+}
+
+func TestCommandArgs(t *testing.T) {
+
+	viper.Set("commands.exec.testcmd.args", []string{"-i", "videofile", "-o", "posterfile"})
+	args := commandArgs("testcmd", map[string]string{"videofile": "/a/b.mov", "posterfile": "/a/b.jpg"})
+	assert.Equal(t, []string{"-i", "/a/b.mov", "-o", "/a/b.jpg"}, args)
+
+	// configured args keep their placeholders for the next call
+	assert.Equal(t, []string{"-i", "videofile", "-o", "posterfile"}, viper.GetStringSlice("commands.exec.testcmd.args"))
+}
+
+func TestPosterSeconds(t *testing.T) {
+
+	viper.Set("media.video.posterSecs", 1.0)
+	assert.Equal(t, 1.0, posterSeconds(10))
+	assert.Equal(t, 0.5, posterSeconds(1))
+	assert.Equal(t, 1.0, posterSeconds(0))
+}
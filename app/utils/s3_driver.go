@@ -154,6 +154,35 @@ func (s *S3_1Driver) DownloadDirectory(localDirPath string, cid string) error {
 	return nil
 }
 
+// ListFiles returns the keys of all files stored for cid, relative to the cid folder
+func (s *S3_1Driver) ListFiles(cid string) ([]string, error) {
+
+	if s.service == nil {
+		return nil, fmt.Errorf("S3_1Drive not initialized")
+	}
+
+	bucket := viper.GetString("media.schemes.s3_1.bucket")
+	prefix := cid + "/"
+
+	files := []string{}
+	err := s.service.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix)},
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, item := range page.Contents {
+				files = append(files, strings.TrimPrefix(*item.Key, prefix))
+			}
+			return true
+		})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list items in bucket %q, %v", bucket, err)
+	}
+	return files, nil
+}
+
+// UrlExpiry returns how long URLs from GetExpiringURL are valid
+func (s *S3_1Driver) UrlExpiry() time.Duration {
+	return time.Duration(viper.GetInt("media.schemes.s3_1.expireSecs")) * time.Second
+}
+
 // Upload loads a string TO S3, must specify mimeType (contentType)
 func (s *S3_1Driver) UploadString(body string, contentType string, folder string, key string) error {
 
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	WalletKindEthereum = "ethereum" // EVM chains, 0x addresses with EIP-55 checksums
+	WalletKindSolana   = "solana"   // base58 ed25519 public keys
+)
+
+// walletAddrValidators check and normalize addresses by wallet kind
+var walletAddrValidators = map[string]func(string) (string, error){
+	WalletKindEthereum: NormalizeEthereumAddr,
+	WalletKindSolana:   NormalizeSolanaAddr,
+}
+
+// Chain is a chain transactions can be enqueued for, see transactions.chains
+type Chain struct {
+	Id         string `mapstructure:"id"`
+	Name       string `mapstructure:"name"`
+	WalletKind string `mapstructure:"walletKind"`
+	Default    bool   `mapstructure:"default"`
+}
+
+// NormalizeWalletAddr checks addr is an address of wallet kind and returns it in canonical form
+func NormalizeWalletAddr(kind string, addr string) (string, error) {
+	validate, ok := walletAddrValidators[kind]
+	if !ok {
+		return "", fmt.Errorf("unknown wallet kind %s", kind)
+	}
+	return validate(addr)
+}
+
+// ethereumChecksumAddr returns the EIP-55 mixed case form of 40 lower case hex digits
+func ethereumChecksumAddr(lower string) string {
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(lower))
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	b := []byte(lower)
+	for i, c := range b {
+		if c >= 'a' && c <= 'f' && hash[i] >= '8' {
+			b[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(b)
+}
+
+// NormalizeEthereumAddr checks an EVM address and returns it with EIP-55 checksum.  Mixed case addresses must have
+// a valid checksum, all lower or upper case ones have none to check.  The 0x prefix is optional.
+func NormalizeEthereumAddr(addr string) (string, error) {
+
+	digits := addr
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		digits = digits[2:]
+	}
+	if len(digits) != 40 {
+		return "", fmt.Errorf("ethereum address %s must have 40 hex digits", addr)
+	}
+	if _, err := hex.DecodeString(digits); err != nil {
+		return "", fmt.Errorf("ethereum address %s is not hex", addr)
+	}
+
+	checksummed := ethereumChecksumAddr(strings.ToLower(digits))
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) && "0x"+digits != checksummed {
+		return "", fmt.Errorf("ethereum address %s has a bad checksum", addr)
+	}
+	return checksummed, nil
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// decodeBase58 decodes bitcoin alphabet base58, leading 1s are zero bytes
+func decodeBase58(s string) ([]byte, error) {
+
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	for i := 0; i < len(s); i++ {
+		d := strings.IndexByte(base58Alphabet, s[i])
+		if d < 0 {
+			return nil, fmt.Errorf("bad base58 character %q", s[i])
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(d)))
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+// NormalizeSolanaAddr checks a Solana address is a base58 32 byte public key
+func NormalizeSolanaAddr(addr string) (string, error) {
+	b, err := decodeBase58(addr)
+	if err != nil {
+		return "", fmt.Errorf("solana address %s %v", addr, err)
+	}
+	if len(b) != 32 {
+		return "", fmt.Errorf("solana address %s is %d bytes, not 32", addr, len(b))
+	}
+	return addr, nil
+}
+
+// SupportedChains returns transactions.chains
+func SupportedChains() ([]Chain, error) {
+	var chains []Chain
+	if err := viper.UnmarshalKey("transactions.chains", &chains); err != nil {
+		return nil, fmt.Errorf("cannot read transactions.chains %v", err)
+	}
+	return chains, nil
+}
+
+// FindChain returns the supported chain id for wallet kind, or the default chain of the kind when id is empty
+func FindChain(id string, walletKind string) (*Chain, error) {
+
+	chains, err := SupportedChains()
+	if err != nil {
+		return nil, err
+	}
+	for i := range chains {
+		c := &chains[i]
+		if c.WalletKind != walletKind {
+			continue
+		}
+		if c.Id == id || (id == "" && c.Default) {
+			return c, nil
+		}
+	}
+	if id == "" {
+		return nil, fmt.Errorf("chainId required, no default chain for wallet kind %s", walletKind)
+	}
+	return nil, fmt.Errorf("chain %s is not supported for wallet kind %s", id, walletKind)
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeEthereumAddr(t *testing.T) {
+
+	// EIP-55 test vectors
+	for _, addr := range []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	} {
+		a, err := NormalizeEthereumAddr(addr)
+		assert.Nil(t, err, addr)
+		assert.Equal(t, addr, a)
+	}
+
+	// no checksum to check, or no prefix
+	for _, addr := range []string{
+		"0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed",
+		"0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED",
+		"5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+	} {
+		a, err := NormalizeEthereumAddr(addr)
+		assert.Nil(t, err, addr)
+		assert.Equal(t, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", a)
+	}
+
+	for _, addr := range []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD",
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA",
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAedff",
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeg",
+		"",
+	} {
+		_, err := NormalizeEthereumAddr(addr)
+		assert.NotNil(t, err, addr)
+	}
+}
+
+func TestNormalizeSolanaAddr(t *testing.T) {
+
+	for _, addr := range []string{
+		"11111111111111111111111111111111",
+		"TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5DA",
+		"9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM",
+	} {
+		a, err := NormalizeWalletAddr(WalletKindSolana, addr)
+		assert.Nil(t, err, addr)
+		assert.Equal(t, addr, a)
+	}
+	for _, addr := range []string{
+		"TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5D0",
+		"TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5",
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"",
+	} {
+		_, err := NormalizeWalletAddr(WalletKindSolana, addr)
+		assert.NotNil(t, err, addr)
+	}
+
+	_, err := NormalizeWalletAddr("dogecoin", "D8vFz4p1L37jdg47HXKtSHA5uYLYxbGgPD")
+	assert.NotNil(t, err)
+}
+
+func TestFindChain(t *testing.T) {
+
+	viper.Set("transactions.chains", []map[string]interface{}{
+		{"id": "1", "name": "mainnet", "walletKind": "ethereum"},
+		{"id": "1337", "name": "ganache", "walletKind": "ethereum", "default": true},
+		{"id": "devnet", "name": "solana devnet", "walletKind": "solana"},
+	})
+	defer viper.Set("transactions.chains", nil)
+
+	c, err := FindChain("1", WalletKindEthereum)
+	assert.Nil(t, err)
+	assert.Equal(t, "mainnet", c.Name)
+	c, err = FindChain("", WalletKindEthereum)
+	assert.Nil(t, err)
+	assert.Equal(t, "1337", c.Id)
+
+	_, err = FindChain("", WalletKindSolana)
+	assert.NotNil(t, err)
+	_, err = FindChain("devnet", WalletKindEthereum)
+	assert.NotNil(t, err)
+	_, err = FindChain("137", WalletKindEthereum)
+	assert.NotNil(t, err)
+}
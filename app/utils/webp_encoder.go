@@ -0,0 +1,306 @@
+package utils
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// EncodeWebpLossless writes img to w as a lossless WebP (VP8L) with the subtract green transform and one set of
+// prefix codes, no backward references.  Good enough for thumbnails, see
+// https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+func EncodeWebpLossless(w io.Writer, img image.Image) error {
+
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > 1<<14 || height > 1<<14 {
+		return fmt.Errorf("cannot encode %dx%d image as webp", width, height)
+	}
+
+	rgba, ok := img.(*image.NRGBA)
+	if !ok || rgba.Rect.Min != (image.Point{}) {
+		rgba = image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	}
+
+	// subtract green, then histogram each channel
+	n := width * height
+	pix := make([][4]uint8, n) // green, red, blue, alpha symbols
+	var hist [4][256]int
+	alpha := false
+	for i := 0; i < n; i++ {
+		y, x := i/width, i%width
+		o := rgba.PixOffset(x, y)
+		r, g, bl, a := rgba.Pix[o], rgba.Pix[o+1], rgba.Pix[o+2], rgba.Pix[o+3]
+		pix[i] = [4]uint8{g, r - g, bl - g, a}
+		for c := 0; c < 4; c++ {
+			hist[c][pix[i][c]]++
+		}
+		alpha = alpha || a != 0xff
+	}
+
+	var bw webpBitWriter
+	bw.writeBits(0x2f, 8)
+	bw.writeBits(uint32(width-1), 14)
+	bw.writeBits(uint32(height-1), 14)
+	if alpha {
+		bw.writeBits(1, 1)
+	} else {
+		bw.writeBits(0, 1)
+	}
+	bw.writeBits(0, 3) // version
+
+	bw.writeBits(1, 1) // transform present
+	bw.writeBits(2, 2) // subtract green
+	bw.writeBits(0, 1) // no more transforms
+
+	bw.writeBits(0, 1) // no color cache
+	bw.writeBits(0, 1) // no meta prefix codes
+
+	// green with length prefixes, red, blue, alpha, distance
+	alphabets := [5]int{256 + 24, 256, 256, 256, 40}
+	var codes [4]webpPrefixCode
+	for c := 0; c < 5; c++ {
+		freq := make([]int, alphabets[c])
+		if c < 4 {
+			copy(freq, hist[c][:])
+		}
+		code := bw.writePrefixCode(freq)
+		if c < 4 {
+			codes[c] = code
+		}
+	}
+
+	for _, p := range pix {
+		for c := 0; c < 4; c++ {
+			bw.writeCode(codes[c], int(p[c]))
+		}
+	}
+	data := bw.bytes()
+
+	// RIFF container, chunks are padded to even length
+	pad := len(data) % 2
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(4+8+len(data)+pad))
+	out.WriteString("WEBPVP8L")
+	binary.Write(&out, binary.LittleEndian, uint32(len(data)))
+	out.Write(data)
+	if pad == 1 {
+		out.WriteByte(0)
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// webpPrefixCode is a canonical prefix code, codes are stored bit reversed ready for writing
+type webpPrefixCode struct {
+	lengths []uint32
+	codes   []uint32
+}
+
+// webpBitWriter writes bits least significant first
+type webpBitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (bw *webpBitWriter) writeBits(v uint32, n uint) {
+	bw.acc |= uint64(v) << bw.nbits
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.nbits -= 8
+	}
+}
+
+func (bw *webpBitWriter) bytes() []byte {
+	if bw.nbits > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc, bw.nbits = 0, 0
+	}
+	return bw.buf
+}
+
+func (bw *webpBitWriter) writeCode(code webpPrefixCode, symbol int) {
+	bw.writeBits(code.codes[symbol], uint(code.lengths[symbol]))
+}
+
+// order code length code lengths are written in
+var webpCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// writePrefixCode writes the prefix code for symbol frequencies freq and returns it.  One or two symbols use the
+// simple code, where a single symbol takes no bits.
+func (bw *webpBitWriter) writePrefixCode(freq []int) webpPrefixCode {
+
+	var used []int
+	for s, f := range freq {
+		if f > 0 {
+			used = append(used, s)
+		}
+	}
+	if len(used) == 0 {
+		used = []int{0}
+	}
+
+	if len(used) <= 2 && used[len(used)-1] < 256 {
+		code := webpPrefixCode{lengths: make([]uint32, len(freq)), codes: make([]uint32, len(freq))}
+		bw.writeBits(1, 1)
+		bw.writeBits(uint32(len(used)-1), 1)
+		bw.writeBits(1, 1) // 8 bit symbols
+		bw.writeBits(uint32(used[0]), 8)
+		if len(used) == 2 {
+			bw.writeBits(uint32(used[1]), 8)
+			code.lengths[used[0]], code.lengths[used[1]] = 1, 1
+			code.codes[used[1]] = 1
+		}
+		return code
+	}
+
+	lengths := huffmanCodeLengths(freq, 15)
+	code := webpPrefixCode{lengths: lengths, codes: canonicalCodes(lengths)}
+
+	// code lengths are themselves prefix coded, literal lengths 0 to 15 only
+	clFreq := make([]int, 19)
+	for _, l := range lengths {
+		clFreq[l]++
+	}
+	clLengths := huffmanCodeLengths(clFreq, 7)
+	clUsed := 0
+	for _, l := range clLengths {
+		if l > 0 {
+			clUsed++
+		}
+	}
+	clCodes := canonicalCodes(clLengths)
+	if clUsed == 1 {
+		// a single code length symbol takes no bits
+		for i := range clCodes {
+			clCodes[i] = 0
+		}
+	}
+
+	nCodes := 19
+	for nCodes > 4 && clLengths[webpCodeLengthOrder[nCodes-1]] == 0 {
+		nCodes--
+	}
+	bw.writeBits(0, 1)
+	bw.writeBits(uint32(nCodes-4), 4)
+	for i := 0; i < nCodes; i++ {
+		bw.writeBits(clLengths[webpCodeLengthOrder[i]], 3)
+	}
+	bw.writeBits(0, 1) // all symbols have lengths
+	for _, l := range lengths {
+		if clUsed == 1 {
+			continue
+		}
+		bw.writeBits(clCodes[l], uint(clLengths[l]))
+	}
+	return code
+}
+
+// canonicalCodes returns bit reversed canonical codes for code lengths
+func canonicalCodes(lengths []uint32) []uint32 {
+
+	var count [16]uint32
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [16]uint32
+	code := uint32(0)
+	for l := 1; l < 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		var rev uint32
+		for i := uint32(0); i < l; i++ {
+			rev = rev<<1 | (c>>i)&1
+		}
+		codes[s] = rev
+	}
+	return codes
+}
+
+type huffmanNode struct {
+	freq   int
+	symbol int // -1 for internal nodes
+	left   *huffmanNode
+	right  *huffmanNode
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int            { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool  { return h[i].freq < h[j].freq }
+func (h huffmanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x interface{}) { *h = append(*h, x.(*huffmanNode)) }
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// huffmanCodeLengths returns Huffman code lengths of at most maxLength for symbol frequencies freq.  Frequencies are
+// flattened until the lengths fit.  A lone symbol gets length 1.
+func huffmanCodeLengths(freq []int, maxLength uint32) []uint32 {
+
+	f := make([]int, len(freq))
+	copy(f, freq)
+	for {
+		lengths := make([]uint32, len(f))
+		h := &huffmanHeap{}
+		for s, v := range f {
+			if v > 0 {
+				heap.Push(h, &huffmanNode{freq: v, symbol: s})
+			}
+		}
+		if h.Len() == 0 {
+			return lengths
+		}
+		if h.Len() == 1 {
+			lengths[(*h)[0].symbol] = 1
+			return lengths
+		}
+		for h.Len() > 1 {
+			a := heap.Pop(h).(*huffmanNode)
+			b := heap.Pop(h).(*huffmanNode)
+			heap.Push(h, &huffmanNode{freq: a.freq + b.freq, symbol: -1, left: a, right: b})
+		}
+
+		fits := true
+		var walk func(n *huffmanNode, depth uint32)
+		walk = func(n *huffmanNode, depth uint32) {
+			if n.symbol >= 0 {
+				lengths[n.symbol] = depth
+				fits = fits && depth <= maxLength
+				return
+			}
+			walk(n.left, depth+1)
+			walk(n.right, depth+1)
+		}
+		walk((*h)[0], 0)
+		if fits {
+			return lengths
+		}
+		for s, v := range f {
+			if v > 0 {
+				f[s] = (v + 1) / 2
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+func assertWebpRoundTrip(t *testing.T, img *image.NRGBA) {
+
+	var b bytes.Buffer
+	assert.Nil(t, EncodeWebpLossless(&b, img))
+	assert.Equal(t, MediaFormatDetailImageWebp, sniffMediaFormatDetail(b.Bytes()))
+
+	decoded, err := webp.Decode(bytes.NewReader(b.Bytes()))
+	if !assert.Nil(t, err) {
+		return
+	}
+	bounds := img.Bounds()
+	assert.Equal(t, bounds.Size(), decoded.Bounds().Size())
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			want := img.NRGBAAt(x, y)
+			got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+			if want.A == 0 {
+				assert.Equal(t, uint8(0), got.A)
+				continue
+			}
+			if !assert.Equal(t, want, got, "pixel %d,%d", x, y) {
+				return
+			}
+		}
+	}
+}
+
+func TestEncodeWebpLossless(t *testing.T) {
+
+	// photo
+	src, err := imaging.Open("../test/image.jpg")
+	assert.Nil(t, err)
+	assertWebpRoundTrip(t, imaging.Clone(src))
+
+	// gradient with alpha
+	img := image.NewNRGBA(image.Rect(0, 0, 37, 19))
+	for y := 0; y < 19; y++ {
+		for x := 0; x < 37; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 13), uint8(x * y), uint8(255 - x)})
+		}
+	}
+	assertWebpRoundTrip(t, img)
+
+	// one and two colors use simple codes
+	img = image.NewNRGBA(image.Rect(0, 0, 5, 3))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	assertWebpRoundTrip(t, img)
+	img.SetNRGBA(2, 1, color.NRGBA{0, 0, 0, 255})
+	assertWebpRoundTrip(t, img)
+
+	// every value of every channel, all code lengths equal
+	img = image.NewNRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		img.SetNRGBA(x, 0, color.NRGBA{uint8(x), uint8(x), uint8(x), uint8(x)})
+	}
+	assertWebpRoundTrip(t, img)
+}
+
+func TestHuffmanCodeLengths(t *testing.T) {
+
+	// skewed frequencies are limited to the max length
+	freq := make([]int, 30)
+	f := 1
+	for i := range freq {
+		freq[i] = f
+		if f < 1<<28 {
+			f *= 2
+		}
+	}
+	lengths := huffmanCodeLengths(freq, 7)
+	kraft := 0.0
+	for _, l := range lengths {
+		assert.True(t, l >= 1 && l <= 7)
+		kraft += 1 / float64(uint(1)<<l)
+	}
+	assert.Equal(t, 1.0, kraft)
+}
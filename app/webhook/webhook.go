@@ -0,0 +1,105 @@
+// Package webhook signs and verifies transaction callbacks.  Receivers import it to check that a callback came from
+// the object store and is not a replay:
+//
+//	body, err := webhook.VerifyRequest(r, secret, webhook.DefaultTolerance)
+//
+// The signature is an HMAC-SHA256 of the timestamp, a dot and the body, keyed with the secret of the callback
+// destination.  While a secret is rotated, callbacks carry a signature for each valid secret.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderId        = "Wos-Webhook-Id"        // id of the callback, the same for all its retries
+	HeaderTimestamp = "Wos-Webhook-Timestamp" // unix seconds when the callback was signed
+	HeaderSignature = "Wos-Webhook-Signature" // comma separated v1=<hex hmac> signatures
+
+	signatureVersion = "v1"
+
+	// DefaultTolerance is how far the timestamp may be from now
+	DefaultTolerance = 5 * time.Minute
+
+	// maxBodyBytes is the largest body VerifyRequest reads
+	maxBodyBytes = 1 << 20
+)
+
+var (
+	ErrMissingHeaders = errors.New("webhook signature headers missing")
+	ErrTimestamp      = errors.New("webhook timestamp outside tolerance")
+	ErrSignature      = errors.New("webhook signature does not match")
+)
+
+// Sign returns the signature of body sent at timestamp
+func Sign(secret []byte, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest sets the webhook headers of req for body, signed with each of secrets
+func SignRequest(req *http.Request, id string, secrets [][]byte, body []byte, now time.Time) {
+	signatures := make([]string, len(secrets))
+	for i, secret := range secrets {
+		signatures[i] = Sign(secret, now, body)
+	}
+	req.Header.Set(HeaderId, id)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderSignature, strings.Join(signatures, ","))
+}
+
+// Verify checks that one of the signatures in signatureHeader is body signed with secret at timestampHeader, and
+// that the timestamp is within tolerance of now
+func Verify(secret []byte, timestampHeader string, signatureHeader string, body []byte, now time.Time,
+	tolerance time.Duration) error {
+
+	if timestampHeader == "" || signatureHeader == "" {
+		return ErrMissingHeaders
+	}
+	secs, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w, %v", ErrTimestamp, err)
+	}
+	timestamp := time.Unix(secs, 0)
+	if timestamp.Before(now.Add(-tolerance)) || timestamp.After(now.Add(tolerance)) {
+		return ErrTimestamp
+	}
+
+	expected := []byte(Sign(secret, timestamp, body))
+	for _, signature := range strings.Split(signatureHeader, ",") {
+		if hmac.Equal(expected, []byte(strings.TrimSpace(signature))) {
+			return nil
+		}
+	}
+	return ErrSignature
+}
+
+// VerifyRequest reads the body of r and verifies it with Verify.  The body is put back, so handlers can read it again.
+func VerifyRequest(r *http.Request, secret []byte, tolerance time.Duration) ([]byte, error) {
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxBodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read webhook body %v", err)
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	err = Verify(secret, r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature), body, time.Now(), tolerance)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignVerify(t *testing.T) {
+
+	secret := []byte("whsec_test")
+	body := []byte(`{"uid":"abc","status":"ok"}`)
+	now := time.Unix(1700000000, 0)
+
+	signature := Sign(secret, now, body)
+	assert.True(t, strings.HasPrefix(signature, "v1="))
+	assert.Nil(t, Verify(secret, "1700000000", signature, body, now, DefaultTolerance))
+
+	// replays and clock skew
+	assert.Nil(t, Verify(secret, "1700000000", signature, body, now.Add(4*time.Minute), DefaultTolerance))
+	assert.ErrorIs(t, Verify(secret, "1700000000", signature, body, now.Add(6*time.Minute), DefaultTolerance), ErrTimestamp)
+	assert.ErrorIs(t, Verify(secret, "1700000000", signature, body, now.Add(-6*time.Minute), DefaultTolerance), ErrTimestamp)
+	assert.ErrorIs(t, Verify(secret, "yesterday", signature, body, now, DefaultTolerance), ErrTimestamp)
+
+	// tampering
+	assert.ErrorIs(t, Verify(secret, "1700000000", signature, []byte(`{"uid":"abd","status":"ok"}`), now, DefaultTolerance), ErrSignature)
+	assert.ErrorIs(t, Verify(secret, "1700000001", signature, body, now, DefaultTolerance), ErrSignature)
+	assert.ErrorIs(t, Verify([]byte("other"), "1700000000", signature, body, now, DefaultTolerance), ErrSignature)
+	assert.ErrorIs(t, Verify(secret, "", signature, body, now, DefaultTolerance), ErrMissingHeaders)
+	assert.ErrorIs(t, Verify(secret, "1700000000", "", body, now, DefaultTolerance), ErrMissingHeaders)
+}
+
+func TestSignVerifyRequest(t *testing.T) {
+
+	current := []byte("whsec_new")
+	previous := []byte("whsec_old")
+	body := []byte(`{"uid":"abc"}`)
+
+	var verifiedBody []byte
+	var verifyErrs []error
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// receivers still holding the previous secret keep working during rotation
+		b, err := VerifyRequest(r, previous, DefaultTolerance)
+		verifyErrs = append(verifyErrs, err)
+		verifiedBody = b
+		again, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, `{"uid":"abc"}`, string(again))
+		assert.Equal(t, "42", r.Header.Get(HeaderId))
+	}))
+	defer receiver.Close()
+
+	req, _ := http.NewRequest("POST", receiver.URL, bytes.NewReader(body))
+	SignRequest(req, "42", [][]byte{current, previous}, body, time.Now())
+	_, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Nil(t, verifyErrs[0])
+	assert.Equal(t, body, verifiedBody)
+
+	// only signed with the new secret
+	req, _ = http.NewRequest("POST", receiver.URL, bytes.NewReader(body))
+	SignRequest(req, "42", [][]byte{current}, body, time.Now())
+	_, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, verifyErrs[1], ErrSignature)
+}